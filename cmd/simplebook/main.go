@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/rodoufu/simple-orderbook/pkg/engine"
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
 	"github.com/rodoufu/simple-orderbook/pkg/event"
 	"github.com/rodoufu/simple-orderbook/pkg/io"
 )
@@ -25,20 +28,84 @@ func main() {
 	logger.SetOutput(os.Stderr)
 	log := logger.WithFields(logrus.Fields{})
 
+	modeName := flag.String("mode", engine.MatchCrossing.String(), "what to do with orders crossing the book: match or reject")
+	storageName := flag.String("storage", engine.ListStorage.String(), "how the books keep the orders: list or tree")
+	selfTradeName := flag.String(
+		"stp", entity.SelfTradeAllow.String(),
+		"what to do with orders of the same user that would match: STP_ALLOW, STP_CANCEL_NEWEST, STP_CANCEL_OLDEST, "+
+			"STP_CANCEL_BOTH or STP_DECREMENT",
+	)
+	matchingName := flag.String("matching", "fifo", "how the orders of a price level are filled: fifo or pro-rata")
+	minAllocation := flag.Uint64("min-allocation", 0, "smallest share of an order in the pro-rata matching, in units of the quantity scale")
+	topOrder := flag.Bool("top-order", false, "fill the first order of the level before sharing it pro-rata")
+	staticBand := flag.Uint64("static-band", 0, "basis points around the reference price the orders can be priced")
+	dynamicBand := flag.Uint64("dynamic-band", 0, "basis points around the last trade price the matches can trade")
+	breakerName := flag.String(
+		"breaker", engine.BreakerHalt.String(),
+		"what to do when a match would trade outside the dynamic band: halt or auction",
+	)
+	instrumentsFile := flag.String("instruments", "", "file with the instruments used to validate the orders")
+	journalFile := flag.String("journal", "", "file journaling the transactions, replayed on start to recover the books")
+	snapshotFile := flag.String("snapshot", "", "file with the snapshot of the books, restored on start and saved when leaving")
+	snapshotEvery := flag.Uint64("snapshot-every", 0, "transactions between the snapshots, 0 only saves it when leaving")
+	flag.Parse()
+
+	mode, err := engine.ParseMode(*modeName)
+	if err != nil {
+		log.WithError(err).Fatal("problem parsing the mode")
+	}
+	storage, err := engine.ParseStorage(*storageName)
+	if err != nil {
+		log.WithError(err).Fatal("problem parsing the storage")
+	}
+	selfTrade, err := entity.ParseSelfTradePrevention(*selfTradeName)
+	if err != nil {
+		log.WithError(err).Fatal("problem parsing the self-trade prevention")
+	}
+	var policy engine.MatchingPolicy
+	switch *matchingName {
+	case "fifo":
+		policy = engine.FIFO{}
+	case "pro-rata":
+		policy = engine.ProRata{MinAllocation: entity.Decimal(*minAllocation), TopOrderPriority: *topOrder}
+	default:
+		log.WithField("Matching", *matchingName).Fatal("invalid matching policy")
+	}
+	breaker, err := engine.ParseBreakerAction(*breakerName)
+	if err != nil {
+		log.WithError(err).Fatal("problem parsing the breaker action")
+	}
+	bands := engine.PriceBands{
+		Static:  *staticBand,
+		Dynamic: *dynamicBand,
+		Breaker: breaker,
+	}
+	var instruments entity.Instruments
+	if len(*instrumentsFile) > 0 {
+		instruments, err = io.ReadInstruments(*instrumentsFile)
+		if err != nil {
+			log.WithField("FileName", *instrumentsFile).WithError(err).Fatal("problem loading the instruments")
+		}
+	}
 	fileName := "input_file.csv"
-	if len(os.Args) == 2 {
-		fileName = os.Args[1]
+	if flag.NArg() == 1 {
+		fileName = flag.Arg(0)
 	}
-	log.WithField("FileName", fileName).Info("staring service")
+	log.WithField("FileName", fileName).WithField("Mode", mode).WithField("Storage", storage).
+		WithField("SelfTrade", selfTrade).WithField("Matching", *matchingName).Info("staring service")
+	// The same clock timestamps the orders and the trades, and expires the good till time orders.
+	clock := entity.SystemClock
 	// The io.ReadTransactions creates a goroutine to read the file
-	transactions, err := io.ReadTransactions(ctx, fileName)
+	transactions, err := io.ReadTransactions(ctx, fileName, clock, instruments)
 	if err != nil {
 		log.WithField("FileName", fileName).WithError(err).Fatal("problem loading transactions parser")
 	}
 
 	// Writing to stdout in a specific goroutine.
 	toOutput := make(chan event.Output)
+	outputDone := make(chan struct{})
 	go func() {
+		defer close(outputDone)
 		done := ctx.Done()
 		for {
 			select {
@@ -55,9 +122,18 @@ func main() {
 		}
 	}()
 
-	mktEngine, events := engine.NewListEngine()
-	defer mktEngine.Close()
+	opts := []engine.Option{
+		engine.WithMode(mode), engine.WithStorage(storage), engine.WithClock(clock),
+		engine.WithSelfTradePrevention(selfTrade), engine.WithMatchingPolicy(policy), engine.WithPriceBands(bands),
+		engine.WithInstruments(instruments),
+	}
+	mktEngine, events, err := openEngine(ctx, *journalFile, *snapshotFile, opts)
+	if err != nil {
+		log.WithField("Journal", *journalFile).WithField("Snapshot", *snapshotFile).WithError(err).
+			Fatal("problem recovering the engine")
+	}
 	go func() {
+		defer close(toOutput)
 		done := ctx.Done()
 		for {
 			select {
@@ -74,9 +150,92 @@ func main() {
 		}
 	}()
 
+	var processed uint64
+	journalFailed := false
 	for transaction := range transactions {
-		if err = mktEngine.ProcessTransaction(ctx, transaction); err != nil {
+		err = mktEngine.ProcessTransaction(ctx, transaction)
+		if errors.Is(err, io.JournalFailedError) {
+			// The next transactions could not be recovered after a crash, so none is processed.
+			log.WithField("Journal", *journalFile).WithError(err).Error("problem journaling the transactions")
+			journalFailed = true
+			break
+		} else if err != nil {
 			log.WithError(err).Error("problem processing transaction")
 		}
+		processed++
+		if len(*snapshotFile) > 0 && *snapshotEvery > 0 && processed%*snapshotEvery == 0 {
+			if err = saveSnapshot(ctx, mktEngine, *snapshotFile); err != nil {
+				log.WithField("FileName", *snapshotFile).WithError(err).Error("problem saving the snapshot")
+			}
+		}
+	}
+	if len(*snapshotFile) > 0 && !journalFailed {
+		if err = saveSnapshot(ctx, mktEngine, *snapshotFile); err != nil {
+			log.WithField("FileName", *snapshotFile).WithError(err).Error("problem saving the snapshot")
+		}
+	}
+
+	// Closing the engine flushes the pending events, waiting for them to be written before leaving.
+	if err = mktEngine.Close(); err != nil {
+		log.WithError(err).Error("problem closing the engine")
+	}
+	<-outputDone
+	if journalFailed {
+		os.Exit(1)
+	}
+}
+
+// openEngine creates the engine, starting from the snapshot and replaying the journal when they are given.
+func openEngine(
+	ctx context.Context, journalFile, snapshotFile string, opts []engine.Option,
+) (engine.MatchingEngine, <-chan event.Event, error) {
+	newEngine := func(opts ...engine.Option) (engine.MatchingEngine, <-chan event.Event) {
+		return engine.NewSymbolEngine(opts...)
+	}
+	var snapshot *os.File
+	if len(snapshotFile) > 0 {
+		file, err := os.Open(snapshotFile)
+		if err == nil {
+			defer file.Close()
+			snapshot = file
+		} else if !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+	}
+
+	if len(journalFile) > 0 {
+		if snapshot == nil {
+			return engine.OpenJournaledEngine(ctx, journalFile, newEngine, opts...)
+		}
+		return engine.RestoreJournaledEngine(ctx, journalFile, snapshot, newEngine, opts...)
+	}
+	mktEngine, events := newEngine(opts...)
+	if snapshot != nil {
+		if err := mktEngine.Restore(ctx, snapshot); err != nil {
+			_ = mktEngine.Close()
+			return nil, nil, err
+		}
+	}
+	return mktEngine, events, nil
+}
+
+// saveSnapshot writes the snapshot to a temporary file first, so a crash while writing keeps the previous one.
+func saveSnapshot(ctx context.Context, mktEngine engine.MatchingEngine, fileName string) error {
+	tmpName := fileName + ".tmp"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	if err = mktEngine.Snapshot(ctx, file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
 	}
+	return os.Rename(tmpName, fileName)
 }
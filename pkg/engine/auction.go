@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+// uncrossing is the outcome of the call auction at a price.
+type uncrossing struct {
+	price  entity.Decimal
+	volume entity.Decimal
+	// buyVolume and sellVolume are the amounts willing to trade at the price.
+	buyVolume  entity.Decimal
+	sellVolume entity.Decimal
+}
+
+// surplus is the amount left on the side with more volume at the price.
+func (u uncrossing) surplus() entity.Decimal {
+	if u.buyVolume > u.sellVolume {
+		return u.buyVolume - u.sellVolume
+	}
+	return u.sellVolume - u.buyVolume
+}
+
+// auctionLevels gives the amount of each price of the side, hidden amounts included, from the best price to the worst.
+func auctionLevels(b book, side entity.Side) ([]entity.Decimal, []entity.Decimal) {
+	var prices, amounts []entity.Decimal
+	b.walk(side, func(order *entity.Order) bool {
+		if len(prices) == 0 || prices[len(prices)-1] != order.Price {
+			prices = append(prices, order.Price)
+			amounts = append(amounts, 0)
+		}
+		amounts[len(amounts)-1] += order.Amount + order.HiddenAmount
+		return true
+	})
+	return prices, amounts
+}
+
+// findUncrossing gives the price that trades the most volume, breaking ties by the smallest surplus, then by the
+// market pressure, and at last by the distance to the reference price.
+// The volume is zero when the book does not cross.
+func findUncrossing(b book) uncrossing {
+	buyPrices, buyAmounts := auctionLevels(b, entity.Buy)
+	sellPrices, sellAmounts := auctionLevels(b, entity.Sell)
+	if len(buyPrices) == 0 || len(sellPrices) == 0 || buyPrices[0] < sellPrices[0] {
+		return uncrossing{}
+	}
+
+	// Only the prices between the best sell and the best buy can trade.
+	lowest, highest := sellPrices[0], buyPrices[0]
+	var candidates []entity.Decimal
+	for _, prices := range [][]entity.Decimal{buyPrices, sellPrices} {
+		for _, price := range prices {
+			if price >= lowest && price <= highest {
+				candidates = append(candidates, price)
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i] < candidates[j]
+	})
+
+	var buyVolume, sellVolume entity.Decimal
+	for i, price := range buyPrices {
+		if price >= lowest {
+			buyVolume += buyAmounts[i]
+		}
+	}
+	buyIndex, sellIndex := len(buyPrices)-1, 0
+	var tied []uncrossing
+	for i, price := range candidates {
+		if i > 0 && price == candidates[i-1] {
+			continue
+		}
+		for ; buyIndex >= 0 && buyPrices[buyIndex] < price; buyIndex-- {
+			if buyPrices[buyIndex] >= lowest {
+				buyVolume -= buyAmounts[buyIndex]
+			}
+		}
+		for ; sellIndex < len(sellPrices) && sellPrices[sellIndex] <= price; sellIndex++ {
+			sellVolume += sellAmounts[sellIndex]
+		}
+		current := uncrossing{
+			price:      price,
+			volume:     minAmount(buyVolume, sellVolume),
+			buyVolume:  buyVolume,
+			sellVolume: sellVolume,
+		}
+		switch {
+		case len(tied) == 0 || current.volume > tied[0].volume ||
+			(current.volume == tied[0].volume && current.surplus() < tied[0].surplus()):
+			tied = []uncrossing{current}
+		case current.volume == tied[0].volume && current.surplus() == tied[0].surplus():
+			tied = append(tied, current)
+		}
+	}
+	return breakUncrossingTie(tied, b.state().lastPrice)
+}
+
+// breakUncrossingTie picks the highest price when the buy side has the surplus in all the tied prices, the lowest
+// price when it is the sell side, and otherwise the closest price to the reference one, the lowest of them.
+func breakUncrossingTie(tied []uncrossing, reference entity.Decimal) uncrossing {
+	buyPressure, sellPressure := true, true
+	for _, it := range tied {
+		buyPressure = buyPressure && it.buyVolume > it.sellVolume
+		sellPressure = sellPressure && it.sellVolume > it.buyVolume
+	}
+	switch {
+	case buyPressure:
+		return tied[len(tied)-1]
+	case sellPressure:
+		return tied[0]
+	}
+
+	distance := func(price entity.Decimal) entity.Decimal {
+		if price > reference {
+			return price - reference
+		}
+		return reference - price
+	}
+	resp := tied[0]
+	for _, it := range tied[1:] {
+		if distance(it.price) < distance(resp.price) {
+			resp = it
+		}
+	}
+	return resp
+}
+
+// publishIndicative lets the consumers know the uncrossing of the call auction, when it changed or always is true.
+func publishIndicative(b book, always bool) {
+	state := b.state()
+	current := findUncrossing(b)
+	if !always && current.price == state.indicative.price && current.volume == state.indicative.volume {
+		return
+	}
+	state.indicative = current
+	b.publish(&event.AuctionIndicative{
+		Symbol: b.config().symbol,
+		Price:  current.price,
+		Volume: current.volume,
+		Scale:  b.config().scale(),
+	})
+}
+
+// changeSession moves the book to the session state, nothing happens when the book is already in it.
+// Moving to the continuous trading uncrosses the orders collected before.
+func changeSession(b book, session entity.SessionState) {
+	state := b.state()
+	if state.session == session {
+		return
+	}
+	expireOrders(b)
+	previous := state.session
+	state.session = session
+	b.publish(&event.SessionChanged{
+		Symbol:   b.config().symbol,
+		Previous: previous,
+		Session:  session,
+	})
+	switch session {
+	case entity.Auction:
+		publishIndicative(b, true)
+	case entity.Continuous:
+		uncrossAuction(b, previous)
+	}
+}
+
+// collectOrder adds the order to the book without matching it, while the call auction is open.
+// Only the orders that can wait for the uncross are accepted.
+func collectOrder(b book, order entity.Order, acknowledge bool) error {
+	cfg := b.config()
+	if order.Type == entity.Market ||
+		(order.TimeInForce != entity.GoodTillCancel && order.TimeInForce != entity.GoodTillTime) {
+		b.publish(&event.OrderRejected{
+			Symbol: cfg.symbol,
+			Order:  order,
+			Reason: event.RejectAuction,
+		})
+		return nil
+	}
+	if acknowledge {
+		order.ExchangeID = nextExchangeID(b)
+		b.publish(&event.OrderAcknowledge{
+			Symbol: cfg.symbol,
+			Order:  order,
+		})
+	}
+	if expired(order, cfg.timeSource().Now()) {
+		b.publish(&event.OrderCancelled{
+			Symbol: cfg.symbol,
+			Order:  order,
+			Reason: event.CancelExpired,
+		})
+		return nil
+	}
+	restOrder(b, order)
+	return nil
+}
+
+// uncrossAuction trades all the crossing orders at the uncrossing price in price and time priority, once the book
+// moves to the continuous trading.
+// The dynamic band does not apply, the uncrossing price becomes its reference as the last trade price.
+func uncrossAuction(b book, previous entity.SessionState) {
+	before := topLevels(b)
+	result := findUncrossing(b)
+	cfg := b.config()
+	for remaining := result.volume; remaining > 0; {
+		buy, sell := b.best(entity.Buy), b.best(entity.Sell)
+		amount := minAmount(remaining, minAmount(buy.Amount, sell.Amount))
+		incoming := *buy
+		incoming.Amount = amount
+		_, trade := incoming.Match(sell, cfg.timeSource())
+		trade.Price = result.price
+		trade.Notional = entity.Notional(trade.Price, trade.Amount)
+		publishTrade(b, *trade)
+
+		sellKey := sell.Key()
+		fillOrder(b, buy, *trade)
+		fillOrder(b, b.find(sellKey), *trade)
+		remaining -= amount
+	}
+
+	b.state().indicative = uncrossing{}
+	if previous.Collecting() || result.volume > 0 {
+		b.publish(&event.AuctionUncrossed{
+			Symbol: cfg.symbol,
+			Price:  result.price,
+			Volume: result.volume,
+			Scale:  cfg.scale(),
+		})
+	}
+	publishBookChanges(b, before)
+	triggerStops(b)
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+func Test_findUncrossing(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		orders     []entity.Order
+		lastPrice  entity.Decimal
+		wantPrice  entity.Decimal
+		wantVolume entity.Decimal
+	}{
+		{
+			name: "book does not cross",
+			orders: []entity.Order{
+				{Amount: 10, Price: 99, ID: 1, Side: entity.Buy, User: 1},
+				{Amount: 10, Price: 100, ID: 2, Side: entity.Sell, User: 2},
+			},
+		},
+		{
+			name: "maximum volume",
+			orders: []entity.Order{
+				{Amount: 10, Price: 102, ID: 1, Side: entity.Buy, User: 1},
+				{Amount: 5, Price: 101, ID: 2, Side: entity.Buy, User: 1},
+				{Amount: 8, Price: 100, ID: 3, Side: entity.Sell, User: 2},
+				{Amount: 6, Price: 101, ID: 4, Side: entity.Sell, User: 2},
+			},
+			wantPrice:  101,
+			wantVolume: 14,
+		},
+		{
+			name: "minimum surplus, then sell pressure",
+			orders: []entity.Order{
+				{Amount: 10, Price: 102, ID: 1, Side: entity.Buy, User: 1},
+				{Amount: 2, Price: 100, ID: 2, Side: entity.Buy, User: 1},
+				{Amount: 10, Price: 100, ID: 3, Side: entity.Sell, User: 2},
+				{Amount: 1, Price: 101, ID: 4, Side: entity.Sell, User: 2},
+			},
+			wantPrice:  101,
+			wantVolume: 10,
+		},
+		{
+			name: "buy pressure",
+			orders: []entity.Order{
+				{Amount: 15, Price: 102, ID: 1, Side: entity.Buy, User: 1},
+				{Amount: 10, Price: 100, ID: 2, Side: entity.Sell, User: 2},
+			},
+			wantPrice:  102,
+			wantVolume: 10,
+		},
+		{
+			name: "sell pressure",
+			orders: []entity.Order{
+				{Amount: 10, Price: 102, ID: 1, Side: entity.Buy, User: 1},
+				{Amount: 15, Price: 100, ID: 2, Side: entity.Sell, User: 2},
+			},
+			wantPrice:  100,
+			wantVolume: 10,
+		},
+		{
+			name: "closest to the reference price",
+			orders: []entity.Order{
+				{Amount: 10, Price: 102, ID: 1, Side: entity.Buy, User: 1},
+				{Amount: 10, Price: 100, ID: 2, Side: entity.Sell, User: 2},
+			},
+			lastPrice:  105,
+			wantPrice:  102,
+			wantVolume: 10,
+		},
+		{
+			name: "same distance to the reference price",
+			orders: []entity.Order{
+				{Amount: 10, Price: 102, ID: 1, Side: entity.Buy, User: 1},
+				{Amount: 10, Price: 100, ID: 2, Side: entity.Sell, User: 2},
+			},
+			lastPrice:  101,
+			wantPrice:  100,
+			wantVolume: 10,
+		},
+		{
+			name: "hidden amounts count",
+			orders: []entity.Order{
+				{Amount: 20, Price: 102, ID: 1, Side: entity.Buy, User: 1, DisplayAmount: 5},
+				{Amount: 15, Price: 100, ID: 2, Side: entity.Sell, User: 2},
+			},
+			wantPrice:  102,
+			wantVolume: 15,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			engine := newListEngine(make(chan event.Event, 50))
+			engine.lastPrice = tt.lastPrice
+			if err := engine.ChangeSession(ctx, entity.Auction); err != nil {
+				t.Fatalf("ChangeSession() error = %v", err)
+			}
+			for _, order := range tt.orders {
+				if err := engine.AddOrder(ctx, order); err != nil {
+					t.Errorf("AddOrder(%v) error = %v", order.ID, err)
+				}
+			}
+			if got := findUncrossing(engine); got.price != tt.wantPrice || got.volume != tt.wantVolume {
+				t.Errorf("findUncrossing() = %v, %v, want %v, %v", got.price, got.volume, tt.wantPrice, tt.wantVolume)
+			}
+		})
+	}
+}
+
+func Test_callAuction(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	for _, storage := range []Storage{ListStorage, TreeStorage} {
+		events := make(chan event.Event, 50)
+		var engine MatchingEngine = newListEngine(events)
+		if storage == TreeStorage {
+			engine = newTreeEngine(events)
+		}
+		addOrder := func(order entity.Order) {
+			if err := engine.AddOrder(ctx, order); err != nil {
+				t.Errorf("%v AddOrder(%v) error = %v", storage, order.ID, err)
+			}
+		}
+
+		changeSession := func(session entity.SessionState) {
+			if err := engine.ChangeSession(ctx, session); err != nil {
+				t.Errorf("%v ChangeSession(%v) error = %v", storage, session, err)
+			}
+		}
+
+		changeSession(entity.Auction)
+		// Nothing happens when the book is already in the session.
+		changeSession(entity.Auction)
+		addOrder(entity.Order{Amount: 10, Price: 100, ID: 1, Side: entity.Sell, User: 1})
+		addOrder(entity.Order{Amount: 6, Price: 102, ID: 2, Side: entity.Buy, User: 2})
+		addOrder(entity.Order{Amount: 8, Price: 101, ID: 3, Side: entity.Buy, User: 3})
+		// Market orders cannot wait for the uncross.
+		addOrder(entity.Order{Amount: 5, ID: 4, Side: entity.Buy, User: 4, Type: entity.Market})
+		changeSession(entity.Continuous)
+		// The book is back to the continuous trading.
+		addOrder(entity.Order{Amount: 4, Price: 101, ID: 5, Side: entity.Sell, User: 5})
+		engine.Close()
+
+		wantEvents := []string{
+			"S, AUCTION",
+			"I, -, -",
+			"A, 1, 1",
+			"B, S, 100, 10",
+			"A, 2, 2",
+			"B, B, 102, 6",
+			"I, 100, 6",
+			"A, 3, 3",
+			"I, 101, 10",
+			"R, 4, 4",
+			"S, CONTINUOUS",
+			"T, 2, 2, 1, 1, 101, 6",
+			"T, 3, 3, 1, 1, 101, 4",
+			"B, B, 101, 4",
+			"B, S, -, -",
+			"A, 5, 5",
+			"T, 3, 3, 5, 5, 101, 4",
+			"B, B, -, -",
+		}
+		if gotEvents := describeEvents(events); !reflect.DeepEqual(gotEvents, wantEvents) {
+			t.Errorf("%v events: %v, want: %v", storage, gotEvents, wantEvents)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+)
+
+// basisPoints is the scale of the price bands, a band of 100 is 1% of the reference price.
+const basisPoints = 10_000
+
+// BreakerAction defines what the engine does when a match would trade outside the dynamic band.
+type BreakerAction uint8
+
+const (
+	// BreakerHalt halts the book, it only accepts cancels until the session is changed, it is the default action.
+	BreakerHalt BreakerAction = iota
+	// BreakerAuction sends the book to a volatility auction, collecting the orders until the session is changed.
+	BreakerAuction BreakerAction = iota
+)
+
+func (a BreakerAction) String() string {
+	switch a {
+	case BreakerHalt:
+		return "halt"
+	case BreakerAuction:
+		return "auction"
+	default:
+		return fmt.Sprintf("invalid breaker action (%v)", uint8(a))
+	}
+}
+
+// ParseBreakerAction gives the BreakerAction for its name.
+func ParseBreakerAction(name string) (BreakerAction, error) {
+	for _, action := range []BreakerAction{BreakerHalt, BreakerAuction} {
+		if action.String() == name {
+			return action, nil
+		}
+	}
+	return BreakerHalt, fmt.Errorf("invalid breaker action: %v", name)
+}
+
+// session gives the session state the book moves to when the breaker trips.
+func (a BreakerAction) session() entity.SessionState {
+	if a == BreakerAuction {
+		return entity.Auction
+	}
+	return entity.Halted
+}
+
+// PriceBands protects the book against orders trading far from the expected prices.
+// The bands are given in basis points of their reference price, a zero band is disabled.
+// The reference price of each symbol comes from its instrument, or from the last trade of its book.
+type PriceBands struct {
+	// Static is how far from the reference price the limit orders can be priced, orders outside it are rejected.
+	Static uint64
+	// Dynamic is how far from the last trade price a match can trade, the reference price of the instrument is used
+	// before the first trade.
+	// The uncross of a call auction discovers a new price, so it is not checked and becomes the new reference.
+	Dynamic uint64
+	// Breaker is what happens when a match would trade outside the dynamic band.
+	Breaker BreakerAction
+}
+
+// withinBand checks the price is at most band basis points away from the reference price.
+// Without a reference or a band every price is accepted.
+func withinBand(price, reference entity.Decimal, band uint64) bool {
+	if reference == 0 || band == 0 {
+		return true
+	}
+	// The width is computed in 128 bits, so large prices do not overflow.
+	hi, lo := bits.Mul64(uint64(reference), band)
+	if hi >= basisPoints {
+		return true
+	}
+	quotient, _ := bits.Div64(hi, lo, basisPoints)
+	width := entity.Decimal(quotient)
+	if price < reference {
+		return reference-price <= width
+	}
+	return price-reference <= width || width > entity.MaxDecimal-reference
+}
+
+// referencePrice gives the reference price of the instrument of the symbol, zero when it has none.
+func referencePrice(cfg *options, symbol string) entity.Decimal {
+	return cfg.instruments[symbol].ReferencePrice
+}
+
+// withinStaticBand checks the price of a limit order of the symbol against the static band, market orders have no
+// price to check.
+func withinStaticBand(b book, symbol string, price entity.Decimal) bool {
+	cfg := b.config()
+	reference := referencePrice(cfg, symbol)
+	if reference == 0 {
+		reference = b.state().lastPrice
+	}
+	return price == 0 || withinBand(price, reference, cfg.bands.Static)
+}
+
+// withinDynamicBand checks a match of the symbol at the price against the dynamic band.
+func withinDynamicBand(b book, symbol string, price entity.Decimal) bool {
+	cfg := b.config()
+	reference := b.state().lastPrice
+	if reference == 0 {
+		reference = referencePrice(cfg, symbol)
+	}
+	return withinBand(price, reference, cfg.bands.Dynamic)
+}
+
+// tripBreaker stops the continuous trading of the book, as the dynamic band says.
+// It is called once the match is over, so the session changes after the events of the match.
+func tripBreaker(b book) {
+	changeSession(b, b.config().bands.Breaker.session())
+}
@@ -0,0 +1,270 @@
+package engine
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+	"github.com/rodoufu/simple-orderbook/pkg/io"
+)
+
+func Test_withinBand(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		price     entity.Decimal
+		reference entity.Decimal
+		band      uint64
+		want      bool
+	}{
+		{name: "no reference", price: 1000, band: 100, want: true},
+		{name: "no band", price: 1000, reference: 100, want: true},
+		{name: "at the reference", price: 100, reference: 100, band: 100, want: true},
+		{name: "at the upper limit", price: 110, reference: 100, band: 1000, want: true},
+		{name: "above the upper limit", price: 111, reference: 100, band: 1000, want: false},
+		{name: "at the lower limit", price: 90, reference: 100, band: 1000, want: true},
+		{name: "below the lower limit", price: 89, reference: 100, band: 1000, want: false},
+		{
+			name:      "large prices do not overflow",
+			price:     math.MaxUint64,
+			reference: math.MaxUint64 - 10,
+			band:      1,
+			want:      true,
+		},
+		{
+			name:      "band wider than the prices",
+			price:     math.MaxUint64,
+			reference: math.MaxUint64 / 2,
+			band:      math.MaxUint64,
+			want:      true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := withinBand(tt.price, tt.reference, tt.band); got != tt.want {
+				t.Errorf("withinBand(%v, %v, %v) = %v, want %v", tt.price, tt.reference, tt.band, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_priceBands(t *testing.T) {
+	t.Parallel()
+	// The static band goes from 90 to 110, and the dynamic band is 5% of the last trade price.
+	bands := PriceBands{Static: 1000, Dynamic: 500}
+	instruments := entity.Instruments{"BTC": {Symbol: "BTC", ReferencePrice: 100}}
+	book := []io.Transaction{
+		io.NewOrderTransaction{Order: entity.Order{Amount: 5, Price: 100, ID: 1, Side: entity.Sell, User: 1}},
+		io.NewOrderTransaction{Order: entity.Order{Amount: 5, Price: 103, ID: 2, Side: entity.Sell, User: 2}},
+		io.NewOrderTransaction{Order: entity.Order{Amount: 5, Price: 110, ID: 3, Side: entity.Sell, User: 3}},
+	}
+	bookEvents := []string{
+		"A, 1, 1",
+		"B, S, 100, 5",
+		"A, 2, 2",
+		"A, 3, 3",
+	}
+	sweep := io.NewOrderTransaction{Order: entity.Order{Amount: 15, Price: 110, ID: 4, Side: entity.Buy, User: 4}}
+	sweepEvents := []string{
+		"A, 4, 4",
+		"T, 4, 4, 1, 1, 100, 5",
+		"T, 4, 4, 2, 2, 103, 5",
+	}
+	tests := []struct {
+		name         string
+		breaker      BreakerAction
+		transactions []io.Transaction
+		wantEvents   []string
+	}{
+		{
+			name: "static band rejects the order",
+			transactions: []io.Transaction{
+				io.NewOrderTransaction{Order: entity.Order{Amount: 5, Price: 111, ID: 4, Side: entity.Buy, User: 4}},
+				io.NewOrderTransaction{Order: entity.Order{Amount: 5, Price: 89, ID: 5, Side: entity.Sell, User: 5}},
+				// Market orders have no price, only the dynamic band applies.
+				io.NewOrderTransaction{
+					Order: entity.Order{Amount: 5, ID: 6, Side: entity.Buy, User: 6, Type: entity.Market},
+				},
+			},
+			wantEvents: []string{
+				"R, 4, 4",
+				"R, 5, 5",
+				"A, 6, 6",
+				"T, 6, 6, 1, 1, 100, 5",
+				"B, S, 103, 5",
+			},
+		},
+		{
+			name: "static band rejects the replace",
+			transactions: []io.Transaction{
+				io.ReplaceOrderTransaction{User: 1, OrderID: 1, Price: 120, Amount: 5},
+			},
+			wantEvents: []string{
+				"R, 1, 1",
+			},
+		},
+		{
+			name: "dynamic band halts the book",
+			transactions: []io.Transaction{
+				sweep,
+				io.NewOrderTransaction{Order: entity.Order{Amount: 5, Price: 100, ID: 5, Side: entity.Sell, User: 5}},
+				// The rest of the order that tripped the breaker is not left crossing the book, so nothing trades
+				// outside the dynamic band when the book resumes.
+				io.SessionTransaction{Session: entity.Continuous},
+			},
+			wantEvents: append(sweepEvents,
+				"cancelled 4, price band",
+				"B, S, 110, 5",
+				"S, BTC, HALTED",
+				"R, 5, 5",
+				"S, BTC, CONTINUOUS",
+			),
+		},
+		{
+			name: "triggered stops wait while the book is halted",
+			transactions: []io.Transaction{
+				io.NewOrderTransaction{Order: entity.Order{
+					Amount: 10, ID: 5, Side: entity.Buy, User: 5, Type: entity.Market, StopPrice: 100,
+				}},
+				io.NewOrderTransaction{Order: entity.Order{
+					Amount: 1, Price: 105, ID: 6, Side: entity.Buy, User: 6, StopPrice: 100,
+				}},
+				io.NewOrderTransaction{Order: entity.Order{Amount: 1, Price: 100, ID: 7, Side: entity.Buy, User: 7}},
+				io.SessionTransaction{Session: entity.Continuous},
+			},
+			wantEvents: []string{
+				"A, 5, 5",
+				"A, 6, 6",
+				"A, 7, 7",
+				"T, 7, 7, 1, 1, 100, 1",
+				"B, S, 100, 4",
+				"T, 5, 5, 1, 1, 100, 4",
+				"T, 5, 5, 2, 2, 103, 5",
+				"cancelled 5, price band",
+				"B, S, 110, 5",
+				"S, BTC, HALTED",
+				"S, BTC, CONTINUOUS",
+				"B, B, 105, 1",
+			},
+		},
+		{
+			name:    "dynamic band starts a volatility auction",
+			breaker: BreakerAuction,
+			transactions: []io.Transaction{
+				sweep,
+				io.SessionTransaction{Session: entity.Continuous},
+			},
+			wantEvents: append(sweepEvents,
+				"B, B, 110, 5",
+				"B, S, 110, 5",
+				"S, BTC, AUCTION",
+				"I, 110, 5",
+				"S, BTC, CONTINUOUS",
+				"T, 4, 4, 3, 3, 110, 5",
+				"B, B, -, -",
+				"B, S, -, -",
+			),
+		},
+		{
+			name: "fill or kill does not trade outside the dynamic band",
+			transactions: []io.Transaction{
+				io.NewOrderTransaction{Order: entity.Order{
+					Amount: 15, Price: 110, ID: 4, Side: entity.Buy, User: 4, TimeInForce: entity.FillOrKill,
+				}},
+			},
+			wantEvents: []string{
+				"A, 4, 4",
+				"cancelled 4, no liquidity",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			bands := bands
+			bands.Breaker = tt.breaker
+			for _, storage := range []Storage{ListStorage, TreeStorage} {
+				events := make(chan event.Event, 50)
+				opts := []Option{withSymbol("BTC"), WithPriceBands(bands), WithInstruments(instruments)}
+				var engine MatchingEngine = newListEngine(events, opts...)
+				if storage == TreeStorage {
+					engine = newTreeEngine(events, opts...)
+				}
+				for i, transaction := range append(append([]io.Transaction{}, book...), tt.transactions...) {
+					if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+						t.Errorf("%v ProcessTransaction(%d) error = %v", storage, i, err)
+					}
+				}
+				engine.Close()
+				wantEvents := append(append([]string{}, bookEvents...), tt.wantEvents...)
+				if gotEvents := describeEvents(events); !reflect.DeepEqual(gotEvents, wantEvents) {
+					t.Errorf("%v events: %v, want: %v", storage, gotEvents, wantEvents)
+				}
+			}
+		})
+	}
+}
+
+func Test_symbolEngine_referencePrice(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	// Each symbol has its own static band, IBM without a reference price is only checked after its first trade.
+	instruments := entity.Instruments{
+		"AAPL": {Symbol: "AAPL", ReferencePrice: 100},
+		"MSFT": {Symbol: "MSFT", ReferencePrice: 1000},
+		"IBM":  {Symbol: "IBM"},
+	}
+	engine, events := NewSymbolEngine(WithPriceBands(PriceBands{Static: 1000}), WithInstruments(instruments))
+	gotEventsCh := make(chan []string)
+	go func() {
+		gotEventsCh <- toListEventsOutput(ctx, events)
+	}()
+	newOrder := func(symbol string, id uint64, side entity.Side, price entity.Decimal) io.Transaction {
+		return io.NewOrderTransaction{
+			Symbol: symbol,
+			Order: entity.Order{
+				Symbol: symbol, Amount: 5, Price: price, ID: entity.OrderID(id), Side: side, User: entity.UserID(id),
+			},
+		}
+	}
+	transactions := []io.Transaction{
+		newOrder("AAPL", 1, entity.Sell, 105),
+		newOrder("AAPL", 2, entity.Sell, 1005),
+		newOrder("MSFT", 3, entity.Sell, 1005),
+		newOrder("MSFT", 4, entity.Sell, 105),
+		newOrder("IBM", 5, entity.Sell, 50),
+		newOrder("IBM", 6, entity.Buy, 50),
+		newOrder("IBM", 7, entity.Sell, 60),
+	}
+	for i, transaction := range transactions {
+		if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+			t.Errorf("ProcessTransaction(%d) error = %v", i, err)
+		}
+	}
+	engine.Close()
+
+	wantEvents := []string{
+		"A, 1, 1",
+		"B, S, 105, 5",
+		"R, 2, 2",
+		"A, 3, 3",
+		"B, S, 1005, 5",
+		"R, 4, 4",
+		"A, 5, 5",
+		"B, S, 50, 5",
+		"A, 6, 6",
+		"T, 6, 6, 5, 5, 50, 5",
+		"B, S, -, -",
+		"R, 7, 7",
+	}
+	if gotEvents := <-gotEventsCh; !reflect.DeepEqual(gotEvents, wantEvents) {
+		t.Errorf("events: %v, want: %v", gotEvents, wantEvents)
+	}
+}
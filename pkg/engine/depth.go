@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+// touchLevel keeps the amount of the price level of the order before its first change, so only the levels changed are
+// compared when the depth updates are published.
+// Nothing is kept for books without depth updates or for orders not in the book.
+func touchLevel(b book, order *entity.Order) {
+	if order == nil || !b.config().depthUpdates {
+		return
+	}
+	state := b.state()
+	if state.touched == nil {
+		state.touched = map[entity.Side]map[entity.Decimal]entity.Decimal{
+			entity.Buy:  {},
+			entity.Sell: {},
+		}
+	}
+	if _, ok := state.touched[order.Side][order.Price]; !ok {
+		state.touched[order.Side][order.Price] = b.levelQuantity(order.Side, order.Price)
+	}
+}
+
+// publishDepthChanges compares the levels touched with their amount before the change, publishing an update for every
+// price level added, changed or deleted, from the best price of each side.
+func publishDepthChanges(b book) {
+	state := b.state()
+	touched := state.touched
+	state.touched = nil
+	cfg := b.config()
+	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
+		prices := make([]entity.Decimal, 0, len(touched[side]))
+		for price := range touched[side] {
+			prices = append(prices, price)
+		}
+		// The best buy prices are the highest ones.
+		sort.Slice(prices, func(i, j int) bool {
+			if side == entity.Buy {
+				return prices[i] > prices[j]
+			}
+			return prices[i] < prices[j]
+		})
+
+		for _, price := range prices {
+			previous := touched[side][price]
+			quantity := b.levelQuantity(side, price)
+			update := &event.DepthUpdate{
+				Symbol:        cfg.symbol,
+				Side:          side,
+				Price:         price,
+				TotalQuantity: quantity,
+				Scale:         cfg.scale(),
+			}
+			switch {
+			case previous == quantity:
+				continue
+			case previous == 0:
+				update.Action = event.DepthNew
+			case quantity == 0:
+				update.Action = event.DepthDelete
+			default:
+				update.Action = event.DepthChange
+			}
+			b.publish(update)
+		}
+	}
+}
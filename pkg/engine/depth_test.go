@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+	"github.com/rodoufu/simple-orderbook/pkg/io"
+	"github.com/rodoufu/simple-orderbook/pkg/orderbook"
+)
+
+// depthTransactions go through every kind of level change, the last one leaves the book with wantDepth.
+func depthTransactions() []io.Transaction {
+	newOrder := func(order entity.Order) io.Transaction {
+		order.User = entity.UserID(order.ID)
+		order.Symbol = "IBM"
+		return io.NewOrderTransaction{Symbol: order.Symbol, Order: order}
+	}
+	return []io.Transaction{
+		newOrder(entity.Order{Amount: 10, Price: 12, ID: 1, Side: entity.Sell}),
+		newOrder(entity.Order{Amount: 5, Price: 12, ID: 2, Side: entity.Sell}),
+		newOrder(entity.Order{Amount: 4, Price: 13, ID: 3, Side: entity.Sell}),
+		newOrder(entity.Order{Amount: 7, Price: 10, ID: 4, Side: entity.Buy}),
+		newOrder(entity.Order{Amount: 10, Price: 9, ID: 5, Side: entity.Buy, DisplayAmount: 2, HiddenAmount: 8}),
+		// Takes the whole level 12 and part of the level 13.
+		newOrder(entity.Order{Amount: 16, Price: 13, ID: 6, Side: entity.Buy}),
+		io.ReplaceOrderTransaction{User: 4, OrderID: 4, Price: 11, Amount: 3},
+		io.CancelOrderTransaction{User: 3, OrderID: 3},
+		newOrder(entity.Order{Amount: 6, Price: 14, ID: 7, Side: entity.Sell}),
+	}
+}
+
+var wantDepth = map[entity.Side]map[entity.Decimal]entity.Decimal{
+	entity.Buy:  {11: 3, 9: 2},
+	entity.Sell: {14: 6},
+}
+
+func Test_depthUpdates(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		newEngine Constructor
+		opts      []Option
+		wantDepth map[entity.Side]map[entity.Decimal]entity.Decimal
+	}{
+		{name: "list", newEngine: NewListEngine, opts: []Option{WithDepthUpdates()}, wantDepth: wantDepth},
+		{name: "tree", newEngine: NewTreeEngine, opts: []Option{WithDepthUpdates()}, wantDepth: wantDepth},
+		{
+			name: "symbol",
+			newEngine: func(opts ...Option) (MatchingEngine, <-chan event.Event) {
+				return NewSymbolEngine(opts...)
+			},
+			wantDepth: wantDepth,
+		},
+		{
+			name:      "without depth updates",
+			newEngine: NewListEngine,
+			wantDepth: map[entity.Side]map[entity.Decimal]entity.Decimal{entity.Buy: {}, entity.Sell: {}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			engine, events := tt.newEngine(tt.opts...)
+			updatesCh := make(chan []event.DepthUpdate)
+			go func() {
+				var resp []event.DepthUpdate
+				for evt := range events {
+					if update, ok := evt.(*event.DepthUpdate); ok {
+						resp = append(resp, *update)
+					}
+				}
+				updatesCh <- resp
+			}()
+			for i, transaction := range depthTransactions() {
+				if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+					t.Errorf("ProcessTransaction(%d) error = %v", i, err)
+				}
+			}
+			engine.Close()
+
+			got := map[entity.Side]map[entity.Decimal]entity.Decimal{entity.Buy: {}, entity.Sell: {}}
+			for _, update := range <-updatesCh {
+				_, exists := got[update.Side][update.Price]
+				if exists == (update.Action == event.DepthNew) {
+					t.Errorf("%v of the %v level %v, level exists = %v", update.Action, update.Side, update.Price, exists)
+				}
+				if update.Action == event.DepthDelete {
+					delete(got[update.Side], update.Price)
+				} else {
+					got[update.Side][update.Price] = update.TotalQuantity
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantDepth) {
+				t.Errorf("depth = %v, want %v", got, tt.wantDepth)
+			}
+		})
+	}
+}
+
+func Test_symbolEngine_SubscribeDepth(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	engine, events := NewSymbolEngine()
+	done := make(chan []string)
+	go func() {
+		done <- describeEvents(events)
+	}()
+
+	transactions := depthTransactions()
+	// The book is created by the first order, the subscriber arrives in the middle of the transactions.
+	for _, transaction := range transactions[:4] {
+		if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+			t.Fatalf("ProcessTransaction() error = %v", err)
+		}
+	}
+	snapshot, updates := engine.OrderBook("IBM").SubscribeDepth(ctx)
+	for _, transaction := range transactions[4:] {
+		if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+			t.Fatalf("ProcessTransaction() error = %v", err)
+		}
+	}
+	engine.Close()
+	// Once all the events are out, the book has applied all of them.
+	<-done
+
+	got := map[entity.Side]map[entity.Decimal]entity.Decimal{entity.Buy: {}, entity.Sell: {}}
+	for _, level := range append(append([]orderbook.BookLevel{}, snapshot.Bids...), snapshot.Asks...) {
+		got[level.Side][level.Price] = level.TotalQuantity
+	}
+	sequence := snapshot.Sequence
+	for len(updates) > 0 {
+		update := <-updates
+		if update.Sequence <= sequence {
+			t.Errorf("update %v after %v", update.Sequence, sequence)
+		}
+		sequence = update.Sequence
+		if update.Action == event.DepthDelete {
+			delete(got[update.Side], update.Price)
+		} else {
+			got[update.Side][update.Price] = update.TotalQuantity
+		}
+	}
+	if !reflect.DeepEqual(got, wantDepth) {
+		t.Errorf("depth = %v, want %v", got, wantDepth)
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/rodoufu/simple-orderbook/pkg/entity"
 	obkIo "github.com/rodoufu/simple-orderbook/pkg/io"
+	"github.com/rodoufu/simple-orderbook/pkg/orderbook"
 )
 
 // MatchingEngine checks for matching for every added order.
@@ -14,7 +15,33 @@ type MatchingEngine interface {
 	io.Closer
 	// AddOrder adds a new order checking for matches.
 	AddOrder(ctx context.Context, order entity.Order) error
-	// CancelOrder remove an order by id.
-	CancelOrder(ctx context.Context, orderID entity.OrderID) error
+	// CancelOrder remove an order by its key, the request is rejected when the user has no order with that id.
+	CancelOrder(ctx context.Context, key entity.OrderKey) error
+	// ReplaceOrder changes the price and the remaining amount of an order.
+	// Reducing the amount keeps the time priority, changing the price or increasing the amount sends the order to the
+	// back of the queue and may match it.
+	// The request is rejected when the user has no order with that id.
+	ReplaceOrder(ctx context.Context, key entity.OrderKey, price, amount entity.Decimal) error
+	// ExpireOrders cancels the good till time orders whose deadline was reached by the clock of the engine.
+	// The engine also does it before adding or cancelling orders.
+	ExpireOrders(ctx context.Context) error
+	// ChangeSession moves the book to the session state, which defines the requests it accepts.
+	// Moving to the continuous trading ends the call auction, trading the crossing orders at the single price that
+	// maximises the volume.
+	ChangeSession(ctx context.Context, session entity.SessionState) error
+	// Snapshot writes the resting orders, the order-ID index and the state of the books in a versioned format, with the
+	// sequence number of the last journal entry they include.
+	Snapshot(ctx context.Context, writer io.Writer) error
+	// Restore replaces the orders and the state of the books with the ones written by Snapshot, without publishing
+	// events.
+	// It is meant for a new engine, before it receives any request.
+	Restore(ctx context.Context, reader io.Reader) error
 	ProcessTransaction(ctx context.Context, transaction obkIo.Transaction) error
 }
+
+// SymbolEngine is a MatchingEngine trading many symbols, each one with its own book.
+type SymbolEngine interface {
+	MatchingEngine
+	// OrderBook gives the book aggregating the events of the symbol.
+	OrderBook(symbol string) orderbook.OrderBook
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+var (
+	missingExpiryError = fmt.Errorf("good till time order without expiry")
+)
+
+// expiry is when a good till time order has to leave the engine.
+type expiry struct {
+	at  time.Time
+	key entity.OrderKey
+	// sequence keeps the arrival order for orders expiring at the same time.
+	sequence uint64
+}
+
+// expiryQueue is a heap with the next order to expire at the top.
+type expiryQueue []expiry
+
+func (q expiryQueue) Len() int {
+	return len(q)
+}
+
+func (q expiryQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].sequence < q[j].sequence
+	}
+	return q[i].at.Before(q[j].at)
+}
+
+func (q expiryQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *expiryQueue) Push(x any) {
+	*q = append(*q, x.(expiry))
+}
+
+func (q *expiryQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+// expiries tracks the deadlines of the good till time orders.
+// Orders leaving the engine are not taken out of the queue, entries not matching the current deadline of the order
+// are ignored when they expire.
+type expiries struct {
+	queue     expiryQueue
+	deadlines map[entity.OrderKey]time.Time
+	sequence  uint64
+}
+
+// track starts watching the deadline of the order, forgetting any previous order with the same ID.
+func (e *expiries) track(order entity.Order) {
+	if order.TimeInForce != entity.GoodTillTime {
+		delete(e.deadlines, order.Key())
+		return
+	}
+	if e.deadlines == nil {
+		e.deadlines = map[entity.OrderKey]time.Time{}
+	}
+	e.deadlines[order.Key()] = order.ExpireAt
+	e.sequence++
+	heap.Push(&e.queue, expiry{
+		at:       order.ExpireAt,
+		key:      order.Key(),
+		sequence: e.sequence,
+	})
+}
+
+// popExpired takes out the orders whose deadline was reached at now, in the order they expired.
+func (e *expiries) popExpired(now time.Time) []entity.OrderKey {
+	var resp []entity.OrderKey
+	for len(e.queue) > 0 && !now.Before(e.queue[0].at) {
+		next := heap.Pop(&e.queue).(expiry)
+		if deadline, ok := e.deadlines[next.key]; ok && deadline.Equal(next.at) {
+			delete(e.deadlines, next.key)
+			resp = append(resp, next.key)
+		}
+	}
+	return resp
+}
+
+func (e *expiries) reset() {
+	e.queue = nil
+	e.deadlines = nil
+}
+
+// expired checks if the deadline of a good till time order was reached.
+func expired(order entity.Order, now time.Time) bool {
+	return order.TimeInForce == entity.GoodTillTime && !now.Before(order.ExpireAt)
+}
+
+// expireOrders cancels the good till time orders whose deadline was reached by the clock of the engine.
+func expireOrders(b book) {
+	cfg := b.config()
+	state := b.state()
+	expiredKeys := state.expiries.popExpired(cfg.timeSource().Now())
+	if len(expiredKeys) == 0 {
+		return
+	}
+
+	before := topLevels(b)
+	defer publishBookChanges(b, before)
+	for _, key := range expiredKeys {
+		if stop, isStop := state.stops.remove(key); isStop {
+			b.publish(&event.StopCancelled{
+				Symbol: cfg.symbol,
+				Order:  stop,
+				Reason: event.CancelExpired,
+			})
+		} else if resting := b.find(key); resting != nil {
+			touchLevel(b, resting)
+			order, _ := b.remove(key)
+			b.publish(&event.OrderCancelled{
+				Symbol: cfg.symbol,
+				Order:  order,
+				Reason: event.CancelExpired,
+			})
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+// describeEvents gives the output of the events, cancels are described with their reason and updates with the amount.
+func describeEvents(events <-chan event.Event) []string {
+	var resp []string
+	for evt := range events {
+		switch it := evt.(type) {
+		case *event.OrderCancelled:
+			resp = append(resp, fmt.Sprintf("cancelled %v, %v", it.Order.ID, it.Reason))
+		case *event.StopCancelled:
+			resp = append(resp, fmt.Sprintf("stop cancelled %v, %v", it.Order.ID, it.Reason))
+		case *event.OrderUpdated:
+			resp = append(resp, fmt.Sprintf("updated %v, %v", it.Order.ID, it.Order.Amount))
+		default:
+			if output := evt.Output(); len(output) > 0 {
+				resp = append(resp, output)
+			}
+		}
+	}
+	return resp
+}
+
+func Test_expireOrders(t *testing.T) {
+	t.Parallel()
+	start := time.UnixMilli(1_000_000)
+	tests := []struct {
+		name      string
+		newEngine func(events chan event.Event, clock entity.Clock) MatchingEngine
+	}{
+		{
+			name: "list",
+			newEngine: func(events chan event.Event, clock entity.Clock) MatchingEngine {
+				return newListEngine(events, WithClock(clock))
+			},
+		},
+		{
+			name: "tree",
+			newEngine: func(events chan event.Event, clock entity.Clock) MatchingEngine {
+				return newTreeEngine(events, WithClock(clock))
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			clock := entity.NewManualClock(start)
+			events := make(chan event.Event, 50)
+			engine := tt.newEngine(events, clock)
+
+			addOrder := func(order entity.Order) {
+				order.User = entity.UserID(order.ID)
+				if err := engine.AddOrder(ctx, order); err != nil {
+					t.Errorf("AddOrder(%v) error = %v", order.ID, err)
+				}
+			}
+			addOrder(entity.Order{
+				Amount:      10,
+				Price:       11,
+				ID:          1,
+				Side:        entity.Sell,
+				TimeInForce: entity.GoodTillTime,
+				ExpireAt:    start.Add(10 * time.Second),
+			})
+			addOrder(entity.Order{
+				Amount:      5,
+				Price:       9,
+				ID:          2,
+				Side:        entity.Buy,
+				TimeInForce: entity.GoodTillTime,
+				ExpireAt:    start.Add(5 * time.Second),
+			})
+			addOrder(entity.Order{
+				Amount:      5,
+				ID:          3,
+				Side:        entity.Buy,
+				Type:        entity.Market,
+				StopPrice:   20,
+				TimeInForce: entity.GoodTillTime,
+				ExpireAt:    start.Add(5 * time.Second),
+			})
+			if err := engine.AddOrder(ctx, entity.Order{
+				Amount:      5,
+				Price:       9,
+				ID:          4,
+				Side:        entity.Buy,
+				TimeInForce: entity.GoodTillTime,
+			}); err != missingExpiryError {
+				t.Errorf("AddOrder() without expiry error = %v, want %v", err, missingExpiryError)
+			}
+
+			clock.Advance(4 * time.Second)
+			if err := engine.ExpireOrders(ctx); err != nil {
+				t.Errorf("ExpireOrders() error = %v", err)
+			}
+			clock.Advance(time.Second)
+			if err := engine.ExpireOrders(ctx); err != nil {
+				t.Errorf("ExpireOrders() error = %v", err)
+			}
+
+			// Arriving after its deadline, the order can only take liquidity.
+			addOrder(entity.Order{
+				Amount:      15,
+				Price:       11,
+				ID:          5,
+				Side:        entity.Buy,
+				TimeInForce: entity.GoodTillTime,
+				ExpireAt:    start.Add(time.Second),
+			})
+
+			addOrder(entity.Order{
+				Amount:      3,
+				Price:       12,
+				ID:          6,
+				Side:        entity.Sell,
+				TimeInForce: entity.GoodTillTime,
+				ExpireAt:    start.Add(20 * time.Second),
+			})
+			clock.Set(start.Add(20 * time.Second))
+			// The order left the book before the cancel, so the request is rejected.
+			if err := engine.CancelOrder(ctx, entity.OrderKey{User: 6, ID: 6}); err != nil {
+				t.Errorf("CancelOrder() error = %v", err)
+			}
+
+			engine.Close()
+			gotEvents := describeEvents(events)
+			wantEvents := []string{
+				"A, 1, 1",
+				"B, S, 11, 10",
+				"A, 2, 2",
+				"B, B, 9, 5",
+				"A, 3, 3",
+				"cancelled 2, expired",
+				"stop cancelled 3, expired",
+				"B, B, -, -",
+				"A, 5, 5",
+				"T, 5, 5, 1, 1, 11, 10",
+				"cancelled 5, expired",
+				"B, S, -, -",
+				"A, 6, 6",
+				"B, S, 12, 3",
+				"cancelled 6, expired",
+				"B, S, -, -",
+				"R, 6, 6",
+			}
+			if !reflect.DeepEqual(gotEvents, wantEvents) {
+				t.Errorf("events: %v, want: %v", gotEvents, wantEvents)
+			}
+		})
+	}
+}
+
+func Test_listEngine_clockTimestampsTrades(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	clock := entity.NewManualClock(time.UnixMilli(42))
+	events := make(chan event.Event, 10)
+	engine := newListEngine(events, WithClock(clock))
+	for _, order := range []entity.Order{
+		{Amount: 1, Price: 10, ID: 1, Side: entity.Sell, User: 1},
+		{Amount: 1, Price: 10, ID: 2, Side: entity.Buy, User: 2},
+	} {
+		if err := engine.AddOrder(ctx, order); err != nil {
+			t.Errorf("AddOrder(%v) error = %v", order.ID, err)
+		}
+	}
+	engine.Close()
+	for evt := range events {
+		if trade, ok := evt.(*event.TradeGenerated); ok && !trade.Trade.Timestamp.Equal(clock.Now()) {
+			t.Errorf("trade timestamp = %v, want %v", trade.Trade.Timestamp, clock.Now())
+		}
+	}
+}
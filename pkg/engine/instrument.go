@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+// instrumentViolation checks the price and the amount against the instrument of the symbol, giving the reason to
+// reject them.
+// Market orders have no price to check, the stop price is checked like the limit one.
+func instrumentViolation(cfg *options, symbol string, prices []entity.Decimal, amount entity.Decimal) (event.RejectReason, bool) {
+	if cfg.instruments == nil {
+		return 0, false
+	}
+	instrument, ok := cfg.instruments[symbol]
+	if !ok {
+		return event.RejectUnknownInstrument, true
+	}
+	for _, price := range prices {
+		if price == 0 {
+			continue
+		}
+		if !instrument.OnTick(price) {
+			return event.RejectTickSize, true
+		}
+		if !instrument.PriceAllowed(price) {
+			return event.RejectPriceLimit, true
+		}
+	}
+	if !instrument.OnLot(amount) {
+		return event.RejectLotSize, true
+	}
+	if !instrument.QuantityAllowed(amount) {
+		return event.RejectQuantity, true
+	}
+	return 0, false
+}
+
+// orderSymbol gives the symbol of the book, or the one of the order for engines without a symbol.
+func orderSymbol(cfg *options, order entity.Order) string {
+	if len(cfg.symbol) > 0 {
+		return cfg.symbol
+	}
+	return order.Symbol
+}
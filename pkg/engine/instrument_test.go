@@ -0,0 +1,228 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+func Test_instrumentViolation(t *testing.T) {
+	t.Parallel()
+	instruments := entity.Instruments{
+		"IBM": {
+			Symbol:      "IBM",
+			TickSize:    5,
+			LotSize:     10,
+			MinQuantity: 20,
+			MaxQuantity: 1000,
+			MinPrice:    50,
+			MaxPrice:    200,
+		},
+		"AAPL": {Symbol: "AAPL"},
+	}
+	tests := []struct {
+		name       string
+		order      entity.Order
+		wantReason event.RejectReason
+		wantOk     bool
+	}{
+		{
+			name:   "valid",
+			order:  entity.Order{Symbol: "IBM", Amount: 20, Price: 100},
+			wantOk: true,
+		},
+		{
+			name:   "market orders have no price",
+			order:  entity.Order{Symbol: "IBM", Amount: 20, Type: entity.Market},
+			wantOk: true,
+		},
+		{
+			name:   "instrument without constraints",
+			order:  entity.Order{Symbol: "AAPL", Amount: 1, Price: 1},
+			wantOk: true,
+		},
+		{
+			name:       "unknown instrument",
+			order:      entity.Order{Symbol: "MSFT", Amount: 20, Price: 100},
+			wantReason: event.RejectUnknownInstrument,
+		},
+		{
+			name:       "off the tick",
+			order:      entity.Order{Symbol: "IBM", Amount: 20, Price: 101},
+			wantReason: event.RejectTickSize,
+		},
+		{
+			name:       "stop price off the tick",
+			order:      entity.Order{Symbol: "IBM", Amount: 20, Price: 100, StopPrice: 102},
+			wantReason: event.RejectTickSize,
+		},
+		{
+			name:       "below the minimum price",
+			order:      entity.Order{Symbol: "IBM", Amount: 20, Price: 45},
+			wantReason: event.RejectPriceLimit,
+		},
+		{
+			name:       "above the maximum price",
+			order:      entity.Order{Symbol: "IBM", Amount: 20, Price: 205},
+			wantReason: event.RejectPriceLimit,
+		},
+		{
+			name:       "off the lot",
+			order:      entity.Order{Symbol: "IBM", Amount: 25, Price: 100},
+			wantReason: event.RejectLotSize,
+		},
+		{
+			name:       "below the minimum quantity",
+			order:      entity.Order{Symbol: "IBM", Amount: 10, Price: 100},
+			wantReason: event.RejectQuantity,
+		},
+		{
+			name:       "above the maximum quantity",
+			order:      entity.Order{Symbol: "IBM", Amount: 1010, Price: 100},
+			wantReason: event.RejectQuantity,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			for _, storage := range []Storage{ListStorage, TreeStorage} {
+				events := make(chan event.Event, 10)
+				var engine MatchingEngine = newListEngine(events, WithInstruments(instruments))
+				if storage == TreeStorage {
+					engine = newTreeEngine(events, WithInstruments(instruments))
+				}
+				order := tt.order
+				order.ID, order.User, order.Side = 1, 1, entity.Buy
+				if err := engine.AddOrder(ctx, order); err != nil {
+					t.Errorf("%v AddOrder() error = %v", storage, err)
+				}
+				engine.Close()
+
+				var gotReason event.RejectReason
+				gotOk := true
+				for evt := range events {
+					if rejected, ok := evt.(*event.OrderRejected); ok {
+						gotReason, gotOk = rejected.Reason, false
+					}
+				}
+				if gotOk != tt.wantOk || gotReason != tt.wantReason {
+					t.Errorf(
+						"%v accepted = %v, reason = %v, want %v, %v", storage, gotOk, gotReason, tt.wantOk, tt.wantReason,
+					)
+				}
+			}
+		})
+	}
+}
+
+func Test_instrumentReplace(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	instruments := entity.Instruments{
+		"IBM": {Symbol: "IBM", TickSize: 5, LotSize: 10},
+	}
+	events := make(chan event.Event, 10)
+	engine := newListEngine(events, WithInstruments(instruments))
+	if err := engine.AddOrder(ctx, entity.Order{
+		Symbol: "IBM", Amount: 20, Price: 100, ID: 1, User: 1, Side: entity.Buy,
+	}); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	key := entity.OrderKey{User: 1, ID: 1}
+	for _, replace := range []struct{ price, amount entity.Decimal }{{101, 20}, {100, 15}, {105, 30}} {
+		if err := engine.ReplaceOrder(ctx, key, replace.price, replace.amount); err != nil {
+			t.Errorf("ReplaceOrder(%v, %v) error = %v", replace.price, replace.amount, err)
+		}
+	}
+	engine.Close()
+
+	var gotReasons []event.RejectReason
+	for evt := range events {
+		if rejected, ok := evt.(*event.RequestRejected); ok {
+			gotReasons = append(gotReasons, rejected.Reason)
+		}
+	}
+	wantReasons := []event.RejectReason{event.RejectTickSize, event.RejectLotSize}
+	if !reflect.DeepEqual(gotReasons, wantReasons) {
+		t.Errorf("reasons: %v, want: %v", gotReasons, wantReasons)
+	}
+}
+
+func Test_instrumentPostOnly(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		instrument entity.Instrument
+		top        entity.Order
+		order      entity.Order
+		wantEvents []string
+	}{
+		{
+			name:       "buy repriced one tick below the offer",
+			instrument: entity.Instrument{Symbol: "IBM", TickSize: 5},
+			top:        entity.Order{Amount: 10, Price: 100, Side: entity.Sell},
+			order:      entity.Order{Amount: 10, Price: 105, Side: entity.Buy},
+			wantEvents: []string{"A, 2, 2", "B, B, 95, 10"},
+		},
+		{
+			name:       "sell repriced one tick above the bid",
+			instrument: entity.Instrument{Symbol: "IBM", TickSize: 5},
+			top:        entity.Order{Amount: 10, Price: 100, Side: entity.Buy},
+			order:      entity.Order{Amount: 10, Price: 95, Side: entity.Sell},
+			wantEvents: []string{"A, 2, 2", "B, S, 105, 10"},
+		},
+		{
+			name:       "tick below the minimum price",
+			instrument: entity.Instrument{Symbol: "IBM", TickSize: 5, MinPrice: 100},
+			top:        entity.Order{Amount: 10, Price: 100, Side: entity.Sell},
+			order:      entity.Order{Amount: 10, Price: 105, Side: entity.Buy},
+			wantEvents: []string{"R, 2, 2"},
+		},
+		{
+			name:       "no tick below the offer",
+			instrument: entity.Instrument{Symbol: "IBM", TickSize: 5},
+			top:        entity.Order{Amount: 10, Price: 5, Side: entity.Sell},
+			order:      entity.Order{Amount: 10, Price: 10, Side: entity.Buy},
+			wantEvents: []string{"R, 2, 2"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			instruments := entity.Instruments{"IBM": tt.instrument}
+			for _, storage := range []Storage{ListStorage, TreeStorage} {
+				events := make(chan event.Event, 10)
+				var engine MatchingEngine = newListEngine(events, WithInstruments(instruments))
+				if storage == TreeStorage {
+					engine = newTreeEngine(events, WithInstruments(instruments))
+				}
+				top := tt.top
+				top.Symbol, top.ID, top.User = "IBM", 1, 1
+				order := tt.order
+				order.Symbol, order.ID, order.User, order.PostOnly = "IBM", 2, 2, entity.PostOnlyReprice
+				for _, it := range []entity.Order{top, order} {
+					if err := engine.AddOrder(ctx, it); err != nil {
+						t.Errorf("%v AddOrder() error = %v", storage, err)
+					}
+				}
+				engine.Close()
+
+				gotEvents := describeEvents(events)
+				if len(gotEvents) < 2 {
+					t.Fatalf("%v events: %v", storage, gotEvents)
+				}
+				// The events of the top of the book are left out.
+				if gotEvents = gotEvents[2:]; !reflect.DeepEqual(gotEvents, tt.wantEvents) {
+					t.Errorf("%v events: %v, want: %v", storage, gotEvents, tt.wantEvents)
+				}
+			}
+		})
+	}
+}
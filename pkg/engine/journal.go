@@ -0,0 +1,254 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+	obkIo "github.com/rodoufu/simple-orderbook/pkg/io"
+)
+
+// Constructor creates a MatchingEngine with the options, like NewListEngine and NewTreeEngine.
+type Constructor func(opts ...Option) (MatchingEngine, <-chan event.Event)
+
+// replayTransaction is sent to the engine around the replay of the journal, the engine answers it with a
+// replayMarker.
+type replayTransaction struct {
+	obkIo.Transaction
+	done bool
+}
+
+// replayMarker delimits the events generated by the replay of the journal, they were already published before the
+// crash.
+type replayMarker struct {
+	event.Header
+	done bool
+}
+
+func (e *replayMarker) Output() string {
+	return ""
+}
+
+func (e *replayMarker) BookSymbol() string {
+	return ""
+}
+
+// journaledEngine writes every transaction to the journal before applying it, so the engine can be rebuilt after a
+// crash.
+type journaledEngine struct {
+	mtx     sync.Mutex
+	engine  MatchingEngine
+	journal *obkIo.Journal
+	// fileName is where the journal is, read again to restore a snapshot.
+	fileName string
+	// clock gives the time of the new transactions.
+	clock entity.Clock
+	// engineClock drives the engine with the time written to the journal, so the replay sees the same times.
+	engineClock *entity.ManualClock
+	output      chan event.Event
+}
+
+// forward drops the events of the replay, publishing only the ones of the new transactions.
+func (s *journaledEngine) forward(events <-chan event.Event) {
+	defer close(s.output)
+	replaying := true
+	for evt := range events {
+		if marker, ok := evt.(*replayMarker); ok {
+			replaying = !marker.done
+			continue
+		}
+		if !replaying {
+			s.output <- evt
+		}
+	}
+}
+
+func (s *journaledEngine) apply(ctx context.Context, entry obkIo.JournalEntry) error {
+	s.engineClock.Set(entry.Time)
+	return s.engine.ProcessTransaction(ctx, entry.Transaction)
+}
+
+func (s *journaledEngine) ProcessTransaction(ctx context.Context, transaction obkIo.Transaction) error {
+	if s == nil {
+		return notStartedError
+	}
+	if t, ok := transaction.(obkIo.ErrorTransaction); ok {
+		return t.Err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// The times are written without the monotonic reading, which cannot be read back, so the replay compares them the
+	// same way.
+	entry, err := s.journal.Append(s.clock.Now().Round(0), wallTime(transaction))
+	if err != nil {
+		return err
+	}
+	return s.apply(ctx, entry)
+}
+
+// wallTime gives the transaction with the times of the order stripped of the monotonic reading.
+func wallTime(transaction obkIo.Transaction) obkIo.Transaction {
+	if t, ok := transaction.(obkIo.NewOrderTransaction); ok {
+		t.Order.Timestamp = t.Order.Timestamp.Round(0)
+		t.Order.ExpireAt = t.Order.ExpireAt.Round(0)
+		return t
+	}
+	return transaction
+}
+
+func (s *journaledEngine) AddOrder(ctx context.Context, order entity.Order) error {
+	return s.ProcessTransaction(ctx, obkIo.NewOrderTransaction{Symbol: order.Symbol, Order: order})
+}
+
+func (s *journaledEngine) CancelOrder(ctx context.Context, key entity.OrderKey) error {
+	return s.ProcessTransaction(ctx, obkIo.CancelOrderTransaction{User: key.User, OrderID: key.ID})
+}
+
+func (s *journaledEngine) ReplaceOrder(ctx context.Context, key entity.OrderKey, price, amount entity.Decimal) error {
+	return s.ProcessTransaction(ctx, obkIo.ReplaceOrderTransaction{
+		User:    key.User,
+		OrderID: key.ID,
+		Price:   price,
+		Amount:  amount,
+	})
+}
+
+func (s *journaledEngine) ExpireOrders(ctx context.Context) error {
+	return s.ProcessTransaction(ctx, obkIo.ExpireOrdersTransaction{})
+}
+
+func (s *journaledEngine) ChangeSession(ctx context.Context, session entity.SessionState) error {
+	return s.ProcessTransaction(ctx, obkIo.SessionTransaction{Session: session})
+}
+
+// Snapshot saves the state of the engine with the sequence number of the last journal entry, so a restore only
+// replays the entries after it.
+func (s *journaledEngine) Snapshot(ctx context.Context, writer io.Writer) error {
+	if s == nil {
+		return notStartedError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	engine, ok := s.engine.(snapshotter)
+	if !ok {
+		return notSnapshotterError
+	}
+	return obkIo.WriteSnapshot(writer, s.journal.Sequence(), engine.snapshot())
+}
+
+// Restore brings the engine back to the snapshot and replays the journal entries after it, without publishing their
+// events.
+func (s *journaledEngine) Restore(ctx context.Context, reader io.Reader) error {
+	if s == nil {
+		return notStartedError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	entries, err := obkIo.ReadJournal(s.fileName)
+	if err != nil {
+		return err
+	}
+	if err = s.engine.ProcessTransaction(ctx, replayTransaction{}); err != nil {
+		return err
+	}
+	return s.recover(ctx, reader, entries)
+}
+
+// recover rebuilds the engine from the snapshot, when there is one, and the journal entries after it.
+// The events are hidden until the end of the replay, even when it fails.
+func (s *journaledEngine) recover(ctx context.Context, snapshot io.Reader, entries []obkIo.JournalEntry) error {
+	var err error
+	var sequence uint64
+	if snapshot != nil {
+		sequence, err = s.restoreSnapshot(snapshot, uint64(len(entries)))
+	}
+	if err == nil {
+		for _, entry := range entries[sequence:] {
+			// The errors were already returned when the transaction was first applied.
+			_ = s.apply(ctx, entry)
+		}
+	}
+	if doneErr := s.engine.ProcessTransaction(ctx, replayTransaction{done: true}); err == nil {
+		err = doneErr
+	}
+	return err
+}
+
+func (s *journaledEngine) restoreSnapshot(reader io.Reader, journaled uint64) (uint64, error) {
+	engine, ok := s.engine.(snapshotter)
+	if !ok {
+		return 0, notSnapshotterError
+	}
+	var snapshot engineSnapshot
+	sequence, err := obkIo.ReadSnapshot(reader, &snapshot)
+	if err != nil {
+		return 0, err
+	}
+	if sequence > journaled {
+		return 0, fmt.Errorf("snapshot at entry %v is ahead of the journal with %v entries", sequence, journaled)
+	}
+	return sequence, engine.restore(snapshot)
+}
+
+func (s *journaledEngine) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.engine.Close(); err != nil {
+		return err
+	}
+	return s.journal.Close()
+}
+
+// OpenJournaledEngine creates the engine with newEngine and replays the transactions already in the journal file,
+// rebuilding the state it had before a crash.
+// The events of the replay are not published again, only the ones of the transactions received afterwards.
+// Every new transaction is written to the journal, with the time it is applied at, before the engine receives it.
+func OpenJournaledEngine(
+	ctx context.Context, fileName string, newEngine Constructor, opts ...Option,
+) (MatchingEngine, <-chan event.Event, error) {
+	return openJournaledEngine(ctx, fileName, nil, newEngine, opts...)
+}
+
+// RestoreJournaledEngine is like OpenJournaledEngine, but it starts from the snapshot and only replays the journal
+// entries written after it.
+func RestoreJournaledEngine(
+	ctx context.Context, fileName string, snapshot io.Reader, newEngine Constructor, opts ...Option,
+) (MatchingEngine, <-chan event.Event, error) {
+	return openJournaledEngine(ctx, fileName, snapshot, newEngine, opts...)
+}
+
+func openJournaledEngine(
+	ctx context.Context, fileName string, snapshot io.Reader, newEngine Constructor, opts ...Option,
+) (MatchingEngine, <-chan event.Event, error) {
+	journal, entries, err := obkIo.OpenJournal(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := newOptions(opts...)
+	clock := cfg.timeSource()
+	engineClock := entity.NewManualClock(clock.Now().Round(0))
+	engine, events := newEngine(append(append([]Option{}, opts...), WithClock(engineClock))...)
+	resp := &journaledEngine{
+		mtx:         sync.Mutex{},
+		engine:      engine,
+		journal:     journal,
+		fileName:    fileName,
+		clock:       clock,
+		engineClock: engineClock,
+		output:      make(chan event.Event, 10),
+	}
+	go resp.forward(events)
+
+	if err = resp.recover(ctx, snapshot, entries); err != nil {
+		_ = resp.Close()
+		return nil, nil, err
+	}
+	return resp, resp.output, nil
+}
@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+	"github.com/rodoufu/simple-orderbook/pkg/io"
+)
+
+func Test_journaledEngine_recover(t *testing.T) {
+	t.Parallel()
+	start := time.UnixMilli(1_000_000)
+	type step struct {
+		at          time.Duration
+		transaction io.Transaction
+	}
+	newOrder := func(order entity.Order) io.Transaction {
+		order.User = entity.UserID(order.ID)
+		order.Symbol = "BTC"
+		order.Timestamp = start
+		return io.NewOrderTransaction{Symbol: order.Symbol, Order: order}
+	}
+	beforeCrash := []step{
+		{at: 0, transaction: newOrder(entity.Order{
+			Amount:      10,
+			Price:       11,
+			ID:          1,
+			Side:        entity.Sell,
+			TimeInForce: entity.GoodTillTime,
+			ExpireAt:    start.Add(10 * time.Second),
+		})},
+		{at: time.Second, transaction: newOrder(entity.Order{Amount: 5, Price: 12, ID: 2, Side: entity.Sell})},
+		{at: 2 * time.Second, transaction: newOrder(entity.Order{Amount: 4, Price: 11, ID: 3, Side: entity.Buy})},
+		{at: 3 * time.Second, transaction: io.ReplaceOrderTransaction{User: 2, OrderID: 2, Price: 13, Amount: 6}},
+	}
+	afterCrash := []step{
+		{at: 4 * time.Second, transaction: newOrder(entity.Order{Amount: 3, Price: 10, ID: 4, Side: entity.Buy})},
+		{at: 11 * time.Second, transaction: io.ExpireOrdersTransaction{}},
+		{at: 12 * time.Second, transaction: newOrder(entity.Order{Amount: 2, Price: 13, ID: 5, Side: entity.Buy})},
+		{at: 13 * time.Second, transaction: io.CancelOrderTransaction{User: 4, OrderID: 4}},
+	}
+
+	tests := []struct {
+		name      string
+		newEngine Constructor
+	}{
+		{name: "list", newEngine: NewListEngine},
+		{name: "tree", newEngine: NewTreeEngine},
+		{
+			name: "symbol",
+			newEngine: func(opts ...Option) (MatchingEngine, <-chan event.Event) {
+				return NewSymbolEngine(opts...)
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			// run opens the journal, applies the steps and gives the events published until the engine is closed.
+			run := func(fileName string, steps []step) []string {
+				clock := entity.NewManualClock(start)
+				engine, events, err := OpenJournaledEngine(ctx, fileName, tt.newEngine, WithClock(clock))
+				if err != nil {
+					t.Fatalf("OpenJournaledEngine() error = %v", err)
+				}
+				done := make(chan []string)
+				go func() {
+					done <- describeEvents(events)
+				}()
+				for _, it := range steps {
+					clock.Set(start.Add(it.at))
+					if err = engine.ProcessTransaction(ctx, it.transaction); err != nil {
+						t.Errorf("ProcessTransaction(%v) error = %v", it.transaction, err)
+					}
+				}
+				if err = engine.Close(); err != nil {
+					t.Errorf("Close() error = %v", err)
+				}
+				return <-done
+			}
+
+			want := run(filepath.Join(dir, "uninterrupted.journal"), append(append([]step{}, beforeCrash...), afterCrash...))
+
+			fileName := filepath.Join(dir, "crashed.journal")
+			before := run(fileName, beforeCrash)
+			// A record cut by the crash is dropped by the recovery.
+			file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				t.Fatalf("OpenFile() error = %v", err)
+			}
+			if _, err = file.Write([]byte{100, 0, 0, 0, 1, 2, 3}); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			_ = file.Close()
+			after := run(fileName, afterCrash)
+
+			if got := append(append([]string{}, before...), after...); !reflect.DeepEqual(got, want) {
+				t.Errorf("events = %v, want %v", got, want)
+			}
+			if len(after) == 0 || !reflect.DeepEqual(after, want[len(before):]) {
+				t.Errorf("events after recovery = %v, want %v", after, want[len(before):])
+			}
+
+			entries, err := io.ReadJournal(fileName)
+			if err != nil {
+				t.Fatalf("ReadJournal() error = %v", err)
+			}
+			if len(entries) != len(beforeCrash)+len(afterCrash) {
+				t.Fatalf("ReadJournal() = %v entries, want %v", len(entries), len(beforeCrash)+len(afterCrash))
+			}
+			for i, entry := range entries {
+				if entry.Sequence != uint64(i+1) {
+					t.Errorf("entry %v sequence = %v, want %v", i, entry.Sequence, i+1)
+				}
+			}
+		})
+	}
+}
+
+func Test_journaledEngine_corrupted(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "corrupted.journal")
+
+	engine, events, err := OpenJournaledEngine(ctx, fileName, NewListEngine)
+	if err != nil {
+		t.Fatalf("OpenJournaledEngine() error = %v", err)
+	}
+	go describeEvents(events)
+	for _, id := range []entity.OrderID{1, 2} {
+		order := entity.Order{Amount: 1, Price: 10, ID: id, User: 1, Side: entity.Buy}
+		if err = engine.AddOrder(ctx, order); err != nil {
+			t.Fatalf("AddOrder() error = %v", err)
+		}
+	}
+	if err = engine.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	// Changing the payload of the first entry breaks its CRC.
+	content[20] ^= 0xff
+	if err = os.WriteFile(fileName, content, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, _, err = OpenJournaledEngine(ctx, fileName, NewListEngine); err == nil {
+		t.Errorf("OpenJournaledEngine() expected error for a corrupted journal")
+	}
+}
@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"container/list"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+)
+
+// priceLevel keeps the orders with the same price in time priority.
+type priceLevel struct {
+	price  entity.Decimal
+	orders *list.List
+}
+
+type priceNode struct {
+	level  *priceLevel
+	left   *priceNode
+	right  *priceNode
+	height int
+}
+
+// priceTree is an AVL tree of price levels sorted by price.
+// Finding, adding and removing a level costs $O(log n)$ where $n$ is the number of levels.
+type priceTree struct {
+	root *priceNode
+}
+
+func (n *priceNode) getHeight() int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func (n *priceNode) update() {
+	n.height = 1 + n.left.getHeight()
+	if rightHeight := n.right.getHeight(); rightHeight >= n.height {
+		n.height = 1 + rightHeight
+	}
+}
+
+func (n *priceNode) balance() int {
+	return n.left.getHeight() - n.right.getHeight()
+}
+
+func (n *priceNode) rotateLeft() *priceNode {
+	root := n.right
+	n.right = root.left
+	root.left = n
+	n.update()
+	root.update()
+	return root
+}
+
+func (n *priceNode) rotateRight() *priceNode {
+	root := n.left
+	n.left = root.right
+	root.right = n
+	n.update()
+	root.update()
+	return root
+}
+
+// rebalance fixes the node after one of its children changed height by one.
+func (n *priceNode) rebalance() *priceNode {
+	n.update()
+	switch balance := n.balance(); {
+	case balance > 1:
+		if n.left.balance() < 0 {
+			n.left = n.left.rotateLeft()
+		}
+		return n.rotateRight()
+	case balance < -1:
+		if n.right.balance() > 0 {
+			n.right = n.right.rotateRight()
+		}
+		return n.rotateLeft()
+	default:
+		return n
+	}
+}
+
+func (n *priceNode) insert(level *priceLevel) *priceNode {
+	if n == nil {
+		return &priceNode{
+			level:  level,
+			height: 1,
+		}
+	}
+	if level.price < n.level.price {
+		n.left = n.left.insert(level)
+	} else {
+		n.right = n.right.insert(level)
+	}
+	return n.rebalance()
+}
+
+func (n *priceNode) first() *priceNode {
+	for n.left != nil {
+		n = n.left
+	}
+	return n
+}
+
+func (n *priceNode) last() *priceNode {
+	for n.right != nil {
+		n = n.right
+	}
+	return n
+}
+
+func (n *priceNode) delete(price entity.Decimal) *priceNode {
+	if n == nil {
+		return nil
+	}
+	switch {
+	case price < n.level.price:
+		n.left = n.left.delete(price)
+	case price > n.level.price:
+		n.right = n.right.delete(price)
+	default:
+		if n.left == nil {
+			return n.right
+		}
+		if n.right == nil {
+			return n.left
+		}
+		next := n.right.first()
+		n.level = next.level
+		n.right = n.right.delete(next.level.price)
+	}
+	return n.rebalance()
+}
+
+func (n *priceNode) walk(ascending bool, fn func(*priceLevel) bool) bool {
+	if n == nil {
+		return true
+	}
+	first, second := n.left, n.right
+	if !ascending {
+		first, second = second, first
+	}
+	return first.walk(ascending, fn) && fn(n.level) && second.walk(ascending, fn)
+}
+
+// get gives the level for the price, nil if there is none.
+func (t *priceTree) get(price entity.Decimal) *priceLevel {
+	for n := t.root; n != nil; {
+		switch {
+		case price < n.level.price:
+			n = n.left
+		case price > n.level.price:
+			n = n.right
+		default:
+			return n.level
+		}
+	}
+	return nil
+}
+
+// getOrCreate gives the level for the price, adding an empty one if necessary.
+func (t *priceTree) getOrCreate(price entity.Decimal) *priceLevel {
+	if level := t.get(price); level != nil {
+		return level
+	}
+	level := &priceLevel{
+		price:  price,
+		orders: list.New(),
+	}
+	t.root = t.root.insert(level)
+	return level
+}
+
+func (t *priceTree) delete(price entity.Decimal) {
+	t.root = t.root.delete(price)
+}
+
+// first gives the level with the lowest price, nil for an empty tree.
+func (t *priceTree) first() *priceLevel {
+	if t.root == nil {
+		return nil
+	}
+	return t.root.first().level
+}
+
+// last gives the level with the highest price, nil for an empty tree.
+func (t *priceTree) last() *priceLevel {
+	if t.root == nil {
+		return nil
+	}
+	return t.root.last().level
+}
+
+// walk visits the levels sorted by price until fn returns false.
+func (t *priceTree) walk(ascending bool, fn func(*priceLevel) bool) {
+	t.root.walk(ascending, fn)
+}
@@ -3,41 +3,59 @@ package engine
 import (
 	"context"
 	"fmt"
+	"io"
 	"sync"
 
 	"github.com/rodoufu/simple-orderbook/pkg/entity"
 	"github.com/rodoufu/simple-orderbook/pkg/event"
-	"github.com/rodoufu/simple-orderbook/pkg/io"
+	obkIo "github.com/rodoufu/simple-orderbook/pkg/io"
 )
 
 var (
 	notStartedError         = fmt.Errorf("engine not started or does not exist")
 	invalidOrderAmountError = fmt.Errorf("invalid order amount")
+	invalidOrderPriceError  = fmt.Errorf("invalid order price")
 )
 
+// listEngine keeps each side of the book in a sorted array, the best order is the last one.
 type listEngine struct {
-	mtx      sync.Mutex
-	orders   map[entity.Side][]entity.Order
-	events   chan event.Event
-	orderIDs map[entity.OrderID]entity.Side
+	mtx       sync.Mutex
+	orders    map[entity.Side][]entity.Order
+	events    chan event.Event
+	orderKeys map[entity.OrderKey]entity.Side
+	options   options
+	engineState
 }
 
-func (s *listEngine) ProcessTransaction(ctx context.Context, transaction io.Transaction) error {
+func (s *listEngine) ProcessTransaction(ctx context.Context, transaction obkIo.Transaction) error {
 	switch t := transaction.(type) {
-	case io.NewOrderTransaction:
+	case obkIo.NewOrderTransaction:
 		return s.AddOrder(ctx, t.Order)
-	case io.CancelOrderTransaction:
-		return s.CancelOrder(ctx, t.OrderID)
-	case io.ErrorTransaction:
+	case obkIo.CancelOrderTransaction:
+		return s.CancelOrder(ctx, t.Key())
+	case obkIo.ReplaceOrderTransaction:
+		return s.ReplaceOrder(ctx, t.Key(), t.Price, t.Amount)
+	case obkIo.SessionTransaction:
+		return s.ChangeSession(ctx, t.Session)
+	case obkIo.ExpireOrdersTransaction:
+		return s.ExpireOrders(ctx)
+	case replayTransaction:
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		// The marker is not an event of the book, so it takes no sequence number.
+		s.events <- &replayMarker{done: t.done}
+		return nil
+	case obkIo.ErrorTransaction:
 		return t.Err
-	case io.FlushAllOrdersTransaction:
+	case obkIo.FlushAllOrdersTransaction:
 		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		flushOrders(s)
 		s.orders = map[entity.Side][]entity.Order{
 			entity.Buy:  {},
 			entity.Sell: {},
 		}
-		s.orderIDs = map[entity.OrderID]entity.Side{}
-		s.mtx.Unlock()
+		s.orderKeys = map[entity.OrderKey]entity.Side{}
 		return nil
 	default:
 		return fmt.Errorf("problem identifying transaction: %v", transaction)
@@ -54,187 +72,214 @@ func (s *listEngine) Close() error {
 	return nil
 }
 
-func (s *listEngine) checkBeforeAndAfter(side entity.Side, before, after *entity.Order) {
-	if before == nil && after == nil {
-		return
+func (s *listEngine) AddOrder(ctx context.Context, order entity.Order) error {
+	if s == nil {
+		return notStartedError
 	}
-	if before == nil {
-		s.events <- &event.TopOfBookChange{
-			Side:          side,
-			Price:         after.Price,
-			TotalQuantity: after.Amount,
-		}
-	} else if after == nil {
-		s.events <- &event.TopOfBookChange{
-			Side: side,
-		}
-	} else if before.Price != after.Price || before.Amount != after.Amount || before.ID != after.ID {
-		top := event.TopOfBookChange{
-			Side:          side,
-			Price:         after.Price,
-			TotalQuantity: after.Amount,
-		}
-
-		for i := len(s.orders[side]) - 2; i >= 0 && s.orders[side][i].Price == top.Price; i-- {
-			top.TotalQuantity += s.orders[side][i].Amount
-		}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return submitOrder(s, order)
+}
 
-		s.events <- &top
+func (s *listEngine) CancelOrder(ctx context.Context, key entity.OrderKey) error {
+	if s == nil {
+		return notStartedError
 	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return cancelOrder(s, key)
 }
 
-func (s *listEngine) AddOrder(ctx context.Context, order entity.Order) error {
+func (s *listEngine) ReplaceOrder(ctx context.Context, key entity.OrderKey, price, amount entity.Decimal) error {
 	if s == nil {
 		return notStartedError
 	}
-	if order.Amount == 0 {
-		return invalidOrderAmountError
-	}
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
+	return replaceOrder(s, key, price, amount)
+}
 
-	before := map[entity.Side]*entity.Order{}
-	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
-		if len(s.orders[side]) > 0 {
-			copyOfTop := s.orders[side][len(s.orders[side])-1]
-			before[side] = &copyOfTop
-		}
+func (s *listEngine) ExpireOrders(ctx context.Context) error {
+	if s == nil {
+		return notStartedError
 	}
-	defer func() {
-		for _, side := range []entity.Side{entity.Buy, entity.Sell} {
-			if len(s.orders[side]) > 0 {
-				s.checkBeforeAndAfter(side, before[side], &s.orders[side][len(s.orders[side])-1])
-			} else {
-				s.checkBeforeAndAfter(side, before[side], nil)
-			}
-		}
-	}()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	expireOrders(s)
+	return nil
+}
 
-	if _, orderExists := s.orderIDs[order.ID]; orderExists {
-		return fmt.Errorf("order %v alreday exists", order.ID)
+func (s *listEngine) ChangeSession(ctx context.Context, session entity.SessionState) error {
+	if s == nil {
+		return notStartedError
 	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	changeSession(s, session)
+	return nil
+}
 
-	s.events <- &event.OrderAcknowledge{
-		Order: order,
+func (s *listEngine) Snapshot(ctx context.Context, writer io.Writer) error {
+	if s == nil {
+		return notStartedError
 	}
+	return obkIo.WriteSnapshot(writer, 0, s.snapshot())
+}
 
-	oppositeBook := s.orders[order.Side.Opposite()]
-	for i := len(oppositeBook) - 1; i >= 0; i-- {
-		remainingOrder, trade := order.Match(&oppositeBook[i])
-		if remainingOrder == nil && trade == nil {
-			break
-		}
-		if trade != nil {
-			s.events <- &event.TradeGenerated{
-				Trade: *trade,
-			}
-		}
-		if remainingOrder == nil {
-			delete(s.orderIDs, oppositeBook[i].ID)
-			s.events <- &event.OrderFilled{
-				Order: oppositeBook[i],
-				Full:  true,
-			}
-			order.Amount = 0
-			oppositeBook = oppositeBook[:i]
-			break
-		}
-
-		if remainingOrder.Side == order.Side {
-			s.events <- &event.OrderFilled{
-				Order: oppositeBook[i],
-				Full:  true,
-			}
-			oppositeBook = oppositeBook[:i]
-			order = *remainingOrder
-		} else {
-			s.events <- &event.OrderFilled{
-				Order: *remainingOrder,
-				Full:  false,
-			}
-			oppositeBook = oppositeBook[:i]
-			oppositeBook = append(oppositeBook, *remainingOrder)
-			order.Amount = 0
-			break
-		}
+func (s *listEngine) Restore(ctx context.Context, reader io.Reader) error {
+	if s == nil {
+		return notStartedError
 	}
-	s.orders[order.Side.Opposite()] = oppositeBook
-
-	if order.Amount > 0 {
-		book := s.orders[order.Side]
-		book = append(book, order)
-		s.orderIDs[order.ID] = order.Side
-		s.events <- &event.OrderCreated{
-			Order: order,
-		}
-		for i := len(book) - 1; i >= 1 && book[i].Less(&book[i-1]); i-- {
-			book[i], book[i-1] = book[i-1], book[i]
-		}
-
-		s.orders[order.Side] = book
+	var snapshot engineSnapshot
+	if _, err := obkIo.ReadSnapshot(reader, &snapshot); err != nil {
+		return err
 	}
+	return s.restore(snapshot)
+}
 
-	return nil
+func (s *listEngine) snapshot() engineSnapshot {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return snapshotSingleBook(s)
 }
 
-func (s *listEngine) CancelOrder(ctx context.Context, orderID entity.OrderID) error {
-	if s == nil {
-		return notStartedError
+func (s *listEngine) restore(snapshot engineSnapshot) error {
+	book, err := singleBook(snapshot)
+	if err != nil {
+		return err
 	}
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
+	s.orders = map[entity.Side][]entity.Order{
+		entity.Buy:  {},
+		entity.Sell: {},
+	}
+	s.orderKeys = map[entity.OrderKey]entity.Side{}
+	return restoreBook(s, book)
+}
 
-	side, orderExists := s.orderIDs[orderID]
-	if !orderExists {
-		return fmt.Errorf("order %v not found", orderID)
+func (s *listEngine) best(side entity.Side) *entity.Order {
+	sideOrders := s.orders[side]
+	if len(sideOrders) == 0 {
+		return nil
 	}
+	return &sideOrders[len(sideOrders)-1]
+}
 
-	before := map[entity.Side]*entity.Order{}
-	if len(s.orders[side]) > 0 {
-		copyOfTop := s.orders[side][len(s.orders[side])-1]
-		before[side] = &copyOfTop
+func (s *listEngine) removeBest(side entity.Side) {
+	sideOrders := s.orders[side]
+	delete(s.orderKeys, sideOrders[len(sideOrders)-1].Key())
+	s.orders[side] = sideOrders[:len(sideOrders)-1]
+}
+
+// insert keeps the time priority by placing the order before all the orders with the same or a better price.
+// The priority is the arrival at the book, like in the queues of the tree, the timestamp of the order is not used.
+func (s *listEngine) insert(order entity.Order) {
+	sideOrders := append(s.orders[order.Side], order)
+	s.orderKeys[order.Key()] = order.Side
+	for i := len(sideOrders) - 1; i >= 1 && !worsePrice(&sideOrders[i-1], &sideOrders[i]); i-- {
+		sideOrders[i], sideOrders[i-1] = sideOrders[i-1], sideOrders[i]
+	}
+	s.orders[order.Side] = sideOrders
+}
+
+func (s *listEngine) remove(key entity.OrderKey) (entity.Order, bool) {
+	side, orderExists := s.orderKeys[key]
+	if !orderExists {
+		return entity.Order{}, false
 	}
-	defer func() {
-		if len(s.orders[side]) > 0 {
-			s.checkBeforeAndAfter(side, before[side], &s.orders[side][len(s.orders[side])-1])
-		} else {
-			s.checkBeforeAndAfter(side, before[side], nil)
-		}
-	}()
 
 	sideOrders := s.orders[side]
 	index := len(sideOrders) - 1
-	for index >= 0 && sideOrders[index].ID != orderID {
+	for index >= 0 && sideOrders[index].Key() != key {
 		index--
 	}
-	if index >= 0 && sideOrders[index].ID == orderID {
-		delete(s.orderIDs, orderID)
-		s.events <- &event.OrderCancelled{
-			Order: sideOrders[index],
+	if index < 0 {
+		return entity.Order{}, false
+	}
+
+	order := sideOrders[index]
+	delete(s.orderKeys, key)
+	copy(sideOrders[index:], sideOrders[index+1:])
+	s.orders[side] = sideOrders[:len(sideOrders)-1]
+	return order, true
+}
+
+func (s *listEngine) sideOf(key entity.OrderKey) (entity.Side, bool) {
+	side, orderExists := s.orderKeys[key]
+	return side, orderExists
+}
+
+func (s *listEngine) find(key entity.OrderKey) *entity.Order {
+	side, orderExists := s.orderKeys[key]
+	if !orderExists {
+		return nil
+	}
+	sideOrders := s.orders[side]
+	for i := len(sideOrders) - 1; i >= 0; i-- {
+		if sideOrders[i].Key() == key {
+			return &sideOrders[i]
 		}
-		s.events <- &event.OrderAcknowledge{
-			Order: sideOrders[index],
+	}
+	return nil
+}
+
+func (s *listEngine) walk(side entity.Side, fn func(order *entity.Order) bool) {
+	sideOrders := s.orders[side]
+	for i := len(sideOrders) - 1; i >= 0; i-- {
+		if !fn(&sideOrders[i]) {
+			return
 		}
+	}
+}
 
-		copy(sideOrders[index:], sideOrders[index+1:])
-		sideOrders = sideOrders[:len(sideOrders)-1]
-		s.orders[side] = sideOrders
+func (s *listEngine) levelQuantity(side entity.Side, price entity.Decimal) entity.Decimal {
+	var total entity.Decimal
+	sideOrders := s.orders[side]
+	for i := len(sideOrders) - 1; i >= 0; i-- {
+		if sideOrders[i].Price == price {
+			total += sideOrders[i].Amount
+		} else if total > 0 {
+			break
+		}
+	}
+	return total
+}
 
-		return nil
+func (s *listEngine) publish(evt event.Event) {
+	evt.SetBookSequence(s.nextEventSequence())
+	if s.options.listener != nil {
+		s.options.listener(evt)
 	}
+	s.events <- evt
+}
+
+func (s *listEngine) config() *options {
+	return &s.options
+}
 
-	return fmt.Errorf("order %v not found", orderID)
+// worsePrice checks if the order has a worse price than the other one, for orders of the same side.
+func worsePrice(order, other *entity.Order) bool {
+	if order.Side == entity.Buy {
+		return order.Price < other.Price
+	}
+	return order.Price > other.Price
 }
 
-func NewListEngine() (MatchingEngine, <-chan event.Event) {
-	engine := listEngine{
+func newListEngine(events chan event.Event, opts ...Option) *listEngine {
+	return &listEngine{
 		mtx: sync.Mutex{},
 		orders: map[entity.Side][]entity.Order{
 			entity.Buy:  {},
 			entity.Sell: {},
 		},
-		events:   make(chan event.Event, 10),
-		orderIDs: map[entity.OrderID]entity.Side{},
+		events:    events,
+		orderKeys: map[entity.OrderKey]entity.Side{},
+		options:   newOptions(opts...),
 	}
-	return &engine, engine.events
+}
+
+func NewListEngine(opts ...Option) (MatchingEngine, <-chan event.Event) {
+	engine := newListEngine(make(chan event.Event, 10), opts...)
+	return engine, engine.events
 }
@@ -31,7 +31,7 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "empty book, add sell",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {},
 					entity.Buy:  {},
@@ -52,11 +52,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 			wantOrders: map[entity.Side][]entity.Order{
 				entity.Sell: {
 					{
-						Amount: 10,
-						Price:  100,
-						ID:     1,
-						Side:   entity.Sell,
-						User:   1,
+						Amount:     10,
+						Price:      100,
+						ID:         1,
+						ExchangeID: 1,
+						Side:       entity.Sell,
+						User:       1,
 					},
 				},
 				entity.Buy: {},
@@ -65,7 +66,7 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "empty book, add buy",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {},
 					entity.Buy:  {},
@@ -86,11 +87,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 			wantOrders: map[entity.Side][]entity.Order{
 				entity.Buy: {
 					{
-						Amount: 10,
-						Price:  100,
-						ID:     1,
-						Side:   entity.Buy,
-						User:   1,
+						Amount:     10,
+						Price:      100,
+						ID:         1,
+						ExchangeID: 1,
+						Side:       entity.Buy,
+						User:       1,
 					},
 				},
 				entity.Sell: {},
@@ -99,7 +101,9 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "add sell",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Sell,
+				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
 						{
@@ -128,11 +132,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 			wantOrders: map[entity.Side][]entity.Order{
 				entity.Sell: {
 					{
-						Amount: 10,
-						Price:  100,
-						ID:     1,
-						Side:   entity.Sell,
-						User:   1,
+						Amount:     10,
+						Price:      100,
+						ID:         1,
+						ExchangeID: 1,
+						Side:       entity.Sell,
+						User:       1,
 					},
 					{
 						Amount: 9,
@@ -148,7 +153,9 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "add buy",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Buy,
+				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {},
 					entity.Buy: {
@@ -184,11 +191,68 @@ func Test_listEngine_AddOrder(t *testing.T) {
 						User:   2,
 					},
 					{
-						Amount: 10,
-						Price:  100,
-						ID:     1,
-						Side:   entity.Buy,
-						User:   1,
+						Amount:     10,
+						Price:      100,
+						ID:         1,
+						ExchangeID: 1,
+						Side:       entity.Buy,
+						User:       1,
+					},
+				},
+				entity.Sell: {},
+			},
+		},
+		{
+			name: "add buy with an older timestamp, queues by arrival",
+			engine: &listEngine{
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Buy,
+				},
+				orders: map[entity.Side][]entity.Order{
+					entity.Sell: {},
+					entity.Buy: {
+						{
+							Amount:    9,
+							Price:     100,
+							ID:        2,
+							Side:      entity.Buy,
+							User:      2,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+				},
+				events: make(chan event.Event, 10),
+			},
+			args: args{
+				ctx: context.Background(),
+				order: entity.Order{
+					Amount:    10,
+					Price:     100,
+					ID:        1,
+					Side:      entity.Buy,
+					User:      1,
+					Timestamp: time.UnixMilli(1),
+				},
+			},
+			wantErr: false,
+			wantOrders: map[entity.Side][]entity.Order{
+				entity.Buy: {
+					{
+						Amount:     10,
+						Price:      100,
+						ID:         1,
+						ExchangeID: 1,
+						Side:       entity.Buy,
+						User:       1,
+						Timestamp:  time.UnixMilli(1),
+					},
+					{
+						Amount:    9,
+						Price:     100,
+						ID:        2,
+						Side:      entity.Buy,
+						User:      2,
+						Timestamp: time.UnixMilli(2),
 					},
 				},
 				entity.Sell: {},
@@ -197,7 +261,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "add buy, match, full fill",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Sell,
+					{User: 3, ID: 3}: entity.Sell,
+					{User: 4, ID: 4}: entity.Buy,
+					{User: 5, ID: 5}: entity.Buy,
+				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
 						{
@@ -276,7 +345,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "add sell, match, full fill",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Sell,
+					{User: 3, ID: 3}: entity.Sell,
+					{User: 4, ID: 4}: entity.Buy,
+					{User: 5, ID: 5}: entity.Buy,
+				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
 						{
@@ -355,7 +429,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "add buy, match, full fill, book is larger",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Sell,
+					{User: 3, ID: 3}: entity.Sell,
+					{User: 4, ID: 4}: entity.Buy,
+					{User: 5, ID: 5}: entity.Buy,
+				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
 						{
@@ -441,7 +520,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "add sell, match, full fill, book is larger",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Sell,
+					{User: 3, ID: 3}: entity.Sell,
+					{User: 4, ID: 4}: entity.Buy,
+					{User: 5, ID: 5}: entity.Buy,
+				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
 						{
@@ -527,7 +611,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "add buy, match, full fill, order is larger",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Sell,
+					{User: 3, ID: 3}: entity.Sell,
+					{User: 4, ID: 4}: entity.Buy,
+					{User: 5, ID: 5}: entity.Buy,
+				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
 						{
@@ -601,11 +690,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 						User:   5,
 					},
 					{
-						Amount: 1,
-						Price:  150,
-						ID:     1,
-						Side:   entity.Buy,
-						User:   1,
+						Amount:     1,
+						Price:      150,
+						ID:         1,
+						ExchangeID: 1,
+						Side:       entity.Buy,
+						User:       1,
 					},
 				},
 			},
@@ -613,7 +703,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "add sell, match, full fill, order is larger",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Sell,
+					{User: 3, ID: 3}: entity.Sell,
+					{User: 4, ID: 4}: entity.Buy,
+					{User: 5, ID: 5}: entity.Buy,
+				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
 						{
@@ -678,11 +773,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 						User:   3,
 					},
 					{
-						Amount: 1,
-						Price:  100,
-						ID:     1,
-						Side:   entity.Sell,
-						User:   1,
+						Amount:     1,
+						Price:      100,
+						ID:         1,
+						ExchangeID: 1,
+						Side:       entity.Sell,
+						User:       1,
 					},
 				},
 				entity.Buy: {
@@ -699,7 +795,13 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "add buy, match, full fill two from the book, order is larger",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Sell,
+					{User: 3, ID: 3}: entity.Sell,
+					{User: 3, ID: 6}: entity.Sell,
+					{User: 4, ID: 4}: entity.Buy,
+					{User: 5, ID: 5}: entity.Buy,
+				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
 						{
@@ -719,7 +821,7 @@ func Test_listEngine_AddOrder(t *testing.T) {
 						{
 							Amount: 11,
 							Price:  120,
-							ID:     3,
+							ID:     6,
 							Side:   entity.Sell,
 							User:   3,
 						},
@@ -780,11 +882,12 @@ func Test_listEngine_AddOrder(t *testing.T) {
 						User:   5,
 					},
 					{
-						Amount: 1,
-						Price:  150,
-						ID:     1,
-						Side:   entity.Buy,
-						User:   1,
+						Amount:     1,
+						Price:      150,
+						ID:         1,
+						ExchangeID: 1,
+						Side:       entity.Buy,
+						User:       1,
 					},
 				},
 			},
@@ -792,7 +895,14 @@ func Test_listEngine_AddOrder(t *testing.T) {
 		{
 			name: "add buy, match, full fill 3 from the book, order is larger",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Sell,
+					{User: 3, ID: 3}: entity.Sell,
+					{User: 3, ID: 6}: entity.Sell,
+					{User: 3, ID: 7}: entity.Sell,
+					{User: 4, ID: 4}: entity.Buy,
+					{User: 5, ID: 5}: entity.Buy,
+				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
 						{
@@ -812,14 +922,14 @@ func Test_listEngine_AddOrder(t *testing.T) {
 						{
 							Amount: 10,
 							Price:  130,
-							ID:     3,
+							ID:     6,
 							Side:   entity.Sell,
 							User:   3,
 						},
 						{
 							Amount: 11,
 							Price:  120,
-							ID:     3,
+							ID:     7,
 							Side:   entity.Sell,
 							User:   3,
 						},
@@ -880,15 +990,121 @@ func Test_listEngine_AddOrder(t *testing.T) {
 						User:   5,
 					},
 					{
-						Amount: 1,
-						Price:  150,
-						ID:     1,
+						Amount:     1,
+						Price:      150,
+						ID:         1,
+						ExchangeID: 1,
+						Side:       entity.Buy,
+						User:       1,
+					},
+				},
+			},
+		},
+		{
+			name: "add market buy, match two from the book",
+			engine: &listEngine{
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Sell,
+					{User: 3, ID: 3}: entity.Sell,
+					{User: 4, ID: 4}: entity.Buy,
+				},
+				orders: map[entity.Side][]entity.Order{
+					entity.Sell: {
+						{
+							Amount: 10,
+							Price:  200,
+							ID:     2,
+							Side:   entity.Sell,
+							User:   2,
+						},
+						{
+							Amount: 9,
+							Price:  150,
+							ID:     3,
+							Side:   entity.Sell,
+							User:   3,
+						},
+					},
+					entity.Buy: {
+						{
+							Amount: 9,
+							Price:  90,
+							ID:     4,
+							Side:   entity.Buy,
+							User:   4,
+						},
+					},
+				},
+				events: make(chan event.Event, 10),
+			},
+			args: args{
+				ctx: context.Background(),
+				order: entity.Order{
+					Amount: 15,
+					ID:     1,
+					Side:   entity.Buy,
+					User:   1,
+					Type:   entity.Market,
+				},
+			},
+			wantErr: false,
+			wantOrders: map[entity.Side][]entity.Order{
+				entity.Sell: {
+					{
+						Amount: 4,
+						Price:  200,
+						ID:     2,
+						Side:   entity.Sell,
+						User:   2,
+					},
+				},
+				entity.Buy: {
+					{
+						Amount: 9,
+						Price:  90,
+						ID:     4,
 						Side:   entity.Buy,
-						User:   1,
+						User:   4,
 					},
 				},
 			},
 		},
+		{
+			name: "add market buy, empty the book, remaining does not sit in the book",
+			engine: &listEngine{
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 2, ID: 2}: entity.Sell,
+				},
+				orders: map[entity.Side][]entity.Order{
+					entity.Sell: {
+						{
+							Amount: 10,
+							Price:  200,
+							ID:     2,
+							Side:   entity.Sell,
+							User:   2,
+						},
+					},
+					entity.Buy: {},
+				},
+				events: make(chan event.Event, 10),
+			},
+			args: args{
+				ctx: context.Background(),
+				order: entity.Order{
+					Amount: 15,
+					ID:     1,
+					Side:   entity.Buy,
+					User:   1,
+					Type:   entity.Market,
+				},
+			},
+			wantErr: false,
+			wantOrders: map[entity.Side][]entity.Order{
+				entity.Sell: {},
+				entity.Buy:  {},
+			},
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -908,6 +1124,7 @@ func Test_listEngine_CancelOrder(t *testing.T) {
 	t.Parallel()
 	type args struct {
 		ctx     context.Context
+		user    entity.UserID
 		orderID entity.OrderID
 	}
 	tests := []struct {
@@ -922,19 +1139,21 @@ func Test_listEngine_CancelOrder(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name:   "order does not exist",
-			engine: &listEngine{},
+			name: "order does not exist",
+			engine: &listEngine{
+				events: make(chan event.Event, 10),
+			},
 			args: args{
 				ctx:     context.Background(),
 				orderID: 1,
 			},
-			wantErr: true,
+			wantErr: false,
 		},
 		{
 			name: "delete sell order - get empty book",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{
-					1: entity.Sell,
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{ID: 1}: entity.Sell,
 				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
@@ -957,9 +1176,9 @@ func Test_listEngine_CancelOrder(t *testing.T) {
 		{
 			name: "delete sell order - last order",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{
-					1: entity.Sell,
-					2: entity.Sell,
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{ID: 1}: entity.Sell,
+					{ID: 2}: entity.Sell,
 				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
@@ -989,9 +1208,9 @@ func Test_listEngine_CancelOrder(t *testing.T) {
 		{
 			name: "delete sell order - first order",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{
-					1: entity.Sell,
-					2: entity.Sell,
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{ID: 1}: entity.Sell,
+					{ID: 2}: entity.Sell,
 				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
@@ -1021,10 +1240,10 @@ func Test_listEngine_CancelOrder(t *testing.T) {
 		{
 			name: "delete sell order - middle order",
 			engine: &listEngine{
-				orderIDs: map[entity.OrderID]entity.Side{
-					1: entity.Sell,
-					2: entity.Sell,
-					3: entity.Sell,
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{ID: 1}: entity.Sell,
+					{ID: 2}: entity.Sell,
+					{ID: 3}: entity.Sell,
 				},
 				orders: map[entity.Side][]entity.Order{
 					entity.Sell: {
@@ -1057,12 +1276,43 @@ func Test_listEngine_CancelOrder(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "delete order of another user",
+			engine: &listEngine{
+				orderKeys: map[entity.OrderKey]entity.Side{
+					{User: 1, ID: 1}: entity.Sell,
+				},
+				orders: map[entity.Side][]entity.Order{
+					entity.Sell: {
+						{
+							ID:   1,
+							User: 1,
+						},
+					},
+				},
+				events: make(chan event.Event, 10),
+			},
+			args: args{
+				ctx:     context.Background(),
+				user:    2,
+				orderID: 1,
+			},
+			wantErr: false,
+			wantOrders: map[entity.Side][]entity.Order{
+				entity.Sell: {
+					{
+						ID:   1,
+						User: 1,
+					},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			if err := tt.engine.CancelOrder(tt.args.ctx, tt.args.orderID); (err != nil) != tt.wantErr {
+			if err := tt.engine.CancelOrder(tt.args.ctx, entity.OrderKey{User: tt.args.user, ID: tt.args.orderID}); (err != nil) != tt.wantErr {
 				t.Errorf("CancelOrder() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if tt.engine != nil && !reflect.DeepEqual(tt.wantOrders, tt.engine.orders) {
@@ -1103,129 +1353,13 @@ func Test_listEngine_ProcessTransaction(t *testing.T) {
 		args       args
 		wantEvents []string
 	}{
-		//{
-		//	name: "scenario 1 balanced book",
-		//	engine: &listEngine{
-		//		orders:   map[entity.Side][]entity.Order{},
-		//		events:   make(chan event.Event, 50),
-		//		orderIDs: map[entity.OrderID]entity.Side{},
-		//	},
-		//	args: args{
-		//		ctx: context.Background(),
-		//		transactions: []io.Transaction{
-		//			io.NewOrderTransaction{
-		//				Symbol: "IBM",
-		//				Order: entity.Order{
-		//					Amount:    100,
-		//					Price:     10,
-		//					ID:        1,
-		//					Side:      entity.Buy,
-		//					User:      1,
-		//					Timestamp: time.UnixMilli(1),
-		//				},
-		//			},
-		//			io.NewOrderTransaction{
-		//				Symbol: "IBM",
-		//				Order: entity.Order{
-		//					Amount:    100,
-		//					Price:     12,
-		//					ID:        2,
-		//					Side:      entity.Sell,
-		//					User:      1,
-		//					Timestamp: time.UnixMilli(2),
-		//				},
-		//			},
-		//			io.NewOrderTransaction{
-		//				Symbol: "IBM",
-		//				Order: entity.Order{
-		//					Amount:    100,
-		//					Price:     9,
-		//					ID:        101,
-		//					Side:      entity.Buy,
-		//					User:      2,
-		//					Timestamp: time.UnixMilli(3),
-		//				},
-		//			},
-		//			io.NewOrderTransaction{
-		//				Symbol: "IBM",
-		//				Order: entity.Order{
-		//					Amount:    100,
-		//					Price:     11,
-		//					ID:        102,
-		//					Side:      entity.Sell,
-		//					User:      2,
-		//					Timestamp: time.UnixMilli(4),
-		//				},
-		//			},
-		//			io.NewOrderTransaction{
-		//				Symbol: "IBM",
-		//				Order: entity.Order{
-		//					Amount:    100,
-		//					Price:     11,
-		//					ID:        3,
-		//					Side:      entity.Buy,
-		//					User:      1,
-		//					Timestamp: time.UnixMilli(5),
-		//				},
-		//			},
-		//			io.NewOrderTransaction{
-		//				Symbol: "IBM",
-		//				Order: entity.Order{
-		//					Amount:    100,
-		//					Price:     10,
-		//					ID:        103,
-		//					Side:      entity.Sell,
-		//					User:      2,
-		//					Timestamp: time.UnixMilli(6),
-		//				},
-		//			},
-		//			io.NewOrderTransaction{
-		//				Symbol: "IBM",
-		//				Order: entity.Order{
-		//					Amount:    100,
-		//					Price:     10,
-		//					ID:        4,
-		//					Side:      entity.Buy,
-		//					User:      1,
-		//					Timestamp: time.UnixMilli(7),
-		//				},
-		//			},
-		//			io.NewOrderTransaction{
-		//				Symbol: "IBM",
-		//				Order: entity.Order{
-		//					Amount:    100,
-		//					Price:     11,
-		//					ID:        104,
-		//					Side:      entity.Sell,
-		//					User:      2,
-		//					Timestamp: time.UnixMilli(8),
-		//				},
-		//			},
-		//			io.FlushAllOrdersTransaction{},
-		//		},
-		//	},
-		//	wantEvents: []string{
-		//		"A, 1, 1",
-		//		"B, B, 10, 100",
-		//		"A, 1, 2",
-		//		"B, S, 12, 100",
-		//		"A, 2, 101",
-		//		"A, 2, 102",
-		//		"B, S, 11, 100",
-		//		"R, 1, 3",
-		//		"R, 2, 103",
-		//		"A, 1, 4",
-		//		"B, B, 10, 200",
-		//		"A, 2, 104",
-		//		"B, S, 11, 200",
-		//	},
-		//},
 		{
-			name: "scenario 8 balanced book, limit buy partial",
+			name: "scenario 1 balanced book, reject crossing",
 			engine: &listEngine{
-				orders:   map[entity.Side][]entity.Order{},
-				events:   make(chan event.Event, 50),
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
+				options:   options{mode: RejectCrossing},
 			},
 			args: args{
 				ctx: context.Background(),
@@ -1277,7 +1411,7 @@ func Test_listEngine_ProcessTransaction(t *testing.T) {
 					io.NewOrderTransaction{
 						Symbol: "IBM",
 						Order: entity.Order{
-							Amount:    20,
+							Amount:    100,
 							Price:     11,
 							ID:        3,
 							Side:      entity.Buy,
@@ -1285,6 +1419,39 @@ func Test_listEngine_ProcessTransaction(t *testing.T) {
 							Timestamp: time.UnixMilli(5),
 						},
 					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     10,
+							ID:        103,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(6),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     10,
+							ID:        4,
+							Side:      entity.Buy,
+							User:      1,
+							Timestamp: time.UnixMilli(7),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     11,
+							ID:        104,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(8),
+						},
+					},
 					io.FlushAllOrdersTransaction{},
 				},
 			},
@@ -1296,17 +1463,20 @@ func Test_listEngine_ProcessTransaction(t *testing.T) {
 				"A, 2, 101",
 				"A, 2, 102",
 				"B, S, 11, 100",
-				"A, 1, 3",
-				"T, 1, 3, 2, 102, 11, 20",
-				"B, S, 11, 80",
+				"R, 1, 3",
+				"R, 2, 103",
+				"A, 1, 4",
+				"B, B, 10, 200",
+				"A, 2, 104",
+				"B, S, 11, 200",
 			},
 		},
 		{
-			name: "scenario 9 balanced book, cancel best bid and offer",
+			name: "scenario 8 balanced book, limit buy partial",
 			engine: &listEngine{
-				orders:   map[entity.Side][]entity.Order{},
-				events:   make(chan event.Event, 50),
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
 			},
 			args: args{
 				ctx: context.Background(),
@@ -1355,13 +1525,16 @@ func Test_listEngine_ProcessTransaction(t *testing.T) {
 							Timestamp: time.UnixMilli(4),
 						},
 					},
-					io.CancelOrderTransaction{
-						User:    1,
-						OrderID: 1,
-					},
-					io.CancelOrderTransaction{
-						User:    2,
-						OrderID: 102,
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    20,
+							Price:     11,
+							ID:        3,
+							Side:      entity.Buy,
+							User:      1,
+							Timestamp: time.UnixMilli(5),
+						},
 					},
 					io.FlushAllOrdersTransaction{},
 				},
@@ -1374,18 +1547,17 @@ func Test_listEngine_ProcessTransaction(t *testing.T) {
 				"A, 2, 101",
 				"A, 2, 102",
 				"B, S, 11, 100",
-				"A, 1, 1",
-				"B, B, 9, 100",
-				"A, 2, 102",
-				"B, S, 12, 100",
+				"A, 1, 3",
+				"T, 1, 3, 2, 102, 11, 20",
+				"B, S, 11, 80",
 			},
 		},
 		{
-			name: "scenario 10 balanced book, cancel behind best bid and offer",
+			name: "scenario 9 balanced book, cancel best bid and offer",
 			engine: &listEngine{
-				orders:   map[entity.Side][]entity.Order{},
-				events:   make(chan event.Event, 50),
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
 			},
 			args: args{
 				ctx: context.Background(),
@@ -1436,11 +1608,11 @@ func Test_listEngine_ProcessTransaction(t *testing.T) {
 					},
 					io.CancelOrderTransaction{
 						User:    1,
-						OrderID: 2,
+						OrderID: 1,
 					},
 					io.CancelOrderTransaction{
 						User:    2,
-						OrderID: 101,
+						OrderID: 102,
 					},
 					io.FlushAllOrdersTransaction{},
 				},
@@ -1453,16 +1625,95 @@ func Test_listEngine_ProcessTransaction(t *testing.T) {
 				"A, 2, 101",
 				"A, 2, 102",
 				"B, S, 11, 100",
-				"A, 1, 2",
-				"A, 2, 101",
-			},
+				"A, 1, 1",
+				"B, B, 9, 100",
+				"A, 2, 102",
+				"B, S, 12, 100",
+			},
+		},
+		{
+			name: "scenario 10 balanced book, cancel behind best bid and offer",
+			engine: &listEngine{
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
+			},
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     10,
+							ID:        1,
+							Side:      entity.Buy,
+							User:      1,
+							Timestamp: time.UnixMilli(1),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     12,
+							ID:        2,
+							Side:      entity.Sell,
+							User:      1,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     9,
+							ID:        101,
+							Side:      entity.Buy,
+							User:      2,
+							Timestamp: time.UnixMilli(3),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     11,
+							ID:        102,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(4),
+						},
+					},
+					io.CancelOrderTransaction{
+						User:    1,
+						OrderID: 2,
+					},
+					io.CancelOrderTransaction{
+						User:    2,
+						OrderID: 101,
+					},
+					io.FlushAllOrdersTransaction{},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, B, 10, 100",
+				"A, 1, 2",
+				"B, S, 12, 100",
+				"A, 2, 101",
+				"A, 2, 102",
+				"B, S, 11, 100",
+				"A, 1, 2",
+				"A, 2, 101",
+			},
 		},
 		{
 			name: "scenario 11 balanced book, cancel all bids",
 			engine: &listEngine{
-				orders:   map[entity.Side][]entity.Order{},
-				events:   make(chan event.Event, 50),
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
 			},
 			args: args{
 				ctx: context.Background(),
@@ -1539,9 +1790,9 @@ func Test_listEngine_ProcessTransaction(t *testing.T) {
 		{
 			name: "scenario 12 balanced book, TOB volume changes",
 			engine: &listEngine{
-				orders:   map[entity.Side][]entity.Order{},
-				events:   make(chan event.Event, 50),
-				orderIDs: map[entity.OrderID]entity.Side{},
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
 			},
 			args: args{
 				ctx: context.Background(),
@@ -1634,21 +1885,894 @@ func Test_listEngine_ProcessTransaction(t *testing.T) {
 				"B, S, -, -",
 			},
 		},
-	}
-	for _, tt := range tests {
-		tt := tt
-		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
-			for i, transaction := range tt.args.transactions {
-				if err := tt.engine.ProcessTransaction(tt.args.ctx, transaction); err != nil {
-					t.Errorf("ProcessTransaction(%d) error = %v", i, err)
-				}
-			}
-			tt.engine.Close()
-			gotEvents := toListEventsOutput(tt.args.ctx, tt.engine.events)
-			if !reflect.DeepEqual(gotEvents, tt.wantEvents) {
-				t.Errorf("ProcessTransaction() events: %v, want: %v", gotEvents, tt.wantEvents)
-			}
-		})
+		{
+			name: "market orders sweep the book",
+			engine: &listEngine{
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
+			},
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     10,
+							ID:        1,
+							Side:      entity.Buy,
+							User:      1,
+							Timestamp: time.UnixMilli(1),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     12,
+							ID:        2,
+							Side:      entity.Sell,
+							User:      1,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     11,
+							ID:        102,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(3),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    150,
+							ID:        3,
+							Side:      entity.Buy,
+							User:      3,
+							Type:      entity.Market,
+							Timestamp: time.UnixMilli(4),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    200,
+							ID:        4,
+							Side:      entity.Sell,
+							User:      4,
+							Type:      entity.Market,
+							Timestamp: time.UnixMilli(5),
+						},
+					},
+					io.FlushAllOrdersTransaction{},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, B, 10, 100",
+				"A, 1, 2",
+				"B, S, 12, 100",
+				"A, 2, 102",
+				"B, S, 11, 100",
+				"A, 3, 3",
+				"T, 3, 3, 2, 102, 11, 100",
+				"T, 3, 3, 1, 2, 12, 50",
+				"B, S, 12, 50",
+				"A, 4, 4",
+				"T, 1, 1, 4, 4, 10, 100",
+				"B, B, -, -",
+			},
+		},
+		{
+			name: "immediate or cancel and fill or kill",
+			engine: &listEngine{
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
+			},
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     10,
+							ID:        1,
+							Side:      entity.Buy,
+							User:      1,
+							Timestamp: time.UnixMilli(1),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     12,
+							ID:        2,
+							Side:      entity.Sell,
+							User:      1,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     11,
+							ID:        102,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(3),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:      150,
+							Price:       11,
+							ID:          3,
+							Side:        entity.Buy,
+							User:        3,
+							TimeInForce: entity.ImmediateOrCancel,
+							Timestamp:   time.UnixMilli(4),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:      150,
+							Price:       12,
+							ID:          4,
+							Side:        entity.Buy,
+							User:        4,
+							TimeInForce: entity.FillOrKill,
+							Timestamp:   time.UnixMilli(5),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:      100,
+							Price:       12,
+							ID:          5,
+							Side:        entity.Buy,
+							User:        5,
+							TimeInForce: entity.FillOrKill,
+							Timestamp:   time.UnixMilli(6),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:      50,
+							Price:       10,
+							ID:          6,
+							Side:        entity.Sell,
+							User:        6,
+							TimeInForce: entity.ImmediateOrCancel,
+							Timestamp:   time.UnixMilli(7),
+						},
+					},
+					io.FlushAllOrdersTransaction{},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, B, 10, 100",
+				"A, 1, 2",
+				"B, S, 12, 100",
+				"A, 2, 102",
+				"B, S, 11, 100",
+				"A, 3, 3",
+				"T, 3, 3, 2, 102, 11, 100",
+				"B, S, 12, 100",
+				"A, 4, 4",
+				"A, 5, 5",
+				"T, 5, 5, 1, 2, 12, 100",
+				"B, S, -, -",
+				"A, 6, 6",
+				"T, 1, 1, 6, 6, 10, 50",
+				"B, B, 10, 50",
+			},
+		},
+		{
+			name: "post only orders do not take liquidity",
+			engine: &listEngine{
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
+			},
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     10,
+							ID:        1,
+							Side:      entity.Buy,
+							User:      1,
+							Timestamp: time.UnixMilli(1),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    100,
+							Price:     12,
+							ID:        2,
+							Side:      entity.Sell,
+							User:      1,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    50,
+							Price:     12,
+							ID:        3,
+							Side:      entity.Buy,
+							User:      3,
+							PostOnly:  entity.PostOnlyReject,
+							Timestamp: time.UnixMilli(3),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    50,
+							Price:     13,
+							ID:        4,
+							Side:      entity.Buy,
+							User:      4,
+							PostOnly:  entity.PostOnlyReprice,
+							Timestamp: time.UnixMilli(4),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    30,
+							Price:     10,
+							ID:        5,
+							Side:      entity.Sell,
+							User:      5,
+							PostOnly:  entity.PostOnlyReprice,
+							Timestamp: time.UnixMilli(5),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    20,
+							Price:     13,
+							ID:        6,
+							Side:      entity.Sell,
+							User:      6,
+							PostOnly:  entity.PostOnlyReject,
+							Timestamp: time.UnixMilli(6),
+						},
+					},
+					io.FlushAllOrdersTransaction{},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, B, 10, 100",
+				"A, 1, 2",
+				"B, S, 12, 100",
+				"R, 3, 3",
+				"A, 4, 4",
+				"B, B, 11, 50",
+				"A, 5, 5",
+				"B, S, 12, 130",
+				"A, 6, 6",
+			},
+		},
+		{
+			name: "iceberg orders only show the visible slice",
+			engine: &listEngine{
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
+			},
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:        30,
+							Price:         11,
+							ID:            1,
+							Side:          entity.Sell,
+							User:          1,
+							DisplayAmount: 10,
+							Timestamp:     time.UnixMilli(1),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    20,
+							Price:     11,
+							ID:        2,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    15,
+							Price:     11,
+							ID:        3,
+							Side:      entity.Buy,
+							User:      3,
+							Timestamp: time.UnixMilli(3),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    40,
+							Price:     11,
+							ID:        4,
+							Side:      entity.Buy,
+							User:      4,
+							Timestamp: time.UnixMilli(4),
+						},
+					},
+					io.FlushAllOrdersTransaction{},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, S, 11, 10",
+				"A, 2, 2",
+				"B, S, 11, 30",
+				"A, 3, 3",
+				"T, 3, 3, 1, 1, 11, 10",
+				"T, 3, 3, 2, 2, 11, 5",
+				"B, S, 11, 25",
+				"A, 4, 4",
+				"T, 4, 4, 2, 2, 11, 15",
+				"T, 4, 4, 1, 1, 11, 10",
+				"T, 4, 4, 1, 1, 11, 10",
+				"B, B, 11, 5",
+				"B, S, -, -",
+			},
+		},
+		{
+			name: "stop orders are triggered by trades, including the ones of other stops",
+			engine: &listEngine{
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
+			},
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     11,
+							ID:        1,
+							Side:      entity.Sell,
+							User:      1,
+							Timestamp: time.UnixMilli(1),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     12,
+							ID:        2,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     0,
+							ID:        3,
+							Side:      entity.Buy,
+							User:      3,
+							Type:      entity.Market,
+							StopPrice: 11,
+							Timestamp: time.UnixMilli(3),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     12,
+							ID:        4,
+							Side:      entity.Buy,
+							User:      4,
+							StopPrice: 12,
+							Timestamp: time.UnixMilli(4),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    1,
+							Price:     5,
+							ID:        5,
+							Side:      entity.Sell,
+							User:      5,
+							StopPrice: 5,
+							Timestamp: time.UnixMilli(5),
+						},
+					},
+					io.CancelOrderTransaction{
+						User:    5,
+						OrderID: 5,
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    5,
+							Price:     11,
+							ID:        6,
+							Side:      entity.Buy,
+							User:      6,
+							Timestamp: time.UnixMilli(6),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    1,
+							Price:     9,
+							ID:        7,
+							Side:      entity.Sell,
+							User:      7,
+							StopPrice: 10,
+							Timestamp: time.UnixMilli(7),
+						},
+					},
+					io.FlushAllOrdersTransaction{},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    1,
+							Price:     10,
+							ID:        8,
+							Side:      entity.Sell,
+							User:      8,
+							Timestamp: time.UnixMilli(8),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    1,
+							Price:     10,
+							ID:        9,
+							Side:      entity.Buy,
+							User:      9,
+							Timestamp: time.UnixMilli(9),
+						},
+					},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, S, 11, 10",
+				"A, 2, 2",
+				"A, 3, 3",
+				"A, 4, 4",
+				"A, 5, 5",
+				"A, 5, 5",
+				"A, 6, 6",
+				"T, 6, 6, 1, 1, 11, 5",
+				"B, S, 11, 5",
+				"T, 3, 3, 1, 1, 11, 5",
+				"T, 3, 3, 2, 2, 12, 5",
+				"B, S, 12, 5",
+				"T, 4, 4, 2, 2, 12, 5",
+				"B, B, 12, 5",
+				"B, S, -, -",
+				"A, 7, 7",
+				"A, 8, 8",
+				"B, S, 10, 1",
+				"A, 9, 9",
+				"T, 9, 9, 8, 8, 10, 1",
+				"B, S, -, -",
+			},
+		},
+		{
+			name: "replace keeps the time priority only when reducing the amount",
+			engine: &listEngine{
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
+			},
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     12,
+							ID:        1,
+							Side:      entity.Sell,
+							User:      1,
+							Timestamp: time.UnixMilli(1),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     12,
+							ID:        2,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+					io.ReplaceOrderTransaction{
+						User:    1,
+						OrderID: 1,
+						Price:   12,
+						Amount:  5,
+					},
+					io.ReplaceOrderTransaction{
+						User:    2,
+						OrderID: 2,
+						Price:   12,
+						Amount:  15,
+					},
+					io.ReplaceOrderTransaction{
+						User:    1,
+						OrderID: 1,
+						Price:   12,
+						Amount:  8,
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    15,
+							Price:     12,
+							ID:        3,
+							Side:      entity.Buy,
+							User:      3,
+							Timestamp: time.UnixMilli(3),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    5,
+							Price:     10,
+							ID:        4,
+							Side:      entity.Buy,
+							User:      4,
+							Timestamp: time.UnixMilli(4),
+						},
+					},
+					io.ReplaceOrderTransaction{
+						User:    4,
+						OrderID: 4,
+						Price:   12,
+						Amount:  5,
+					},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, S, 12, 10",
+				"A, 2, 2",
+				"B, S, 12, 20",
+				"A, 1, 1",
+				"B, S, 12, 15",
+				"A, 2, 2",
+				"B, S, 12, 20",
+				"A, 1, 1",
+				"B, S, 12, 23",
+				"A, 3, 3",
+				"T, 3, 3, 2, 2, 12, 15",
+				"B, S, 12, 8",
+				"A, 4, 4",
+				"B, B, 10, 5",
+				"A, 4, 4",
+				"T, 4, 4, 1, 1, 12, 5",
+				"B, B, -, -",
+				"B, S, 12, 3",
+			},
+		},
+		{
+			name: "rejected replace leaves the order in the book",
+			engine: &listEngine{
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
+				// The static band goes from 90 to 110 once the book trades at 100.
+				options: newOptions(WithPriceBands(PriceBands{Static: 1000})),
+			},
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    5,
+							Price:     50,
+							ID:        1,
+							Side:      entity.Buy,
+							User:      1,
+							PostOnly:  entity.PostOnlyReprice,
+							Timestamp: time.UnixMilli(1),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    1,
+							Price:     100,
+							ID:        2,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    1,
+							Price:     100,
+							ID:        3,
+							Side:      entity.Buy,
+							User:      3,
+							Timestamp: time.UnixMilli(3),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    5,
+							Price:     90,
+							ID:        4,
+							Side:      entity.Sell,
+							User:      4,
+							Timestamp: time.UnixMilli(4),
+						},
+					},
+					// Repriced to 89 the order would be outside the static band.
+					io.ReplaceOrderTransaction{
+						User:    1,
+						OrderID: 1,
+						Price:   90,
+						Amount:  5,
+					},
+					io.CancelOrderTransaction{
+						User:    1,
+						OrderID: 1,
+					},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, B, 50, 5",
+				"A, 2, 2",
+				"B, S, 100, 1",
+				"A, 3, 3",
+				"T, 3, 3, 2, 2, 100, 1",
+				"B, S, -, -",
+				"A, 4, 4",
+				"B, S, 90, 5",
+				"R, 1, 1",
+				"A, 1, 1",
+				"B, B, -, -",
+			},
+		},
+		{
+			name: "only the owner can cancel or replace an order",
+			engine: &listEngine{
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
+			},
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     12,
+							ID:        1,
+							Side:      entity.Sell,
+							User:      1,
+							Timestamp: time.UnixMilli(1),
+						},
+					},
+					io.CancelOrderTransaction{
+						User:    2,
+						OrderID: 1,
+					},
+					io.ReplaceOrderTransaction{
+						User:    2,
+						OrderID: 1,
+						Price:   12,
+						Amount:  5,
+					},
+					io.CancelOrderTransaction{
+						User:    1,
+						OrderID: 1,
+					},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, S, 12, 10",
+				"R, 2, 1",
+				"R, 2, 1",
+				"A, 1, 1",
+				"B, S, -, -",
+			},
+		},
+		{
+			name: "users can have orders with the same id",
+			engine: &listEngine{
+				orders:    map[entity.Side][]entity.Order{},
+				events:    make(chan event.Event, 50),
+				orderKeys: map[entity.OrderKey]entity.Side{},
+			},
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     12,
+							ID:        1,
+							Side:      entity.Sell,
+							User:      1,
+							Timestamp: time.UnixMilli(1),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    5,
+							Price:     11,
+							ID:        1,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+					io.CancelOrderTransaction{
+						User:    2,
+						OrderID: 1,
+					},
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    4,
+							Price:     12,
+							ID:        1,
+							Side:      entity.Buy,
+							User:      3,
+							Timestamp: time.UnixMilli(3),
+						},
+					},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, S, 12, 10",
+				"A, 2, 1",
+				"B, S, 11, 5",
+				"A, 2, 1",
+				"B, S, 12, 10",
+				"A, 3, 1",
+				"T, 3, 1, 1, 1, 12, 4",
+				"B, S, 12, 6",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			for i, transaction := range tt.args.transactions {
+				if err := tt.engine.ProcessTransaction(tt.args.ctx, transaction); err != nil {
+					t.Errorf("ProcessTransaction(%d) error = %v", i, err)
+				}
+			}
+			tt.engine.Close()
+			gotEvents := toListEventsOutput(tt.args.ctx, tt.engine.events)
+			if !reflect.DeepEqual(gotEvents, tt.wantEvents) {
+				t.Errorf("ProcessTransaction() events: %v, want: %v", gotEvents, tt.wantEvents)
+			}
+
+			// The tree engine has to behave exactly like the list one.
+			tree := newTreeEngine(make(chan event.Event, cap(tt.engine.events)))
+			tree.options = tt.engine.options
+			for i, transaction := range tt.args.transactions {
+				if err := tree.ProcessTransaction(tt.args.ctx, transaction); err != nil {
+					t.Errorf("tree ProcessTransaction(%d) error = %v", i, err)
+				}
+			}
+			tree.Close()
+			if gotTreeEvents := toListEventsOutput(tt.args.ctx, tree.events); !reflect.DeepEqual(gotTreeEvents, gotEvents) {
+				t.Errorf("tree ProcessTransaction() events: %v, want: %v", gotTreeEvents, gotEvents)
+			}
+		})
+	}
+}
+
+func Test_listEngine_notional(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	// The prices and the amounts have the scales of the crypto currencies, 8 decimal places each.
+	instruments := entity.Instruments{"BTC": {Symbol: "BTC", Scale: entity.Scale{Price: 8, Quantity: 8}}}
+	for _, storage := range []Storage{ListStorage, TreeStorage} {
+		events := make(chan event.Event, 50)
+		var engine MatchingEngine = newListEngine(events, WithInstruments(instruments))
+		if storage == TreeStorage {
+			engine = newTreeEngine(events, WithInstruments(instruments))
+		}
+		orders := []entity.Order{
+			// 1000 at 100000, the notional does not fit in 64 bits.
+			{Symbol: "BTC", Amount: 1_000_00000000, Price: 100_000_00000000, ID: 1, Side: entity.Sell, User: 1},
+			{Symbol: "BTC", Amount: 500_00000000, Price: 100_000_00000000, ID: 2, Side: entity.Buy, User: 2},
+		}
+		for _, order := range orders {
+			if err := engine.AddOrder(ctx, order); err != nil {
+				t.Errorf("%v AddOrder(%v) error = %v", storage, order.ID, err)
+			}
+		}
+		// Increasing the amount of the resting order keeps it.
+		key := entity.OrderKey{User: 1, ID: 1}
+		if err := engine.ReplaceOrder(ctx, key, 100_000_00000000, 2_000_00000000); err != nil {
+			t.Errorf("%v ReplaceOrder() error = %v", storage, err)
+		}
+		engine.Close()
+
+		var rejected []event.RejectReason
+		var tradeNotional, filledNotional entity.Decimal128
+		for evt := range events {
+			switch it := evt.(type) {
+			case *event.OrderRejected:
+				rejected = append(rejected, it.Reason)
+			case *event.RequestRejected:
+				rejected = append(rejected, it.Reason)
+			case *event.TradeGenerated:
+				tradeNotional = it.Trade.Notional
+			case *event.OrderFilled:
+				filledNotional = it.Notional
+			}
+		}
+		if len(rejected) > 0 {
+			t.Errorf("%v rejected: %v", storage, rejected)
+		}
+		want := "50000000.0000000000000000"
+		if tradeNotional.Format(16) != want || filledNotional.Format(16) != want {
+			t.Errorf("%v notional: trade %v, filled %v, want %v", storage, tradeNotional, filledNotional, want)
+		}
 	}
 }
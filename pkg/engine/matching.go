@@ -0,0 +1,630 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+// book is the storage of the resting orders of a MatchingEngine.
+// The matching rules are implemented on top of it, so every engine generates the same events.
+type book interface {
+	// best gives the order with the highest priority on the side, nil for an empty side.
+	// The order can be changed in place while no other order is added or removed.
+	best(side entity.Side) *entity.Order
+	// removeBest takes the best order out of the side.
+	removeBest(side entity.Side)
+	// insert adds the order to the book behind the ones with the same price.
+	insert(order entity.Order)
+	// remove takes the order out of the book.
+	remove(key entity.OrderKey) (entity.Order, bool)
+	// sideOf tells the side of an order in the book.
+	sideOf(key entity.OrderKey) (entity.Side, bool)
+	// find gives the order in the book, nil when it is not there.
+	// The order can be changed in place as long as its price stays the same.
+	find(key entity.OrderKey) *entity.Order
+	// walk visits the orders of the side in priority order until fn returns false.
+	walk(side entity.Side, fn func(order *entity.Order) bool)
+	// levelQuantity gives the total amount in the book for the price.
+	levelQuantity(side entity.Side, price entity.Decimal) entity.Decimal
+	// publish sends the event to the consumers of the engine.
+	publish(evt event.Event)
+	// config gives the options used to create the engine.
+	config() *options
+	// state gives what the engine keeps besides the resting orders.
+	state() *engineState
+}
+
+// topLevel is the best price of a side and the amount available for it.
+type topLevel struct {
+	price         entity.Decimal
+	totalQuantity entity.Decimal
+}
+
+func topLevels(b book) map[entity.Side]*topLevel {
+	resp := map[entity.Side]*topLevel{}
+	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
+		if top := b.best(side); top != nil {
+			resp[side] = &topLevel{
+				price:         top.Price,
+				totalQuantity: b.levelQuantity(side, top.Price),
+			}
+		}
+	}
+	return resp
+}
+
+// publishBookChanges compares the top of the book with the one before the change, publishing the differences, and
+// the depth of the levels touched since.
+// During a call auction it also publishes the changes to the indicative uncrossing.
+func publishBookChanges(b book, before map[entity.Side]*topLevel) {
+	if b.state().session == entity.Auction {
+		defer publishIndicative(b, false)
+	}
+	defer publishDepthChanges(b)
+	after := topLevels(b)
+	cfg := b.config()
+	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
+		if before[side] == nil && after[side] == nil {
+			continue
+		}
+		if after[side] == nil {
+			b.publish(&event.TopOfBookChange{
+				Symbol: cfg.symbol,
+				Side:   side,
+				Scale:  cfg.scale(),
+			})
+		} else if before[side] == nil || *before[side] != *after[side] {
+			b.publish(&event.TopOfBookChange{
+				Symbol:        cfg.symbol,
+				Side:          side,
+				Price:         after[side].price,
+				TotalQuantity: after[side].totalQuantity,
+				Scale:         cfg.scale(),
+			})
+		}
+	}
+}
+
+// orderExists checks the order both in the book and waiting as a stop.
+func orderExists(b book, key entity.OrderKey) bool {
+	_, inBook := b.sideOf(key)
+	return inBook || b.state().stops.contains(key)
+}
+
+func orderExistsError(key entity.OrderKey) error {
+	return fmt.Errorf("order %v of user %v alreday exists", key.ID, key.User)
+}
+
+// submitOrder sends the order to the book, or keeps it aside for stop orders, triggering the stops crossed by its
+// trades.
+func submitOrder(b book, order entity.Order) error {
+	if order.TimeInForce == entity.GoodTillTime && order.ExpireAt.IsZero() {
+		return missingExpiryError
+	}
+	expireOrders(b)
+	cfg := b.config()
+	reject := func(reason event.RejectReason) error {
+		b.publish(&event.OrderRejected{
+			Symbol: cfg.symbol,
+			Order:  order,
+			Reason: reason,
+		})
+		return nil
+	}
+	if !b.state().session.AcceptsOrders() {
+		return reject(event.RejectSession)
+	}
+	prices := []entity.Decimal{order.Price, order.StopPrice}
+	amount := order.Amount + order.HiddenAmount
+	if reason, invalid := instrumentViolation(cfg, orderSymbol(cfg, order), prices, amount); invalid {
+		return reject(reason)
+	}
+	if _, ok := order.Notional(); !ok {
+		return reject(event.RejectNotional)
+	}
+	if !withinStaticBand(b, orderSymbol(cfg, order), order.Price) {
+		return reject(event.RejectPriceBand)
+	}
+	if order.StopPrice > 0 {
+		return addStop(b, order)
+	}
+	if err := addOrder(b, order, true); err != nil {
+		return err
+	}
+	triggerStops(b)
+	return nil
+}
+
+// addOrder matches the order against the book, acknowledge is false for triggered stops as they were acknowledged
+// when accepted.
+// The breaker changes the session once the changes of the match are published.
+func addOrder(b book, order entity.Order, acknowledge bool) error {
+	before := topLevels(b)
+	tripped, err := matchOrder(b, order, acknowledge)
+	publishBookChanges(b, before)
+	if tripped {
+		tripBreaker(b)
+	}
+	return err
+}
+
+// matchOrder is addOrder without publishing the changes to the book, it tells if the match tripped the breaker.
+func matchOrder(b book, order entity.Order, acknowledge bool) (bool, error) {
+	// The hidden amount is managed by the engine, the order arrives with the whole amount.
+	order.Amount += order.HiddenAmount
+	order.HiddenAmount = 0
+	if order.Amount == 0 {
+		return false, invalidOrderAmountError
+	}
+	cfg := b.config()
+
+	if orderExists(b, order.Key()) {
+		return false, orderExistsError(order.Key())
+	}
+	if b.state().session.Collecting() {
+		return false, collectOrder(b, order, acknowledge)
+	}
+
+	opposite := order.Side.Opposite()
+	var repriced *event.OrderRepriced
+	if order.PostOnly != entity.TakeLiquidity {
+		if _, trade := order.Match(b.best(opposite), cfg.timeSource()); trade != nil {
+			price, ok := postOnlyPrice(b, order, b.best(opposite))
+			if order.PostOnly == entity.PostOnlyReject || !ok {
+				b.publish(&event.OrderRejected{
+					Symbol: cfg.symbol,
+					Order:  order,
+					Reason: event.RejectPostOnly,
+				})
+				return false, nil
+			}
+			repriced = &event.OrderRepriced{
+				Symbol:        cfg.symbol,
+				OriginalPrice: order.Price,
+			}
+			order.Price = price
+		}
+	}
+
+	if cfg.mode == RejectCrossing && order.Type == entity.Limit {
+		if _, trade := order.Match(b.best(opposite), cfg.timeSource()); trade != nil {
+			b.publish(&event.OrderRejected{
+				Symbol: cfg.symbol,
+				Order:  order,
+				Reason: event.RejectCrossed,
+			})
+			return false, nil
+		}
+	}
+
+	if acknowledge {
+		// Only the accepted orders take an exchange id, so the ids have no gaps.
+		order.ExchangeID = nextExchangeID(b)
+		b.publish(&event.OrderAcknowledge{
+			Symbol: cfg.symbol,
+			Order:  order,
+		})
+	}
+	if repriced != nil {
+		repriced.Order = order
+		b.publish(repriced)
+	}
+
+	if order.TimeInForce == entity.FillOrKill && availableAmount(b, order) < order.Amount {
+		b.publish(&event.OrderCancelled{
+			Symbol: cfg.symbol,
+			Order:  order,
+			Reason: event.CancelNoLiquidity,
+		})
+		return false, nil
+	}
+
+	selfTrade := selfTradePrevention(cfg, order)
+	tripped := false
+	for order.Amount > 0 {
+		if _, trade := order.Match(b.best(opposite), cfg.timeSource()); trade == nil {
+			break
+		}
+		// The breaker is checked before trading each level, so no trade happens outside the dynamic band.
+		if !withinDynamicBand(b, orderSymbol(cfg, order), b.best(opposite).Price) {
+			tripped = true
+			break
+		}
+		if !matchLevel(b, &order, selfTrade) {
+			break
+		}
+	}
+
+	if order.Amount > 0 && tripped && cfg.bands.Breaker == BreakerHalt {
+		// The halted book would be left crossed by the rest of the order, a volatility auction collects it instead.
+		b.publish(&event.OrderCancelled{
+			Symbol: cfg.symbol,
+			Order:  order,
+			Reason: event.CancelPriceBand,
+		})
+	} else if order.Amount > 0 && expired(order, cfg.timeSource().Now()) {
+		b.publish(&event.OrderCancelled{
+			Symbol: cfg.symbol,
+			Order:  order,
+			Reason: event.CancelExpired,
+		})
+	} else if order.Amount > 0 && (order.Type == entity.Market ||
+		(order.TimeInForce != entity.GoodTillCancel && order.TimeInForce != entity.GoodTillTime)) {
+		// Only good till cancel and good till time limit orders sit in the book.
+		b.publish(&event.OrderCancelled{
+			Symbol: cfg.symbol,
+			Order:  order,
+			Reason: event.CancelNoLiquidity,
+		})
+	} else if order.Amount > 0 {
+		restOrder(b, order)
+	}
+
+	return tripped, nil
+}
+
+// restOrder adds the order to the book, keeping only the visible slice of icebergs.
+func restOrder(b book, order entity.Order) {
+	if order.DisplayAmount > 0 && order.Amount > order.DisplayAmount {
+		order.HiddenAmount = order.Amount - order.DisplayAmount
+		order.Amount = order.DisplayAmount
+	}
+	touchLevel(b, &order)
+	b.insert(order)
+	b.state().expiries.track(order)
+	b.publish(&event.OrderCreated{
+		Symbol: b.config().symbol,
+		Order:  order,
+	})
+}
+
+// matchLevel matches the order against the best opposite price level, sharing it among the resting orders as the
+// matching policy says.
+// It returns false when nothing changed, so a policy allocating nothing cannot keep the loop going.
+func matchLevel(b book, order *entity.Order, selfTrade entity.SelfTradePrevention) bool {
+	opposite := order.Side.Opposite()
+	price := b.best(opposite).Price
+	var keys []entity.OrderKey
+	var sizes []entity.Decimal
+	var total entity.Decimal
+	b.walk(opposite, func(other *entity.Order) bool {
+		if other.Price != price {
+			return false
+		}
+		keys = append(keys, other.Key())
+		sizes = append(sizes, other.Amount)
+		total += other.Amount
+		return true
+	})
+
+	matched := false
+	allocations := b.config().matchingPolicy().Allocate(minAmount(order.Amount, total), sizes)
+	for i, allocation := range allocations {
+		if allocation == 0 {
+			continue
+		}
+		resting := b.find(keys[i])
+		if resting.User == order.User && selfTrade != entity.SelfTradeAllow {
+			// The level is allocated again without the orders changed by the prevention.
+			preventSelfTrade(b, order, resting, selfTrade)
+			return true
+		}
+		fillResting(b, order, resting, minAmount(allocation, resting.Amount))
+		matched = true
+	}
+	return matched
+}
+
+// fillResting trades the amount of the incoming order against the resting one.
+func fillResting(b book, order *entity.Order, resting *entity.Order, amount entity.Decimal) {
+	incoming := *order
+	incoming.Amount = amount
+	_, trade := incoming.Match(resting, b.config().timeSource())
+	publishTrade(b, *trade)
+	order.Amount -= amount
+	fillOrder(b, resting, *trade)
+}
+
+func publishTrade(b book, trade entity.Trade) {
+	b.publish(&event.TradeGenerated{
+		Symbol: b.config().symbol,
+		Trade:  trade,
+		Scale:  b.config().scale(),
+	})
+	b.state().recordTrade(trade.Price)
+}
+
+// fillOrder takes the amount of the trade out of an order in the book, refilling icebergs from their hidden reserve.
+// The amount is never more than the visible amount of the order.
+func fillOrder(b book, resting *entity.Order, trade entity.Trade) {
+	symbol := b.config().symbol
+	amount := trade.Amount
+	touchLevel(b, resting)
+	if amount < resting.Amount {
+		resting.Amount -= amount
+		b.publish(&event.OrderFilled{
+			Symbol:   symbol,
+			Order:    *resting,
+			Full:     false,
+			Notional: trade.Notional,
+		})
+		return
+	}
+
+	filled, _ := b.remove(resting.Key())
+	if filled.HiddenAmount > 0 {
+		// The next slice of the iceberg loses its time priority.
+		filled.Amount = filled.DisplayAmount
+		if filled.HiddenAmount < filled.Amount {
+			filled.Amount = filled.HiddenAmount
+		}
+		filled.HiddenAmount -= filled.Amount
+		b.insert(filled)
+		b.publish(&event.OrderFilled{
+			Symbol:   symbol,
+			Order:    filled,
+			Full:     false,
+			Notional: trade.Notional,
+		})
+	} else {
+		b.publish(&event.OrderFilled{
+			Symbol:   symbol,
+			Order:    filled,
+			Full:     true,
+			Notional: trade.Notional,
+		})
+	}
+}
+
+// postOnlyPrice gives the price one tick of the instrument away from the opposite top of the book, so the order does
+// not match, false when the price is not valid for the order.
+func postOnlyPrice(b book, order entity.Order, top *entity.Order) (entity.Decimal, bool) {
+	if order.Type != entity.Limit {
+		return 0, false
+	}
+	cfg := b.config()
+	symbol := orderSymbol(cfg, order)
+	tick := cfg.instruments[symbol].TickSize
+	if tick == 0 {
+		tick = 1
+	}
+	var price entity.Decimal
+	if order.Side == entity.Buy {
+		if top.Price <= tick {
+			return 0, false
+		}
+		price = (top.Price - 1) / tick * tick
+	} else {
+		steps := top.Price/tick + 1
+		if steps > entity.MaxDecimal/tick {
+			return 0, false
+		}
+		price = steps * tick
+	}
+	// The new price is validated like the one of the order.
+	amount := order.Amount + order.HiddenAmount
+	if _, invalid := instrumentViolation(cfg, symbol, []entity.Decimal{price}, amount); invalid {
+		return 0, false
+	}
+	return price, withinStaticBand(b, symbol, price)
+}
+
+// availableAmount sums the amount on the opposite side the order can match against, stopping once it is enough to
+// fill the order, or at the first price outside the dynamic band.
+// The orders of the same user count as much as the self-trade prevention lets the order go through them.
+func availableAmount(b book, order entity.Order) entity.Decimal {
+	var total entity.Decimal
+	cfg := b.config()
+	selfTrade := selfTradePrevention(cfg, order)
+	symbol := orderSymbol(cfg, order)
+	b.walk(order.Side.Opposite(), func(other *entity.Order) bool {
+		if _, trade := order.Match(other, cfg.timeSource()); trade == nil {
+			return false
+		}
+		if !withinDynamicBand(b, symbol, other.Price) {
+			return false
+		}
+		if other.User == order.User {
+			switch selfTrade {
+			case entity.CancelNewest, entity.CancelBoth:
+				return false
+			case entity.CancelOldest:
+				return true
+			}
+		}
+		total += other.Amount + other.HiddenAmount
+		return total < order.Amount
+	})
+	return total
+}
+
+// rejectUnknownOrder publishes the rejection of a request for an order the user does not have, in the book or waiting
+// as a stop.
+// Orders are found by their key, so users can never change the orders of other users.
+func rejectUnknownOrder(b book, key entity.OrderKey) bool {
+	if orderExists(b, key) {
+		return false
+	}
+	b.publish(&event.RequestRejected{
+		Symbol:  b.config().symbol,
+		User:    key.User,
+		OrderID: key.ID,
+		Reason:  event.RejectUnknownOrder,
+	})
+	return true
+}
+
+func cancelOrder(b book, key entity.OrderKey) error {
+	expireOrders(b)
+	if rejectUnknownOrder(b, key) {
+		return nil
+	}
+	if stop, isStop := b.state().stops.remove(key); isStop {
+		symbol := b.config().symbol
+		b.publish(&event.StopCancelled{
+			Symbol: symbol,
+			Order:  stop,
+		})
+		b.publish(&event.OrderAcknowledge{
+			Symbol: symbol,
+			Order:  stop,
+		})
+		return nil
+	}
+	before := topLevels(b)
+	defer publishBookChanges(b, before)
+
+	touchLevel(b, b.find(key))
+	order, _ := b.remove(key)
+	symbol := b.config().symbol
+	b.publish(&event.OrderCancelled{
+		Symbol: symbol,
+		Order:  order,
+	})
+	b.publish(&event.OrderAcknowledge{
+		Symbol: symbol,
+		Order:  order,
+	})
+	return nil
+}
+
+// replaceOrder changes the order in place when only its amount is reduced, otherwise the order is taken out of the
+// book and sent again with the new values, as if it had just arrived.
+func replaceOrder(b book, key entity.OrderKey, price, amount entity.Decimal) error {
+	expireOrders(b)
+	if rejectUnknownOrder(b, key) {
+		return nil
+	}
+	if !b.state().session.AcceptsOrders() {
+		b.publish(&event.RequestRejected{
+			Symbol:  b.config().symbol,
+			User:    key.User,
+			OrderID: key.ID,
+			Reason:  event.RejectSession,
+		})
+		return nil
+	}
+	if b.state().stops.contains(key) {
+		return fmt.Errorf("stop order %v of user %v cannot be replaced", key.ID, key.User)
+	}
+	current := b.find(key)
+	if amount == 0 {
+		return invalidOrderAmountError
+	}
+	if price == 0 {
+		return invalidOrderPriceError
+	}
+	cfg := b.config()
+	if reason, invalid := instrumentViolation(cfg, orderSymbol(cfg, *current), []entity.Decimal{price}, amount); invalid {
+		b.publish(&event.RequestRejected{
+			Symbol:  cfg.symbol,
+			User:    key.User,
+			OrderID: key.ID,
+			Reason:  reason,
+		})
+		return nil
+	}
+	if !withinStaticBand(b, orderSymbol(cfg, *current), price) {
+		b.publish(&event.RequestRejected{
+			Symbol:  cfg.symbol,
+			User:    key.User,
+			OrderID: key.ID,
+			Reason:  event.RejectPriceBand,
+		})
+		return nil
+	}
+
+	// The top of the book changes are published before triggering the stops, like for new orders.
+	before := topLevels(b)
+	if price == current.Price && amount <= current.Amount+current.HiddenAmount {
+		touchLevel(b, current)
+		// The hidden amount of an iceberg is reduced before the visible slice.
+		if amount > current.Amount {
+			current.HiddenAmount = amount - current.Amount
+		} else {
+			current.Amount = amount
+			current.HiddenAmount = 0
+		}
+		b.publish(&event.OrderUpdated{
+			Symbol: cfg.symbol,
+			Order:  *current,
+		})
+		b.publish(&event.OrderAcknowledge{
+			Symbol: cfg.symbol,
+			Order:  *current,
+		})
+		publishBookChanges(b, before)
+		return nil
+	}
+
+	replaced := *current
+	replaced.Price = price
+	replaced.Amount = amount
+	replaced.HiddenAmount = 0
+	replaced.Timestamp = cfg.timeSource().Now()
+	// Orders are not matched during a call auction, so they can cross the book.
+	_, trade := replaced.Match(b.best(replaced.Side.Opposite()), cfg.timeSource())
+	if trade != nil && !b.state().session.Collecting() {
+		// A rejected replace leaves the order as it was, so every rejection of matchOrder is checked before the order
+		// leaves the book.
+		var reason event.RejectReason
+		rejected := false
+		switch {
+		case replaced.PostOnly == entity.PostOnlyReject:
+			reason, rejected = event.RejectPostOnly, true
+		case replaced.PostOnly == entity.PostOnlyReprice:
+			_, ok := postOnlyPrice(b, replaced, b.best(replaced.Side.Opposite()))
+			reason, rejected = event.RejectPostOnly, !ok
+		case cfg.mode == RejectCrossing:
+			reason, rejected = event.RejectCrossed, true
+		}
+		if rejected {
+			b.publish(&event.OrderRejected{
+				Symbol: cfg.symbol,
+				Order:  replaced,
+				Reason: reason,
+			})
+			return nil
+		}
+	}
+
+	touchLevel(b, current)
+	order, _ := b.remove(key)
+	b.publish(&event.OrderCancelled{
+		Symbol: cfg.symbol,
+		Order:  order,
+		Reason: event.CancelReplaced,
+	})
+	b.publish(&event.OrderAcknowledge{
+		Symbol: cfg.symbol,
+		Order:  replaced,
+	})
+	tripped, err := matchOrder(b, replaced, false)
+	publishBookChanges(b, before)
+	if tripped {
+		tripBreaker(b)
+	}
+	if err != nil {
+		return err
+	}
+	triggerStops(b)
+	return nil
+}
+
+// flushOrders removes all the orders and the stops without publishing events, like the engine always did between
+// scenarios, so the consumers following the events have to be reset as well.
+func flushOrders(b book) {
+	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
+		for b.best(side) != nil {
+			b.removeBest(side)
+		}
+	}
+	state := b.state()
+	state.stops.popAll()
+	state.traded = false
+	state.expiries.reset()
+	state.indicative = uncrossing{}
+	state.touched = nil
+}
@@ -0,0 +1,203 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+// Mode defines what the engine does with orders that cross the book.
+type Mode uint8
+
+const (
+	// MatchCrossing matches the orders crossing the book, it is the default mode.
+	MatchCrossing Mode = iota
+	// RejectCrossing rejects the limit orders that would cross the book.
+	RejectCrossing Mode = iota
+)
+
+func (m Mode) String() string {
+	switch m {
+	case MatchCrossing:
+		return "match"
+	case RejectCrossing:
+		return "reject"
+	default:
+		return fmt.Sprintf("invalid mode (%v)", uint8(m))
+	}
+}
+
+// ParseMode gives the Mode for its name.
+func ParseMode(name string) (Mode, error) {
+	for _, mode := range []Mode{MatchCrossing, RejectCrossing} {
+		if mode.String() == name {
+			return mode, nil
+		}
+	}
+	return MatchCrossing, fmt.Errorf("invalid mode: %v", name)
+}
+
+// Storage defines how the engine keeps the resting orders.
+type Storage uint8
+
+const (
+	// ListStorage keeps each side of the book in a sorted array, it is the default storage.
+	ListStorage Storage = iota
+	// TreeStorage keeps each side of the book in a tree of price levels.
+	TreeStorage Storage = iota
+)
+
+func (s Storage) String() string {
+	switch s {
+	case ListStorage:
+		return "list"
+	case TreeStorage:
+		return "tree"
+	default:
+		return fmt.Sprintf("invalid storage (%v)", uint8(s))
+	}
+}
+
+// ParseStorage gives the Storage for its name.
+func ParseStorage(name string) (Storage, error) {
+	for _, storage := range []Storage{ListStorage, TreeStorage} {
+		if storage.String() == name {
+			return storage, nil
+		}
+	}
+	return ListStorage, fmt.Errorf("invalid storage: %v", name)
+}
+
+// options configures the behaviour of the engines.
+type options struct {
+	mode    Mode
+	storage Storage
+	// symbol tags the events generated by the engine.
+	symbol string
+	// clock timestamps the trades and expires the good till time orders.
+	clock entity.Clock
+	// selfTrade is used for the orders without their own self-trade prevention.
+	selfTrade entity.SelfTradePrevention
+	// exchangeIDs is the last exchange id given, shared by the books of a SymbolEngine.
+	exchangeIDs *uint64
+	// policy shares the incoming orders among the resting orders of each price level.
+	policy MatchingPolicy
+	// bands rejects the orders priced too far from the reference price and stops the trading on large price moves.
+	bands PriceBands
+	// instruments validates the orders of each symbol, no order is validated when it is nil.
+	instruments entity.Instruments
+	// depthUpdates publishes a DepthUpdate for every price level changed.
+	depthUpdates bool
+	// listener sees every event of the book before it is published, the SymbolEngine keeps its index of orders with it.
+	listener func(evt event.Event)
+}
+
+// timeSource gives the clock of the engine, the system one when none was configured.
+func (o *options) timeSource() entity.Clock {
+	if o.clock == nil {
+		return entity.SystemClock
+	}
+	return o.clock
+}
+
+// matchingPolicy gives the policy of the engine, FIFO when none was configured.
+func (o *options) matchingPolicy() MatchingPolicy {
+	if o.policy == nil {
+		return FIFO{}
+	}
+	return o.policy
+}
+
+// scale gives the precision of the instrument of the book, the prices and the quantities are integers without one.
+func (o *options) scale() entity.Scale {
+	return o.instruments[o.symbol].Scale
+}
+
+// Option changes the default behaviour of the engines.
+type Option func(*options)
+
+// WithMode defines what to do with orders crossing the book.
+func WithMode(mode Mode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// WithStorage defines how the SymbolEngine keeps the orders of each symbol.
+func WithStorage(storage Storage) Option {
+	return func(o *options) {
+		o.storage = storage
+	}
+}
+
+// WithSelfTradePrevention defines what to do when orders of the same user would match, orders can override it.
+func WithSelfTradePrevention(selfTrade entity.SelfTradePrevention) Option {
+	return func(o *options) {
+		o.selfTrade = selfTrade
+	}
+}
+
+// WithClock defines the time used by the engines, so it can be driven by hand.
+func WithClock(clock entity.Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithMatchingPolicy defines how the orders of a price level are filled, like FIFO or ProRata.
+func WithMatchingPolicy(policy MatchingPolicy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// WithPriceBands defines the static and dynamic price bands of the books, and what to do when the dynamic one is
+// reached.
+func WithPriceBands(bands PriceBands) Option {
+	return func(o *options) {
+		o.bands = bands
+	}
+}
+
+// WithInstruments defines the reference data used to validate the orders, orders of symbols missing from it are
+// rejected.
+func WithInstruments(instruments entity.Instruments) Option {
+	return func(o *options) {
+		o.instruments = instruments
+	}
+}
+
+// WithDepthUpdates publishes a DepthUpdate for every price level changed, so the consumers can follow the depth of the
+// book, the SymbolEngine always publishes them.
+func WithDepthUpdates() Option {
+	return func(o *options) {
+		o.depthUpdates = true
+	}
+}
+
+func withExchangeIDs(sequence *uint64) Option {
+	return func(o *options) {
+		o.exchangeIDs = sequence
+	}
+}
+
+func withListener(listener func(evt event.Event)) Option {
+	return func(o *options) {
+		o.listener = listener
+	}
+}
+
+func withSymbol(symbol string) Option {
+	return func(o *options) {
+		o.symbol = symbol
+	}
+}
+
+func newOptions(opts ...Option) options {
+	resp := options{}
+	for _, opt := range opts {
+		opt(&resp)
+	}
+	return resp
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"math/bits"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+)
+
+// MatchingPolicy decides how an incoming order is shared by the resting orders of a price level.
+// The levels are still matched from the best price to the worst one.
+type MatchingPolicy interface {
+	// Allocate gives how much of amount goes to each order of the level, the orders are given by their visible amount
+	// in time priority.
+	// The amount is never more than the level has, and the allocations must add up to it.
+	Allocate(amount entity.Decimal, level []entity.Decimal) []entity.Decimal
+}
+
+// FIFO fills the orders of the level in time priority, it is the default policy.
+type FIFO struct{}
+
+func (FIFO) Allocate(amount entity.Decimal, level []entity.Decimal) []entity.Decimal {
+	resp := make([]entity.Decimal, len(level))
+	for i, size := range level {
+		if amount == 0 {
+			break
+		}
+		resp[i] = minAmount(size, amount)
+		amount -= resp[i]
+	}
+	return resp
+}
+
+// ProRata shares the amount by the size of the orders of the level.
+// The rounding is always down, and the lots left by it are given in time priority, so the allocation is
+// deterministic.
+type ProRata struct {
+	// MinAllocation is the smallest share an order gets, smaller shares are dropped and only get the lots left by the
+	// rounding.
+	MinAllocation entity.Decimal
+	// TopOrderPriority fills the first order of the level before sharing what is left among the others.
+	TopOrderPriority bool
+}
+
+func (p ProRata) Allocate(amount entity.Decimal, level []entity.Decimal) []entity.Decimal {
+	resp := make([]entity.Decimal, len(level))
+	var total entity.Decimal
+	for _, size := range level {
+		total += size
+	}
+	if amount >= total {
+		copy(resp, level)
+		return resp
+	}
+
+	start := 0
+	if p.TopOrderPriority && len(level) > 0 {
+		resp[0] = minAmount(level[0], amount)
+		amount -= resp[0]
+		total -= level[0]
+		start = 1
+	}
+	if amount == 0 {
+		return resp
+	}
+
+	remaining := amount
+	for i := start; i < len(level); i++ {
+		// The amount is smaller than the total, so the share always fits.
+		hi, lo := bits.Mul64(uint64(amount), uint64(level[i]))
+		quotient, _ := bits.Div64(hi, lo, uint64(total))
+		share := entity.Decimal(quotient)
+		if share < p.MinAllocation {
+			share = 0
+		}
+		resp[i] = share
+		remaining -= share
+	}
+	for i := start; i < len(level) && remaining > 0; i++ {
+		extra := minAmount(level[i]-resp[i], remaining)
+		resp[i] += extra
+		remaining -= extra
+	}
+	return resp
+}
+
+func minAmount(a, b entity.Decimal) entity.Decimal {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+func TestMatchingPolicy_Allocate(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		policy MatchingPolicy
+		amount entity.Decimal
+		level  []entity.Decimal
+		want   []entity.Decimal
+	}{
+		{
+			name:   "fifo fills in time priority",
+			policy: FIFO{},
+			amount: 12,
+			level:  []entity.Decimal{5, 10, 3},
+			want:   []entity.Decimal{5, 7, 0},
+		},
+		{
+			name:   "fifo whole level",
+			policy: FIFO{},
+			amount: 18,
+			level:  []entity.Decimal{5, 10, 3},
+			want:   []entity.Decimal{5, 10, 3},
+		},
+		{
+			name:   "pro-rata by size",
+			policy: ProRata{},
+			amount: 10,
+			level:  []entity.Decimal{10, 30, 60},
+			want:   []entity.Decimal{1, 3, 6},
+		},
+		{
+			name:   "pro-rata gives the rounding lots in time priority",
+			policy: ProRata{},
+			amount: 2,
+			level:  []entity.Decimal{1, 1, 1},
+			want:   []entity.Decimal{1, 1, 0},
+		},
+		{
+			name:   "pro-rata rounds down",
+			policy: ProRata{},
+			amount: 5,
+			level:  []entity.Decimal{10, 10, 10},
+			want:   []entity.Decimal{3, 1, 1},
+		},
+		{
+			name:   "pro-rata drops shares under the minimum allocation",
+			policy: ProRata{MinAllocation: 3},
+			amount: 10,
+			level:  []entity.Decimal{10, 30, 60},
+			want:   []entity.Decimal{1, 3, 6},
+		},
+		{
+			name:   "pro-rata minimum allocation moves lots to the first orders",
+			policy: ProRata{MinAllocation: 4},
+			amount: 10,
+			level:  []entity.Decimal{10, 30, 60},
+			want:   []entity.Decimal{4, 0, 6},
+		},
+		{
+			name:   "pro-rata top order priority",
+			policy: ProRata{TopOrderPriority: true},
+			amount: 20,
+			level:  []entity.Decimal{10, 30, 60},
+			want:   []entity.Decimal{10, 4, 6},
+		},
+		{
+			name:   "pro-rata top order takes everything",
+			policy: ProRata{TopOrderPriority: true},
+			amount: 4,
+			level:  []entity.Decimal{10, 30, 60},
+			want:   []entity.Decimal{4, 0, 0},
+		},
+		{
+			name:   "pro-rata whole level",
+			policy: ProRata{MinAllocation: 100},
+			amount: 200,
+			level:  []entity.Decimal{10, 30, 60},
+			want:   []entity.Decimal{10, 30, 60},
+		},
+		{
+			name:   "pro-rata large amounts",
+			policy: ProRata{},
+			amount: math.MaxUint64 / 2,
+			level:  []entity.Decimal{math.MaxUint64 / 2, math.MaxUint64 / 2},
+			want:   []entity.Decimal{1 << 62, 1<<62 - 1},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.policy.Allocate(tt.amount, tt.level); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Allocate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_proRataMatching(t *testing.T) {
+	t.Parallel()
+	resting := []entity.Order{
+		{Amount: 10, Price: 10, ID: 1, Side: entity.Sell, User: 1},
+		{Amount: 30, Price: 10, ID: 2, Side: entity.Sell, User: 2},
+		{Amount: 60, Price: 10, ID: 3, Side: entity.Sell, User: 3},
+		{Amount: 5, Price: 11, ID: 4, Side: entity.Sell, User: 4},
+	}
+	book := []string{
+		"A, 1, 1",
+		"B, S, 10, 10",
+		"A, 2, 2",
+		"B, S, 10, 40",
+		"A, 3, 3",
+		"B, S, 10, 100",
+		"A, 4, 4",
+	}
+	tests := []struct {
+		name       string
+		policy     MatchingPolicy
+		order      entity.Order
+		wantEvents []string
+	}{
+		{
+			name:   "fifo",
+			policy: FIFO{},
+			order:  entity.Order{Amount: 20, Price: 10, ID: 5, Side: entity.Buy, User: 5},
+			wantEvents: append(book,
+				"A, 5, 5",
+				"T, 5, 5, 1, 1, 10, 10",
+				"T, 5, 5, 2, 2, 10, 10",
+				"B, S, 10, 80",
+			),
+		},
+		{
+			name:   "pro-rata",
+			policy: ProRata{},
+			order:  entity.Order{Amount: 20, Price: 10, ID: 5, Side: entity.Buy, User: 5},
+			wantEvents: append(book,
+				"A, 5, 5",
+				"T, 5, 5, 1, 1, 10, 2",
+				"T, 5, 5, 2, 2, 10, 6",
+				"T, 5, 5, 3, 3, 10, 12",
+				"B, S, 10, 80",
+			),
+		},
+		{
+			name:   "pro-rata with top order priority",
+			policy: ProRata{TopOrderPriority: true, MinAllocation: 4},
+			order:  entity.Order{Amount: 20, Price: 10, ID: 5, Side: entity.Buy, User: 5},
+			wantEvents: append(book,
+				"A, 5, 5",
+				"T, 5, 5, 1, 1, 10, 10",
+				"T, 5, 5, 2, 2, 10, 4",
+				"T, 5, 5, 3, 3, 10, 6",
+				"B, S, 10, 80",
+			),
+		},
+		{
+			name:   "pro-rata moves to the next level",
+			policy: ProRata{},
+			order:  entity.Order{Amount: 102, Price: 11, ID: 5, Side: entity.Buy, User: 5},
+			wantEvents: append(book,
+				"A, 5, 5",
+				"T, 5, 5, 1, 1, 10, 10",
+				"T, 5, 5, 2, 2, 10, 30",
+				"T, 5, 5, 3, 3, 10, 60",
+				"T, 5, 5, 4, 4, 11, 2",
+				"B, S, 11, 3",
+			),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			for _, storage := range []Storage{ListStorage, TreeStorage} {
+				events := make(chan event.Event, 50)
+				var engine MatchingEngine = newListEngine(events, WithMatchingPolicy(tt.policy))
+				if storage == TreeStorage {
+					engine = newTreeEngine(events, WithMatchingPolicy(tt.policy))
+				}
+				for _, order := range append(resting, tt.order) {
+					if err := engine.AddOrder(ctx, order); err != nil {
+						t.Errorf("%v AddOrder(%v) error = %v", storage, order.ID, err)
+					}
+				}
+				engine.Close()
+				if gotEvents := describeEvents(events); !reflect.DeepEqual(gotEvents, tt.wantEvents) {
+					t.Errorf("%v events: %v, want: %v", storage, gotEvents, tt.wantEvents)
+				}
+			}
+		})
+	}
+}
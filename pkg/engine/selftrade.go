@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+// selfTradePrevention gives the self-trade prevention used for the incoming order.
+func selfTradePrevention(cfg *options, order entity.Order) entity.SelfTradePrevention {
+	selfTrade := order.SelfTrade
+	if selfTrade == entity.SelfTradeDefault {
+		selfTrade = cfg.selfTrade
+	}
+	if selfTrade == entity.SelfTradeDefault {
+		return entity.SelfTradeAllow
+	}
+	return selfTrade
+}
+
+// preventSelfTrade is applied instead of matching the order against the resting one, when both are from the same
+// user. It returns false when the incoming order cannot keep matching.
+func preventSelfTrade(b book, order *entity.Order, resting *entity.Order, selfTrade entity.SelfTradePrevention) bool {
+	symbol := b.config().symbol
+	cancelResting := func() {
+		cancelled := *resting
+		touchLevel(b, resting)
+		b.remove(cancelled.Key())
+		b.publish(&event.OrderCancelled{
+			Symbol: symbol,
+			Order:  cancelled,
+			Reason: event.CancelSelfTrade,
+		})
+	}
+	cancelIncoming := func() {
+		b.publish(&event.OrderCancelled{
+			Symbol: symbol,
+			Order:  *order,
+			Reason: event.CancelSelfTrade,
+		})
+		order.Amount = 0
+	}
+
+	switch selfTrade {
+	case entity.CancelNewest:
+		cancelIncoming()
+	case entity.CancelOldest:
+		cancelResting()
+	case entity.CancelBoth:
+		cancelResting()
+		cancelIncoming()
+	case entity.DecrementAndCancel:
+		restingAmount := resting.Amount + resting.HiddenAmount
+		decrement := order.Amount
+		if restingAmount < decrement {
+			decrement = restingAmount
+		}
+		if decrement == restingAmount {
+			cancelResting()
+		} else {
+			// The hidden amount of an iceberg is reduced before the visible slice, like in a replace.
+			touchLevel(b, resting)
+			if decrement <= resting.HiddenAmount {
+				resting.HiddenAmount -= decrement
+			} else {
+				resting.Amount -= decrement - resting.HiddenAmount
+				resting.HiddenAmount = 0
+			}
+			b.publish(&event.OrderUpdated{
+				Symbol: symbol,
+				Order:  *resting,
+			})
+		}
+		if decrement == order.Amount {
+			cancelIncoming()
+		} else {
+			order.Amount -= decrement
+		}
+	}
+	return order.Amount > 0
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+func Test_preventSelfTrade(t *testing.T) {
+	t.Parallel()
+	// The book has orders of users 1 and 2 at the same price, the incoming buy order is from user 1.
+	resting := []entity.Order{
+		{Amount: 5, Price: 10, ID: 1, Side: entity.Sell, User: 1},
+		{Amount: 5, Price: 10, ID: 2, Side: entity.Sell, User: 2},
+	}
+	book := []string{
+		"A, 1, 1",
+		"B, S, 10, 5",
+		"A, 2, 2",
+		"B, S, 10, 10",
+	}
+	tests := []struct {
+		name       string
+		opts       []Option
+		order      entity.Order
+		wantEvents []string
+	}{
+		{
+			name:  "allowed by default",
+			order: entity.Order{Amount: 8, Price: 10, ID: 3, Side: entity.Buy, User: 1},
+			wantEvents: append(book,
+				"A, 1, 3",
+				"T, 1, 3, 1, 1, 10, 5",
+				"T, 1, 3, 2, 2, 10, 3",
+				"B, S, 10, 2",
+			),
+		},
+		{
+			name:  "cancel newest",
+			opts:  []Option{WithSelfTradePrevention(entity.CancelNewest)},
+			order: entity.Order{Amount: 8, Price: 10, ID: 3, Side: entity.Buy, User: 1},
+			wantEvents: append(book,
+				"A, 1, 3",
+				"cancelled 3, self-trade",
+			),
+		},
+		{
+			name:  "cancel oldest",
+			opts:  []Option{WithSelfTradePrevention(entity.CancelOldest)},
+			order: entity.Order{Amount: 8, Price: 10, ID: 3, Side: entity.Buy, User: 1},
+			wantEvents: append(book,
+				"A, 1, 3",
+				"cancelled 1, self-trade",
+				"T, 1, 3, 2, 2, 10, 5",
+				"B, B, 10, 3",
+				"B, S, -, -",
+			),
+		},
+		{
+			name:  "cancel both",
+			opts:  []Option{WithSelfTradePrevention(entity.CancelBoth)},
+			order: entity.Order{Amount: 8, Price: 10, ID: 3, Side: entity.Buy, User: 1},
+			wantEvents: append(book,
+				"A, 1, 3",
+				"cancelled 1, self-trade",
+				"cancelled 3, self-trade",
+				"B, S, 10, 5",
+			),
+		},
+		{
+			name:  "decrement and cancel the resting order",
+			opts:  []Option{WithSelfTradePrevention(entity.DecrementAndCancel)},
+			order: entity.Order{Amount: 8, Price: 10, ID: 3, Side: entity.Buy, User: 1},
+			wantEvents: append(book,
+				"A, 1, 3",
+				"cancelled 1, self-trade",
+				"T, 1, 3, 2, 2, 10, 3",
+				"B, S, 10, 2",
+			),
+		},
+		{
+			name:  "decrement and cancel the incoming order",
+			opts:  []Option{WithSelfTradePrevention(entity.DecrementAndCancel)},
+			order: entity.Order{Amount: 3, Price: 10, ID: 3, Side: entity.Buy, User: 1},
+			wantEvents: append(book,
+				"A, 1, 3",
+				"updated 1, 2",
+				"cancelled 3, self-trade",
+				"B, S, 10, 7",
+			),
+		},
+		{
+			name: "order overrides the engine",
+			opts: []Option{WithSelfTradePrevention(entity.CancelNewest)},
+			order: entity.Order{
+				Amount: 8, Price: 10, ID: 3, Side: entity.Buy, User: 1, SelfTrade: entity.CancelOldest,
+			},
+			wantEvents: append(book,
+				"A, 1, 3",
+				"cancelled 1, self-trade",
+				"T, 1, 3, 2, 2, 10, 5",
+				"B, B, 10, 3",
+				"B, S, -, -",
+			),
+		},
+		{
+			name: "fill or kill does not count the liquidity it cannot reach",
+			opts: []Option{WithSelfTradePrevention(entity.CancelNewest)},
+			order: entity.Order{
+				Amount: 5, Price: 10, ID: 3, Side: entity.Buy, User: 1, TimeInForce: entity.FillOrKill,
+			},
+			wantEvents: append(book,
+				"A, 1, 3",
+				"cancelled 3, no liquidity",
+			),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			for _, storage := range []Storage{ListStorage, TreeStorage} {
+				events := make(chan event.Event, 50)
+				var engine MatchingEngine = newListEngine(events, tt.opts...)
+				if storage == TreeStorage {
+					engine = newTreeEngine(events, tt.opts...)
+				}
+				for _, order := range append(resting, tt.order) {
+					if err := engine.AddOrder(ctx, order); err != nil {
+						t.Errorf("%v AddOrder(%v) error = %v", storage, order.ID, err)
+					}
+				}
+				engine.Close()
+				if gotEvents := describeEvents(events); !reflect.DeepEqual(gotEvents, tt.wantEvents) {
+					t.Errorf("%v events: %v, want: %v", storage, gotEvents, tt.wantEvents)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+	"github.com/rodoufu/simple-orderbook/pkg/io"
+)
+
+func Test_changeSession(t *testing.T) {
+	t.Parallel()
+	book := []string{
+		"A, 1, 1",
+		"B, S, 100, 10",
+	}
+	cancelled := []string{
+		"cancelled 1, requested",
+		"A, 1, 1",
+		"B, S, -, -",
+	}
+	tests := []struct {
+		name       string
+		session    entity.SessionState
+		wantEvents []string
+	}{
+		{
+			name:    "continuous",
+			session: entity.Continuous,
+			wantEvents: append(append(book,
+				"A, 2, 2",
+				"T, 2, 2, 1, 1, 100, 5",
+				"B, S, 100, 5",
+				"updated 1, 5",
+				"A, 1, 1",
+			), cancelled...),
+		},
+		{
+			name:    "pre-open collects the orders",
+			session: entity.PreOpen,
+			wantEvents: append(append(book,
+				"S, PRE_OPEN",
+				"A, 2, 2",
+				"B, B, 100, 5",
+				"updated 1, 5",
+				"A, 1, 1",
+				"B, S, 100, 5",
+			), cancelled...),
+		},
+		{
+			name:    "halted only accepts cancels",
+			session: entity.Halted,
+			wantEvents: append(append(book,
+				"S, HALTED",
+				"R, 2, 2",
+				"R, 1, 1",
+			), cancelled...),
+		},
+		{
+			name:    "closed only accepts cancels",
+			session: entity.Closed,
+			wantEvents: append(append(book,
+				"S, CLOSED",
+				"R, 2, 2",
+				"R, 1, 1",
+			), cancelled...),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			for _, storage := range []Storage{ListStorage, TreeStorage} {
+				events := make(chan event.Event, 50)
+				var engine MatchingEngine = newListEngine(events)
+				if storage == TreeStorage {
+					engine = newTreeEngine(events)
+				}
+				transactions := []io.Transaction{
+					io.NewOrderTransaction{
+						Order: entity.Order{Amount: 10, Price: 100, ID: 1, Side: entity.Sell, User: 1},
+					},
+					io.SessionTransaction{
+						Session: tt.session,
+					},
+					io.NewOrderTransaction{
+						Order: entity.Order{Amount: 5, Price: 100, ID: 2, Side: entity.Buy, User: 2},
+					},
+					io.ReplaceOrderTransaction{
+						User:    1,
+						OrderID: 1,
+						Price:   100,
+						Amount:  5,
+					},
+					io.CancelOrderTransaction{
+						User:    1,
+						OrderID: 1,
+					},
+				}
+				for i, transaction := range transactions {
+					if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+						t.Errorf("%v ProcessTransaction(%d) error = %v", storage, i, err)
+					}
+				}
+				engine.Close()
+				if gotEvents := describeEvents(events); !reflect.DeepEqual(gotEvents, tt.wantEvents) {
+					t.Errorf("%v events: %v, want: %v", storage, gotEvents, tt.wantEvents)
+				}
+			}
+		})
+	}
+}
+
+func Test_symbolEngine_sessions(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	engine, events := NewSymbolEngine()
+	gotEventsCh := make(chan []string)
+	go func() {
+		gotEventsCh <- toListEventsOutput(ctx, events)
+	}()
+	transactions := []io.Transaction{
+		io.NewOrderTransaction{
+			Symbol: "IBM",
+			Order:  entity.Order{Amount: 10, Price: 100, ID: 1, Side: entity.Sell, User: 1},
+		},
+		io.SessionTransaction{
+			Symbol:  "IBM",
+			Session: entity.Halted,
+		},
+		io.NewOrderTransaction{
+			Symbol: "IBM",
+			Order:  entity.Order{Amount: 10, Price: 100, ID: 2, Side: entity.Buy, User: 2},
+		},
+		io.NewOrderTransaction{
+			Symbol: "AAPL",
+			Order:  entity.Order{Amount: 10, Price: 100, ID: 3, Side: entity.Buy, User: 3},
+		},
+		io.SessionTransaction{
+			Session: entity.Auction,
+		},
+		// The book created during the auction joins it.
+		io.NewOrderTransaction{
+			Symbol: "MSFT",
+			Order:  entity.Order{Amount: 5, Price: 100, ID: 4, Side: entity.Sell, User: 4},
+		},
+		io.SessionTransaction{
+			Session: entity.Continuous,
+		},
+		io.SessionTransaction{
+			Symbol:  "IBM",
+			Session: entity.Closed,
+		},
+	}
+	for i, transaction := range transactions {
+		if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+			t.Errorf("ProcessTransaction(%d) error = %v", i, err)
+		}
+	}
+	engine.Close()
+
+	wantEvents := []string{
+		"A, 1, 1",
+		"B, S, 100, 10",
+		"S, IBM, HALTED",
+		"R, 2, 2",
+		"A, 3, 3",
+		"B, B, 100, 10",
+		"S, AAPL, AUCTION",
+		"I, -, -",
+		"S, IBM, AUCTION",
+		"I, -, -",
+		"S, MSFT, AUCTION",
+		"I, -, -",
+		"A, 4, 4",
+		"B, S, 100, 5",
+		"S, AAPL, CONTINUOUS",
+		"S, IBM, CONTINUOUS",
+		"S, MSFT, CONTINUOUS",
+		"S, IBM, CLOSED",
+	}
+	if gotEvents := <-gotEventsCh; !reflect.DeepEqual(gotEvents, wantEvents) {
+		t.Errorf("events: %v, want: %v", gotEvents, wantEvents)
+	}
+	wantSessions := map[string]entity.SessionState{
+		"IBM":  entity.Closed,
+		"AAPL": entity.Continuous,
+		"MSFT": entity.Continuous,
+	}
+	for symbol, wantSession := range wantSessions {
+		if got := engine.OrderBook(symbol).Session(ctx); got != wantSession {
+			t.Errorf("OrderBook(%v).Session() = %v, want %v", symbol, got, wantSession)
+		}
+	}
+}
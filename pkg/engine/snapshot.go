@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+)
+
+var (
+	notSnapshotterError = fmt.Errorf("engine cannot be saved to a snapshot")
+)
+
+// snapshotter is implemented by the engines that can save their state and restore it later.
+type snapshotter interface {
+	snapshot() engineSnapshot
+	restore(snapshot engineSnapshot) error
+}
+
+// engineSnapshot is the state of an engine saved by Snapshot.
+type engineSnapshot struct {
+	Books []bookSnapshot `json:"books"`
+	// Symbols is the index of a SymbolEngine, giving the symbol of the orders it received.
+	Symbols []symbolSnapshot `json:"symbols,omitempty"`
+	// Session is the state given to the books created later by a SymbolEngine.
+	Session entity.SessionState `json:"session"`
+	// ExchangeIDs is the last exchange id given by a SymbolEngine.
+	ExchangeIDs uint64 `json:"exchangeIds"`
+	// EventSequence is the sequence number of the last event of a SymbolEngine that belongs to no book.
+	EventSequence uint64 `json:"eventSequence"`
+}
+
+type symbolSnapshot struct {
+	Key    entity.OrderKey `json:"key"`
+	Symbol string          `json:"symbol"`
+}
+
+// bookSnapshot is the state of a single book, the resting orders are kept in priority order.
+type bookSnapshot struct {
+	Symbol string                         `json:"symbol"`
+	Orders map[entity.Side][]entity.Order `json:"orders"`
+	// Index is the order-ID index of the book, giving the side of every resting order.
+	Index []indexSnapshot `json:"index"`
+	// Stops are kept like the stopBook does, the next order to be triggered is the last one.
+	Stops          map[entity.Side][]entity.Order `json:"stops"`
+	Expiries       []expirySnapshot               `json:"expiries"`
+	Deadlines      []expirySnapshot               `json:"deadlines"`
+	ExpirySequence uint64                         `json:"expirySequence"`
+	Traded         bool                           `json:"traded"`
+	TradedLow      entity.Decimal                 `json:"tradedLow"`
+	TradedHigh     entity.Decimal                 `json:"tradedHigh"`
+	LastPrice      entity.Decimal                 `json:"lastPrice"`
+	Session        entity.SessionState            `json:"session"`
+	Indicative     indicativeSnapshot             `json:"indicative"`
+	LastExchangeID entity.ExchangeOrderID         `json:"lastExchangeId"`
+	EventSequence  uint64                         `json:"eventSequence"`
+}
+
+type indexSnapshot struct {
+	Key  entity.OrderKey `json:"key"`
+	Side entity.Side     `json:"side"`
+}
+
+type expirySnapshot struct {
+	At       time.Time       `json:"at"`
+	Key      entity.OrderKey `json:"key"`
+	Sequence uint64          `json:"sequence,omitempty"`
+}
+
+type indicativeSnapshot struct {
+	Price      entity.Decimal `json:"price"`
+	Volume     entity.Decimal `json:"volume"`
+	BuyVolume  entity.Decimal `json:"buyVolume"`
+	SellVolume entity.Decimal `json:"sellVolume"`
+}
+
+// snapshotBook saves the orders and the state of the book.
+func snapshotBook(b book) bookSnapshot {
+	state := b.state()
+	resp := bookSnapshot{
+		Symbol:         b.config().symbol,
+		Orders:         map[entity.Side][]entity.Order{},
+		Stops:          map[entity.Side][]entity.Order{},
+		ExpirySequence: state.expiries.sequence,
+		Traded:         state.traded,
+		TradedLow:      state.tradedLow,
+		TradedHigh:     state.tradedHigh,
+		LastPrice:      state.lastPrice,
+		Session:        state.session,
+		Indicative: indicativeSnapshot{
+			Price:      state.indicative.price,
+			Volume:     state.indicative.volume,
+			BuyVolume:  state.indicative.buyVolume,
+			SellVolume: state.indicative.sellVolume,
+		},
+		LastExchangeID: state.lastExchangeID,
+		EventSequence:  state.eventSequence,
+	}
+	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
+		resp.Orders[side] = []entity.Order{}
+		b.walk(side, func(order *entity.Order) bool {
+			resp.Orders[side] = append(resp.Orders[side], *order)
+			indexSide, _ := b.sideOf(order.Key())
+			resp.Index = append(resp.Index, indexSnapshot{
+				Key:  order.Key(),
+				Side: indexSide,
+			})
+			return true
+		})
+		resp.Stops[side] = append([]entity.Order{}, state.stops.orders[side]...)
+	}
+	// The queue is saved as it is, so the orders expiring at the same time keep their order.
+	for _, it := range state.expiries.queue {
+		resp.Expiries = append(resp.Expiries, expirySnapshot{
+			At:       it.at,
+			Key:      it.key,
+			Sequence: it.sequence,
+		})
+	}
+	for key, at := range state.expiries.deadlines {
+		resp.Deadlines = append(resp.Deadlines, expirySnapshot{
+			At:  at,
+			Key: key,
+		})
+	}
+	// The map is sorted, so the same state always gives the same snapshot.
+	sort.Slice(resp.Deadlines, func(i, j int) bool {
+		return lessKey(resp.Deadlines[i].Key, resp.Deadlines[j].Key)
+	})
+	return resp
+}
+
+func lessKey(key, other entity.OrderKey) bool {
+	if key.User == other.User {
+		return key.ID < other.ID
+	}
+	return key.User < other.User
+}
+
+// restoreBook brings back the orders and the state saved by snapshotBook, the book has to be empty.
+func restoreBook(b book, snapshot bookSnapshot) error {
+	if symbol := b.config().symbol; snapshot.Symbol != symbol {
+		return fmt.Errorf("snapshot of symbol %q cannot be restored to %q", snapshot.Symbol, symbol)
+	}
+	state := b.state()
+	*state = engineState{
+		traded:     snapshot.Traded,
+		tradedLow:  snapshot.TradedLow,
+		tradedHigh: snapshot.TradedHigh,
+		lastPrice:  snapshot.LastPrice,
+		session:    snapshot.Session,
+		indicative: uncrossing{
+			price:      snapshot.Indicative.Price,
+			volume:     snapshot.Indicative.Volume,
+			buyVolume:  snapshot.Indicative.BuyVolume,
+			sellVolume: snapshot.Indicative.SellVolume,
+		},
+		lastExchangeID: snapshot.LastExchangeID,
+		eventSequence:  snapshot.EventSequence,
+	}
+
+	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
+		// Inserting in priority order keeps the time priority of the orders with the same price.
+		for _, order := range snapshot.Orders[side] {
+			if order.Side != side {
+				return fmt.Errorf("order %v of user %v saved on the wrong side", order.ID, order.User)
+			}
+			b.insert(order)
+		}
+		for _, stop := range snapshot.Stops[side] {
+			if state.stops.orders == nil {
+				state.stops.orders = map[entity.Side][]entity.Order{}
+				state.stops.orderKeys = map[entity.OrderKey]entity.Side{}
+			}
+			state.stops.orders[side] = append(state.stops.orders[side], stop)
+			state.stops.orderKeys[stop.Key()] = side
+		}
+	}
+	orders := len(snapshot.Orders[entity.Buy]) + len(snapshot.Orders[entity.Sell])
+	if len(snapshot.Index) != orders {
+		return fmt.Errorf("snapshot index has %v orders, want %v", len(snapshot.Index), orders)
+	}
+	for _, it := range snapshot.Index {
+		if side, ok := b.sideOf(it.Key); !ok || side != it.Side {
+			return fmt.Errorf("order %v of user %v does not match the snapshot index", it.Key.ID, it.Key.User)
+		}
+	}
+
+	state.expiries.sequence = snapshot.ExpirySequence
+	for _, it := range snapshot.Expiries {
+		state.expiries.queue = append(state.expiries.queue, expiry{
+			at:       it.At,
+			key:      it.Key,
+			sequence: it.Sequence,
+		})
+	}
+	for _, it := range snapshot.Deadlines {
+		if state.expiries.deadlines == nil {
+			state.expiries.deadlines = map[entity.OrderKey]time.Time{}
+		}
+		state.expiries.deadlines[it.Key] = it.At
+	}
+	return nil
+}
+
+// snapshotSingleBook saves an engine keeping a single book.
+func snapshotSingleBook(b book) engineSnapshot {
+	return engineSnapshot{
+		Books:   []bookSnapshot{snapshotBook(b)},
+		Session: b.state().session,
+	}
+}
+
+// singleBook gives the only book of the snapshot, for the engines keeping a single book.
+func singleBook(snapshot engineSnapshot) (bookSnapshot, error) {
+	if len(snapshot.Books) != 1 {
+		return bookSnapshot{}, fmt.Errorf("snapshot has %v books, want 1", len(snapshot.Books))
+	}
+	return snapshot.Books[0], nil
+}
@@ -0,0 +1,244 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+	"github.com/rodoufu/simple-orderbook/pkg/io"
+)
+
+// snapshotSteps gives the transactions applied before and after the snapshot, with the time they are applied at.
+func snapshotSteps(start time.Time) (before, after []io.Transaction, times []time.Duration) {
+	newOrder := func(order entity.Order) io.Transaction {
+		order.User = entity.UserID(order.ID)
+		order.Symbol = "BTC"
+		order.Timestamp = start
+		return io.NewOrderTransaction{Symbol: order.Symbol, Order: order}
+	}
+	before = []io.Transaction{
+		newOrder(entity.Order{
+			Amount:      10,
+			Price:       11,
+			ID:          1,
+			Side:        entity.Sell,
+			TimeInForce: entity.GoodTillTime,
+			ExpireAt:    start.Add(10 * time.Second),
+		}),
+		newOrder(entity.Order{Amount: 5, Price: 12, ID: 2, Side: entity.Sell}),
+		newOrder(entity.Order{Amount: 1, Price: 14, StopPrice: 12, ID: 3, Side: entity.Buy}),
+		newOrder(entity.Order{Amount: 4, Price: 11, ID: 4, Side: entity.Buy}),
+		newOrder(entity.Order{Amount: 3, Price: 9, ID: 5, Side: entity.Buy}),
+	}
+	after = []io.Transaction{
+		io.ExpireOrdersTransaction{},
+		newOrder(entity.Order{Amount: 2, Price: 12, ID: 6, Side: entity.Buy}),
+		io.CancelOrderTransaction{User: 2, OrderID: 2},
+		newOrder(entity.Order{Amount: 3, Price: 9, ID: 7, Side: entity.Sell}),
+	}
+	times = []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second,
+		11 * time.Second, 12 * time.Second, 13 * time.Second, 14 * time.Second}
+	return before, after, times
+}
+
+func Test_Snapshot_restore(t *testing.T) {
+	t.Parallel()
+	start := time.UnixMilli(1_000_000)
+	before, after, times := snapshotSteps(start)
+	tests := []struct {
+		name      string
+		newEngine Constructor
+	}{
+		{name: "list", newEngine: NewListEngine},
+		{name: "tree", newEngine: NewTreeEngine},
+		{
+			name: "symbol",
+			newEngine: func(opts ...Option) (MatchingEngine, <-chan event.Event) {
+				return NewSymbolEngine(opts...)
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			// run calls fn on a new engine and applies the transactions from the step, giving the events until it is
+			// closed.
+			run := func(
+				transactions []io.Transaction, step int, fn func(engine MatchingEngine, clock *entity.ManualClock),
+			) []string {
+				clock := entity.NewManualClock(start)
+				engine, events := tt.newEngine(WithClock(clock))
+				done := make(chan []string)
+				go func() {
+					done <- describeEvents(events)
+				}()
+				if fn != nil {
+					fn(engine, clock)
+				}
+				for i, transaction := range transactions {
+					clock.Set(start.Add(times[step+i]))
+					if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+						t.Errorf("ProcessTransaction(%v) error = %v", transaction, err)
+					}
+				}
+				if err := engine.Close(); err != nil {
+					t.Errorf("Close() error = %v", err)
+				}
+				return <-done
+			}
+
+			want := run(append(append([]io.Transaction{}, before...), after...), 0, nil)
+
+			var snapshot bytes.Buffer
+			saved := run(nil, 0, func(engine MatchingEngine, clock *entity.ManualClock) {
+				for i, transaction := range before {
+					clock.Set(start.Add(times[i]))
+					if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+						t.Errorf("ProcessTransaction(%v) error = %v", transaction, err)
+					}
+				}
+				if err := engine.Snapshot(ctx, &snapshot); err != nil {
+					t.Fatalf("Snapshot() error = %v", err)
+				}
+			})
+
+			var restored bytes.Buffer
+			got := run(after, len(before), func(engine MatchingEngine, clock *entity.ManualClock) {
+				if err := engine.Restore(ctx, bytes.NewReader(snapshot.Bytes())); err != nil {
+					t.Fatalf("Restore() error = %v", err)
+				}
+				if err := engine.Snapshot(ctx, &restored); err != nil {
+					t.Fatalf("Snapshot() error = %v", err)
+				}
+			})
+
+			if !bytes.Equal(restored.Bytes(), snapshot.Bytes()) {
+				t.Errorf("Snapshot() after Restore() = %s, want %s", restored.Bytes(), snapshot.Bytes())
+			}
+			if len(got) == 0 || !reflect.DeepEqual(got, want[len(saved):]) {
+				t.Errorf("events after Restore() = %v, want %v", got, want[len(saved):])
+			}
+		})
+	}
+}
+
+func Test_Restore_invalid(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	engine, events := NewListEngine()
+	go describeEvents(events)
+	defer engine.Close()
+	if err := engine.AddOrder(ctx, entity.Order{Amount: 1, Price: 10, ID: 1, User: 1, Side: entity.Buy}); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	var snapshot bytes.Buffer
+	if err := engine.Snapshot(ctx, &snapshot); err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(content []byte)
+	}{
+		// The version follows the magic of the header.
+		{name: "version", change: func(content []byte) { content[4]++ }},
+		{name: "payload", change: func(content []byte) { content[len(content)-2] ^= 0xff }},
+		{name: "magic", change: func(content []byte) { content[0] = 'X' }},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			content := append([]byte{}, snapshot.Bytes()...)
+			tt.change(content)
+			other, otherEvents := NewListEngine()
+			go describeEvents(otherEvents)
+			defer other.Close()
+			if err := other.Restore(ctx, bytes.NewReader(content)); err == nil {
+				t.Errorf("Restore() expected error")
+			}
+		})
+	}
+}
+
+func Test_journaledEngine_snapshot(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	start := time.UnixMilli(1_000_000)
+	before, after, times := snapshotSteps(start)
+	newEngine := func(opts ...Option) (MatchingEngine, <-chan event.Event) {
+		return NewSymbolEngine(opts...)
+	}
+	dir := t.TempDir()
+
+	// run applies the transactions from the step, calling fn after the third one, and gives the events until the engine
+	// is closed.
+	run := func(
+		open func(clock entity.Clock) (MatchingEngine, <-chan event.Event, error),
+		transactions []io.Transaction, step int, fn func(engine MatchingEngine),
+	) []string {
+		clock := entity.NewManualClock(start)
+		engine, events, err := open(clock)
+		if err != nil {
+			t.Fatalf("open error = %v", err)
+		}
+		done := make(chan []string)
+		go func() {
+			done <- describeEvents(events)
+		}()
+		for i, transaction := range transactions {
+			clock.Set(start.Add(times[step+i]))
+			if err = engine.ProcessTransaction(ctx, transaction); err != nil {
+				t.Errorf("ProcessTransaction(%v) error = %v", transaction, err)
+			}
+			if fn != nil && i == 2 {
+				fn(engine)
+			}
+		}
+		if err = engine.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+		return <-done
+	}
+
+	want := run(func(clock entity.Clock) (MatchingEngine, <-chan event.Event, error) {
+		return OpenJournaledEngine(ctx, filepath.Join(dir, "uninterrupted.journal"), newEngine, WithClock(clock))
+	}, append(append([]io.Transaction{}, before...), after...), 0, nil)
+
+	// The snapshot is taken in the middle of the transactions, the rest comes from the journal.
+	fileName := filepath.Join(dir, "crashed.journal")
+	var snapshot bytes.Buffer
+	saved := run(func(clock entity.Clock) (MatchingEngine, <-chan event.Event, error) {
+		return OpenJournaledEngine(ctx, fileName, newEngine, WithClock(clock))
+	}, before, 0, func(engine MatchingEngine) {
+		if err := engine.Snapshot(ctx, &snapshot); err != nil {
+			t.Fatalf("Snapshot() error = %v", err)
+		}
+	})
+	snapshotFile := filepath.Join(dir, "snapshot")
+	if err := os.WriteFile(snapshotFile, snapshot.Bytes(), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	got := run(func(clock entity.Clock) (MatchingEngine, <-chan event.Event, error) {
+		file, err := os.Open(snapshotFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+		return RestoreJournaledEngine(ctx, fileName, file, newEngine, WithClock(clock))
+	}, after, len(before), nil)
+
+	if len(got) == 0 || !reflect.DeepEqual(got, want[len(saved):]) {
+		t.Errorf("events after RestoreJournaledEngine() = %v, want %v", got, want[len(saved):])
+	}
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"sync/atomic"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+)
+
+// engineState keeps what the engines need besides the resting orders.
+// It is embedded in every engine, and its zero value is ready to use.
+type engineState struct {
+	stops    stopBook
+	expiries expiries
+	// traded tells there were trades since the stops were last checked, between tradedLow and tradedHigh.
+	traded     bool
+	tradedLow  entity.Decimal
+	tradedHigh entity.Decimal
+	// lastPrice is the price of the last trade, used as the reference price of the call auctions.
+	lastPrice entity.Decimal
+	// session tells what the book accepts and if the orders are matched.
+	session entity.SessionState
+	// indicative is the last uncrossing published during the call auction.
+	indicative uncrossing
+	// lastExchangeID is used when the engine does not share the exchange ids with other engines.
+	lastExchangeID entity.ExchangeOrderID
+	// eventSequence is the sequence number of the last event published by the book.
+	eventSequence uint64
+	// touched is the amount of the price levels changed since the depth updates were last published, before their
+	// change.
+	touched map[entity.Side]map[entity.Decimal]entity.Decimal
+}
+
+func (s *engineState) state() *engineState {
+	return s
+}
+
+func (s *engineState) recordTrade(price entity.Decimal) {
+	s.recordTraded(price, price)
+	s.lastPrice = price
+}
+
+// recordTraded widens the prices traded since the stops were last checked.
+func (s *engineState) recordTraded(low, high entity.Decimal) {
+	if !s.traded || low < s.tradedLow {
+		s.tradedLow = low
+	}
+	if !s.traded || high > s.tradedHigh {
+		s.tradedHigh = high
+	}
+	s.traded = true
+}
+
+// nextEventSequence numbers the next event published by the book.
+func (s *engineState) nextEventSequence() uint64 {
+	s.eventSequence++
+	return s.eventSequence
+}
+
+// nextExchangeID gives a new id for an order, unique across all the books sharing the sequence.
+func nextExchangeID(b book) entity.ExchangeOrderID {
+	if sequence := b.config().exchangeIDs; sequence != nil {
+		return entity.ExchangeOrderID(atomic.AddUint64(sequence, 1))
+	}
+	state := b.state()
+	state.lastExchangeID++
+	return state.lastExchangeID
+}
@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+)
+
+// stopBook keeps the stop orders outside the book until a trade crosses their stop price.
+// Each side is sorted so the next order to be triggered is the last one.
+type stopBook struct {
+	orders    map[entity.Side][]entity.Order
+	orderKeys map[entity.OrderKey]entity.Side
+}
+
+// triggersAfter checks if the stop order is further from the market than the other one, for orders of the same side.
+func triggersAfter(order, other *entity.Order) bool {
+	if order.Side == entity.Buy {
+		return order.StopPrice > other.StopPrice
+	}
+	return order.StopPrice < other.StopPrice
+}
+
+// triggered checks if a trade at the price crosses the stop price of the order.
+func triggered(order *entity.Order, price entity.Decimal) bool {
+	if order.Side == entity.Buy {
+		return price >= order.StopPrice
+	}
+	return price <= order.StopPrice
+}
+
+func (b *stopBook) contains(key entity.OrderKey) bool {
+	_, orderExists := b.orderKeys[key]
+	return orderExists
+}
+
+// find gives the stop order, nil when it is not there.
+func (b *stopBook) find(key entity.OrderKey) *entity.Order {
+	side, orderExists := b.orderKeys[key]
+	if !orderExists {
+		return nil
+	}
+	stops := b.orders[side]
+	for i := len(stops) - 1; i >= 0; i-- {
+		if stops[i].Key() == key {
+			return &stops[i]
+		}
+	}
+	return nil
+}
+
+// add keeps the arrival order for stops with the same stop price.
+func (b *stopBook) add(order entity.Order) {
+	if b.orders == nil {
+		b.orders = map[entity.Side][]entity.Order{}
+		b.orderKeys = map[entity.OrderKey]entity.Side{}
+	}
+	stops := append(b.orders[order.Side], order)
+	b.orderKeys[order.Key()] = order.Side
+	for i := len(stops) - 1; i >= 1 && !triggersAfter(&stops[i-1], &stops[i]); i-- {
+		stops[i], stops[i-1] = stops[i-1], stops[i]
+	}
+	b.orders[order.Side] = stops
+}
+
+func (b *stopBook) remove(key entity.OrderKey) (entity.Order, bool) {
+	side, orderExists := b.orderKeys[key]
+	if !orderExists {
+		return entity.Order{}, false
+	}
+	stops := b.orders[side]
+	for i := len(stops) - 1; i >= 0; i-- {
+		if stops[i].Key() == key {
+			order := stops[i]
+			delete(b.orderKeys, key)
+			b.orders[side] = append(stops[:i], stops[i+1:]...)
+			return order, true
+		}
+	}
+	return entity.Order{}, false
+}
+
+// popTriggered takes out the stops crossed by trades between the prices, in the order they are triggered.
+func (b *stopBook) popTriggered(low, high entity.Decimal) []entity.Order {
+	var resp []entity.Order
+	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
+		price := high
+		if side == entity.Sell {
+			price = low
+		}
+		stops := b.orders[side]
+		for len(stops) > 0 && triggered(&stops[len(stops)-1], price) {
+			resp = append(resp, stops[len(stops)-1])
+			delete(b.orderKeys, stops[len(stops)-1].Key())
+			stops = stops[:len(stops)-1]
+		}
+		if b.orders != nil {
+			b.orders[side] = stops
+		}
+	}
+	return resp
+}
+
+// popAll takes out all the stops.
+func (b *stopBook) popAll() []entity.Order {
+	var resp []entity.Order
+	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
+		for i := len(b.orders[side]) - 1; i >= 0; i-- {
+			resp = append(resp, b.orders[side][i])
+		}
+	}
+	b.orders = nil
+	b.orderKeys = nil
+	return resp
+}
+
+func addStop(b book, order entity.Order) error {
+	if order.Amount == 0 {
+		return invalidOrderAmountError
+	}
+	if orderExists(b, order.Key()) {
+		return orderExistsError(order.Key())
+	}
+	order.ExchangeID = nextExchangeID(b)
+	state := b.state()
+	state.stops.add(order)
+	state.expiries.track(order)
+	b.publish(&event.StopAccepted{
+		Symbol: b.config().symbol,
+		Order:  order,
+	})
+	return nil
+}
+
+// triggerStops sends to the book the stops crossed by the trades, including the ones crossed by the trades of other
+// triggered stops.
+// While the book does not accept orders the stops wait, they are triggered when the book resumes the trading.
+func triggerStops(b book) {
+	state := b.state()
+	symbol := b.config().symbol
+	for state.traded && state.session.AcceptsOrders() {
+		low, high := state.tradedLow, state.tradedHigh
+		state.traded = false
+		stops := state.stops.popTriggered(low, high)
+		for i, stop := range stops {
+			if !state.session.AcceptsOrders() {
+				// A triggered stop tripped the breaker, the others wait for the book to resume the trading.
+				for _, waiting := range stops[i:] {
+					state.stops.add(waiting)
+				}
+				state.recordTraded(low, high)
+				return
+			}
+			b.publish(&event.StopTriggered{
+				Symbol: symbol,
+				Order:  stop,
+			})
+			order := stop
+			order.StopPrice = 0
+			// The order was already validated when the stop was accepted.
+			_ = addOrder(b, order, false)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+	obkIo "github.com/rodoufu/simple-orderbook/pkg/io"
+	"github.com/rodoufu/simple-orderbook/pkg/orderbook"
+)
+
+var (
+	missingSymbolError = fmt.Errorf("order without symbol")
+)
+
+// symbolEngine keeps an independent book for every symbol.
+// All the books publish to the same channel, so the order of the events is kept across symbols.
+type symbolEngine struct {
+	mtx     sync.Mutex
+	engines map[string]MatchingEngine
+	// orderSymbols is used to find the book of an order, since cancels do not carry the symbol.
+	// It only keeps the orders resting in a book or waiting as a stop, following the events of the books.
+	orderSymbols map[entity.OrderKey]string
+	// session is given to the books when they are created.
+	session entity.SessionState
+	// exchangeIDs is shared by the books, so the exchange ids are unique across symbols.
+	exchangeIDs uint64
+	// eventSequence numbers the events published by the engine itself, like the rejections of unknown orders, which
+	// belong to no book.
+	eventSequence uint64
+	// events is shared by all the books and consumed by forward.
+	events chan event.Event
+	output chan event.Event
+
+	booksMtx sync.RWMutex
+	books    map[string]orderbook.OrderBook
+	// opts is used to create the engine of every symbol.
+	opts []Option
+}
+
+// forward feeds the order book of each symbol before publishing the events.
+func (s *symbolEngine) forward() {
+	defer close(s.output)
+	ctx := context.Background()
+	for evt := range s.events {
+		if book := s.OrderBook(evt.BookSymbol()); book != nil {
+			// The book is only a view of the engine, a problem there should not stop the events.
+			_ = book.ProcessEvent(ctx, evt)
+		}
+		s.output <- evt
+	}
+}
+
+func (s *symbolEngine) engine(symbol string) MatchingEngine {
+	engine, ok := s.engines[symbol]
+	if !ok {
+		engine = s.newEngine(symbol)
+		// Books start in the continuous trading, so the change is published like for the other books.
+		_ = engine.ChangeSession(context.Background(), s.session)
+	}
+	return engine
+}
+
+// newEngine creates the book of the symbol without publishing anything.
+func (s *symbolEngine) newEngine(symbol string) MatchingEngine {
+	s.booksMtx.Lock()
+	s.books[symbol] = orderbook.NewListOrderBook()
+	s.booksMtx.Unlock()
+
+	var engine MatchingEngine
+	// The order books of the symbols follow the depth updates.
+	opts := append([]Option{
+		withSymbol(symbol), withExchangeIDs(&s.exchangeIDs), withListener(s.indexOrder), WithDepthUpdates(),
+	}, s.opts...)
+	if newOptions(opts...).storage == TreeStorage {
+		engine = newTreeEngine(s.events, opts...)
+	} else {
+		engine = newListEngine(s.events, opts...)
+	}
+	s.engines[symbol] = engine
+	return engine
+}
+
+// indexOrder keeps the symbol of the orders while they are in a book, it runs under the lock of the engine since the
+// books only publish while serving its requests.
+func (s *symbolEngine) indexOrder(evt event.Event) {
+	switch it := evt.(type) {
+	case *event.OrderCreated:
+		s.orderSymbols[it.Order.Key()] = it.Symbol
+	case *event.StopAccepted:
+		s.orderSymbols[it.Order.Key()] = it.Symbol
+	case *event.OrderFilled:
+		if it.Full {
+			delete(s.orderSymbols, it.Order.Key())
+		}
+	case *event.OrderCancelled:
+		delete(s.orderSymbols, it.Order.Key())
+	case *event.StopCancelled:
+		delete(s.orderSymbols, it.Order.Key())
+	case *event.StopTriggered:
+		// The triggered order is indexed again if it rests in the book.
+		delete(s.orderSymbols, it.Order.Key())
+	}
+}
+
+// OrderBook gives the book for the symbol, it is nil for symbols that never received an order.
+func (s *symbolEngine) OrderBook(symbol string) orderbook.OrderBook {
+	s.booksMtx.RLock()
+	defer s.booksMtx.RUnlock()
+	return s.books[symbol]
+}
+
+func (s *symbolEngine) AddOrder(ctx context.Context, order entity.Order) error {
+	if s == nil {
+		return notStartedError
+	}
+	if len(order.Symbol) == 0 {
+		return missingSymbolError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if err := s.expireOrders(ctx); err != nil {
+		return err
+	}
+	// Keys are unique across the books, otherwise cancels could not find the book of the order.
+	if _, ok := s.orderSymbols[order.Key()]; ok {
+		return orderExistsError(order.Key())
+	}
+	return s.engine(order.Symbol).AddOrder(ctx, order)
+}
+
+// rejectUnknownOrder publishes the rejection of a request for an order that is in none of the books.
+func (s *symbolEngine) rejectUnknownOrder(key entity.OrderKey) {
+	s.eventSequence++
+	evt := &event.RequestRejected{
+		User:    key.User,
+		OrderID: key.ID,
+		Reason:  event.RejectUnknownOrder,
+	}
+	evt.SetBookSequence(s.eventSequence)
+	s.events <- evt
+}
+
+func (s *symbolEngine) CancelOrder(ctx context.Context, key entity.OrderKey) error {
+	if s == nil {
+		return notStartedError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if err := s.expireOrders(ctx); err != nil {
+		return err
+	}
+	symbol, ok := s.orderSymbols[key]
+	if !ok {
+		s.rejectUnknownOrder(key)
+		return nil
+	}
+	return s.engines[symbol].CancelOrder(ctx, key)
+}
+
+func (s *symbolEngine) ReplaceOrder(ctx context.Context, key entity.OrderKey, price, amount entity.Decimal) error {
+	if s == nil {
+		return notStartedError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if err := s.expireOrders(ctx); err != nil {
+		return err
+	}
+	symbol, ok := s.orderSymbols[key]
+	if !ok {
+		s.rejectUnknownOrder(key)
+		return nil
+	}
+	return s.engines[symbol].ReplaceOrder(ctx, key, price, amount)
+}
+
+func (s *symbolEngine) ExpireOrders(ctx context.Context) error {
+	if s == nil {
+		return notStartedError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.expireOrders(ctx)
+}
+
+func (s *symbolEngine) expireOrders(ctx context.Context) error {
+	return s.eachEngine(func(engine MatchingEngine) error {
+		return engine.ExpireOrders(ctx)
+	})
+}
+
+// eachEngine goes through the books sorted by symbol, so the events do not depend on the map order.
+func (s *symbolEngine) eachEngine(fn func(engine MatchingEngine) error) error {
+	symbols := make([]string, 0, len(s.engines))
+	for symbol := range s.engines {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		if err := fn(s.engines[symbol]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChangeSession moves every book to the session state, including the ones created later.
+func (s *symbolEngine) ChangeSession(ctx context.Context, session entity.SessionState) error {
+	if s == nil {
+		return notStartedError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.session = session
+	return s.eachEngine(func(engine MatchingEngine) error {
+		return engine.ChangeSession(ctx, session)
+	})
+}
+
+func (s *symbolEngine) Snapshot(ctx context.Context, writer io.Writer) error {
+	if s == nil {
+		return notStartedError
+	}
+	return obkIo.WriteSnapshot(writer, 0, s.snapshot())
+}
+
+// Restore also rebuilds the order book of every symbol, without publishing the events.
+func (s *symbolEngine) Restore(ctx context.Context, reader io.Reader) error {
+	if s == nil {
+		return notStartedError
+	}
+	var snapshot engineSnapshot
+	if _, err := obkIo.ReadSnapshot(reader, &snapshot); err != nil {
+		return err
+	}
+	return s.restore(snapshot)
+}
+
+func (s *symbolEngine) snapshot() engineSnapshot {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	resp := engineSnapshot{
+		Session:       s.session,
+		ExchangeIDs:   atomic.LoadUint64(&s.exchangeIDs),
+		EventSequence: s.eventSequence,
+	}
+	_ = s.eachEngine(func(engine MatchingEngine) error {
+		resp.Books = append(resp.Books, engine.(snapshotter).snapshot().Books...)
+		return nil
+	})
+	for key, symbol := range s.orderSymbols {
+		resp.Symbols = append(resp.Symbols, symbolSnapshot{
+			Key:    key,
+			Symbol: symbol,
+		})
+	}
+	sort.Slice(resp.Symbols, func(i, j int) bool {
+		return lessKey(resp.Symbols[i].Key, resp.Symbols[j].Key)
+	})
+	return resp
+}
+
+func (s *symbolEngine) restore(snapshot engineSnapshot) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.engines = map[string]MatchingEngine{}
+	s.booksMtx.Lock()
+	s.books = map[string]orderbook.OrderBook{}
+	s.booksMtx.Unlock()
+	s.session = snapshot.Session
+	atomic.StoreUint64(&s.exchangeIDs, snapshot.ExchangeIDs)
+	s.eventSequence = snapshot.EventSequence
+
+	ctx := context.Background()
+	for _, book := range snapshot.Books {
+		engine := s.newEngine(book.Symbol)
+		if err := engine.(snapshotter).restore(engineSnapshot{Books: []bookSnapshot{book}}); err != nil {
+			return err
+		}
+		// The events were already published before the snapshot, so the order book starts from the state of the book.
+		orders := append(append([]entity.Order{}, book.Orders[entity.Buy]...), book.Orders[entity.Sell]...)
+		if err := s.OrderBook(book.Symbol).Resync(ctx, book.EventSequence, book.Session, orders); err != nil {
+			return err
+		}
+	}
+	s.orderSymbols = map[entity.OrderKey]string{}
+	for _, it := range snapshot.Symbols {
+		s.orderSymbols[it.Key] = it.Symbol
+	}
+	return nil
+}
+
+func (s *symbolEngine) ProcessTransaction(ctx context.Context, transaction obkIo.Transaction) error {
+	if s == nil {
+		return notStartedError
+	}
+	switch t := transaction.(type) {
+	case obkIo.NewOrderTransaction:
+		order := t.Order
+		if len(order.Symbol) == 0 {
+			order.Symbol = t.Symbol
+		}
+		return s.AddOrder(ctx, order)
+	case obkIo.CancelOrderTransaction:
+		return s.CancelOrder(ctx, t.Key())
+	case obkIo.ReplaceOrderTransaction:
+		return s.ReplaceOrder(ctx, t.Key(), t.Price, t.Amount)
+	case obkIo.SessionTransaction:
+		if len(t.Symbol) == 0 {
+			return s.ChangeSession(ctx, t.Session)
+		}
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		return s.engine(t.Symbol).ChangeSession(ctx, t.Session)
+	case obkIo.ExpireOrdersTransaction:
+		return s.ExpireOrders(ctx)
+	case replayTransaction:
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		s.events <- &replayMarker{done: t.done}
+		return nil
+	case obkIo.ErrorTransaction:
+		return t.Err
+	case obkIo.FlushAllOrdersTransaction:
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		defer func() {
+			s.orderSymbols = map[entity.OrderKey]string{}
+		}()
+		return s.eachEngine(func(engine MatchingEngine) error {
+			if err := engine.ProcessTransaction(ctx, t); err != nil {
+				return err
+			}
+			// The flush publishes no events, so the order book starts again from the empty book.
+			book := engine.(snapshotter).snapshot().Books[0]
+			return s.OrderBook(book.Symbol).Resync(ctx, book.EventSequence, book.Session, nil)
+		})
+	default:
+		return fmt.Errorf("problem identifying transaction: %v", transaction)
+	}
+}
+
+func (s *symbolEngine) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	close(s.events)
+	return nil
+}
+
+// NewSymbolEngine creates an engine that routes the orders to a book per symbol.
+// The books are created when the first order for the symbol arrives.
+func NewSymbolEngine(opts ...Option) (SymbolEngine, <-chan event.Event) {
+	engine := symbolEngine{
+		mtx:          sync.Mutex{},
+		engines:      map[string]MatchingEngine{},
+		orderSymbols: map[entity.OrderKey]string{},
+		events:       make(chan event.Event, 10),
+		output:       make(chan event.Event, 10),
+		booksMtx:     sync.RWMutex{},
+		books:        map[string]orderbook.OrderBook{},
+		opts:         opts,
+	}
+	go engine.forward()
+	return &engine, engine.output
+}
@@ -0,0 +1,565 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+	"github.com/rodoufu/simple-orderbook/pkg/io"
+	"github.com/rodoufu/simple-orderbook/pkg/orderbook"
+)
+
+func Test_symbolEngine_ProcessTransaction(t *testing.T) {
+	t.Parallel()
+
+	type args struct {
+		ctx          context.Context
+		transactions []io.Transaction
+	}
+	tests := []struct {
+		name       string
+		args       args
+		wantEvents []string
+		wantTops   map[string][]*orderbook.BookLevel
+	}{
+		{
+			name: "orders of different symbols do not match",
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     100,
+							ID:        1,
+							Side:      entity.Buy,
+							User:      1,
+							Timestamp: time.UnixMilli(1),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "AAPL",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     100,
+							ID:        2,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+					io.CancelOrderTransaction{
+						User:    1,
+						OrderID: 1,
+					},
+					io.NewOrderTransaction{
+						Symbol: "AAPL",
+						Order: entity.Order{
+							Amount:    4,
+							Price:     100,
+							ID:        3,
+							Side:      entity.Buy,
+							User:      3,
+							Timestamp: time.UnixMilli(3),
+						},
+					},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, B, 100, 10",
+				"A, 2, 2",
+				"B, S, 100, 10",
+				"A, 1, 1",
+				"B, B, -, -",
+				"A, 3, 3",
+				"T, 3, 3, 2, 2, 100, 4",
+				"B, S, 100, 6",
+			},
+			wantTops: map[string][]*orderbook.BookLevel{
+				"IBM": {nil, nil},
+				"AAPL": {
+					nil,
+					{
+						Side:          entity.Sell,
+						Price:         100,
+						TotalQuantity: 6,
+					},
+				},
+			},
+		},
+		{
+			name: "flush clears every book",
+			args: args{
+				ctx: context.Background(),
+				transactions: []io.Transaction{
+					io.NewOrderTransaction{
+						Symbol: "IBM",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     100,
+							ID:        1,
+							Side:      entity.Buy,
+							User:      1,
+							Timestamp: time.UnixMilli(1),
+						},
+					},
+					io.NewOrderTransaction{
+						Symbol: "AAPL",
+						Order: entity.Order{
+							Amount:    10,
+							Price:     100,
+							ID:        2,
+							Side:      entity.Sell,
+							User:      2,
+							Timestamp: time.UnixMilli(2),
+						},
+					},
+					io.FlushAllOrdersTransaction{},
+				},
+			},
+			wantEvents: []string{
+				"A, 1, 1",
+				"B, B, 100, 10",
+				"A, 2, 2",
+				"B, S, 100, 10",
+			},
+			wantTops: map[string][]*orderbook.BookLevel{
+				"IBM":  {nil, nil},
+				"AAPL": {nil, nil},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			engine, events := NewSymbolEngine()
+			gotEventsCh := make(chan []string)
+			go func() {
+				gotEventsCh <- toListEventsOutput(tt.args.ctx, events)
+			}()
+			for i, transaction := range tt.args.transactions {
+				if err := engine.ProcessTransaction(tt.args.ctx, transaction); err != nil {
+					t.Errorf("ProcessTransaction(%d) error = %v", i, err)
+				}
+			}
+			engine.Close()
+			if gotEvents := <-gotEventsCh; !reflect.DeepEqual(gotEvents, tt.wantEvents) {
+				t.Errorf("ProcessTransaction() events: %v, want: %v", gotEvents, tt.wantEvents)
+			}
+			for symbol, wantTops := range tt.wantTops {
+				book := engine.OrderBook(symbol)
+				if book == nil {
+					t.Fatalf("OrderBook(%v) not found", symbol)
+				}
+				gotTops := []*orderbook.BookLevel{book.TopBid(tt.args.ctx), book.TopAsk(tt.args.ctx)}
+				if !reflect.DeepEqual(gotTops, wantTops) {
+					t.Errorf("OrderBook(%v) tops: %+v, want: %+v", symbol, gotTops, wantTops)
+				}
+			}
+		})
+	}
+}
+
+func Test_symbolEngine_exchangeIDs(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	engine, events := NewSymbolEngine()
+	gotEventsCh := make(chan []event.Event)
+	go func() {
+		gotEventsCh <- toListEvents(events)
+	}()
+	// Both users use the order id 1, the exchange ids are unique across the books.
+	// The rejected orders take no exchange id, so the ids have no gaps.
+	orders := []io.NewOrderTransaction{
+		{Symbol: "IBM", Order: entity.Order{Amount: 10, Price: 100, ID: 1, Side: entity.Sell, User: 1}},
+		{Symbol: "IBM", Order: entity.Order{
+			Amount: 10, Price: 100, ID: 3, Side: entity.Buy, User: 3, PostOnly: entity.PostOnlyReject,
+		}},
+		{Symbol: "AAPL", Order: entity.Order{Amount: 10, Price: 100, ID: 1, Side: entity.Sell, User: 2}},
+		{Symbol: "IBM", Order: entity.Order{Amount: 4, Price: 100, ID: 2, Side: entity.Buy, User: 2}},
+	}
+	for i, transaction := range orders {
+		if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+			t.Errorf("ProcessTransaction(%d) error = %v", i, err)
+		}
+	}
+	engine.Close()
+
+	var gotAcks []entity.ExchangeOrderID
+	var gotTrades [][2]entity.ExchangeOrderID
+	for _, evt := range <-gotEventsCh {
+		switch it := evt.(type) {
+		case *event.OrderAcknowledge:
+			gotAcks = append(gotAcks, it.Order.ExchangeID)
+		case *event.TradeGenerated:
+			gotTrades = append(gotTrades, [2]entity.ExchangeOrderID{it.Trade.BuyExchangeID, it.Trade.SellExchangeID})
+		}
+	}
+	if wantAcks := []entity.ExchangeOrderID{1, 2, 3}; !reflect.DeepEqual(gotAcks, wantAcks) {
+		t.Errorf("acknowledged exchange ids: %v, want: %v", gotAcks, wantAcks)
+	}
+	if wantTrades := [][2]entity.ExchangeOrderID{{3, 1}}; !reflect.DeepEqual(gotTrades, wantTrades) {
+		t.Errorf("traded exchange ids: %v, want: %v", gotTrades, wantTrades)
+	}
+}
+
+func Test_symbolEngine_scale(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	instruments := entity.Instruments{
+		"IBM":  {Symbol: "IBM", Scale: entity.Scale{Price: 2, Quantity: 1}},
+		"AAPL": {Symbol: "AAPL"},
+	}
+	engine, events := NewSymbolEngine(WithInstruments(instruments))
+	gotEventsCh := make(chan []string)
+	go func() {
+		gotEventsCh <- toListEventsOutput(ctx, events)
+	}()
+	transactions := []io.Transaction{
+		io.NewOrderTransaction{
+			Symbol: "IBM",
+			Order:  entity.Order{Symbol: "IBM", Amount: 15, Price: 10125, ID: 1, Side: entity.Sell, User: 1},
+		},
+		io.NewOrderTransaction{
+			Symbol: "IBM",
+			Order:  entity.Order{Symbol: "IBM", Amount: 5, Price: 10150, ID: 2, Side: entity.Buy, User: 2},
+		},
+		io.NewOrderTransaction{
+			Symbol: "AAPL",
+			Order:  entity.Order{Symbol: "AAPL", Amount: 15, Price: 10125, ID: 3, Side: entity.Sell, User: 3},
+		},
+	}
+	for i, transaction := range transactions {
+		if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+			t.Errorf("ProcessTransaction(%d) error = %v", i, err)
+		}
+	}
+	engine.Close()
+
+	// The prices and the amounts are printed with the precision of each instrument.
+	wantEvents := []string{
+		"A, 1, 1",
+		"B, S, 101.25, 1.5",
+		"A, 2, 2",
+		"T, 2, 2, 1, 1, 101.25, 0.5",
+		"B, S, 101.25, 1.0",
+		"A, 3, 3",
+		"B, S, 10125, 15",
+	}
+	if gotEvents := <-gotEventsCh; !reflect.DeepEqual(gotEvents, wantEvents) {
+		t.Errorf("events: %v, want: %v", gotEvents, wantEvents)
+	}
+}
+
+func Test_symbolEngine_sequence(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	engine, events := NewSymbolEngine()
+	sequencesCh := make(chan map[string][]uint64)
+	go func() {
+		resp := map[string][]uint64{}
+		for evt := range events {
+			resp[evt.BookSymbol()] = append(resp[evt.BookSymbol()], evt.BookSequence())
+		}
+		sequencesCh <- resp
+	}()
+	transactions := []io.Transaction{
+		io.NewOrderTransaction{
+			Symbol: "IBM",
+			Order:  entity.Order{Symbol: "IBM", Amount: 15, Price: 10, ID: 1, Side: entity.Sell, User: 1},
+		},
+		io.NewOrderTransaction{
+			Symbol: "AAPL",
+			Order:  entity.Order{Symbol: "AAPL", Amount: 15, Price: 10, ID: 2, Side: entity.Sell, User: 2},
+		},
+		io.NewOrderTransaction{
+			Symbol: "IBM",
+			Order:  entity.Order{Symbol: "IBM", Amount: 5, Price: 10, ID: 3, Side: entity.Buy, User: 3},
+		},
+		io.CancelOrderTransaction{User: 9, OrderID: 9},
+		io.CancelOrderTransaction{User: 2, OrderID: 2},
+	}
+	for i, transaction := range transactions {
+		if err := engine.ProcessTransaction(ctx, transaction); err != nil {
+			t.Errorf("ProcessTransaction(%d) error = %v", i, err)
+		}
+	}
+	engine.Close()
+
+	// Every book numbers its own events, the rejection of the unknown order belongs to no book.
+	sequences := <-sequencesCh
+	for symbol, got := range sequences {
+		for i, sequence := range got {
+			if sequence != uint64(i+1) {
+				t.Errorf("%q events sequences = %v, want them from 1 without gaps", symbol, got)
+				break
+			}
+		}
+	}
+	for _, symbol := range []string{"IBM", "AAPL"} {
+		book := engine.OrderBook(symbol)
+		want := uint64(len(sequences[symbol]))
+		if got := book.Sequence(ctx); got != want || book.NeedsResync(ctx) {
+			t.Errorf(
+				"OrderBook(%q).Sequence() = %v, NeedsResync() = %v, want %v", symbol, got, book.NeedsResync(ctx), want,
+			)
+		}
+	}
+	if len(sequences[""]) != 1 {
+		t.Errorf("events without book = %v, want 1", sequences[""])
+	}
+}
+
+func Test_symbolEngine_orderSymbols(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	start := time.UnixMilli(1_000_000)
+	clock := entity.NewManualClock(start)
+	engine, events := NewSymbolEngine(WithClock(clock))
+	go toListEvents(events)
+	symbols := engine.(*symbolEngine)
+
+	newOrder := func(symbol string, order entity.Order) io.Transaction {
+		order.User = entity.UserID(order.ID)
+		order.Symbol = symbol
+		return io.NewOrderTransaction{Symbol: symbol, Order: order}
+	}
+	// Only the orders resting in a book or waiting as a stop are indexed.
+	steps := []struct {
+		transaction io.Transaction
+		want        map[entity.OrderKey]string
+	}{
+		{
+			transaction: newOrder("IBM", entity.Order{Amount: 10, Price: 100, ID: 1, Side: entity.Sell}),
+			want:        map[entity.OrderKey]string{{User: 1, ID: 1}: "IBM"},
+		},
+		{
+			transaction: newOrder("IBM", entity.Order{Amount: 10, Price: 100, ID: 2, Side: entity.Buy}),
+			want:        map[entity.OrderKey]string{},
+		},
+		{
+			transaction: newOrder("IBM", entity.Order{
+				Amount:      10,
+				Price:       90,
+				ID:          3,
+				Side:        entity.Buy,
+				TimeInForce: entity.GoodTillTime,
+				ExpireAt:    start.Add(4 * time.Second),
+			}),
+			want: map[entity.OrderKey]string{{User: 3, ID: 3}: "IBM"},
+		},
+		{
+			transaction: newOrder("AAPL", entity.Order{Amount: 10, ID: 4, Side: entity.Buy, Type: entity.Market}),
+			want:        map[entity.OrderKey]string{{User: 3, ID: 3}: "IBM"},
+		},
+		{
+			transaction: io.ExpireOrdersTransaction{},
+			want:        map[entity.OrderKey]string{},
+		},
+		{
+			transaction: io.ReplaceOrderTransaction{User: 1, OrderID: 1, Price: 101, Amount: 5},
+			want:        map[entity.OrderKey]string{},
+		},
+		{
+			transaction: newOrder("AAPL", entity.Order{Amount: 10, Price: 50, ID: 5, Side: entity.Sell}),
+			want:        map[entity.OrderKey]string{{User: 5, ID: 5}: "AAPL"},
+		},
+		{
+			transaction: newOrder("AAPL", entity.Order{Amount: 1, Price: 60, StopPrice: 55, ID: 6, Side: entity.Buy}),
+			want:        map[entity.OrderKey]string{{User: 5, ID: 5}: "AAPL", {User: 6, ID: 6}: "AAPL"},
+		},
+		{
+			transaction: io.FlushAllOrdersTransaction{},
+			want:        map[entity.OrderKey]string{},
+		},
+	}
+	for i, step := range steps {
+		clock.Set(start.Add(time.Duration(i) * time.Second))
+		if err := engine.ProcessTransaction(ctx, step.transaction); err != nil {
+			t.Errorf("ProcessTransaction(%d) error = %v", i, err)
+		}
+		// The index is only used by the requests, which are served one at a time.
+		symbols.mtx.Lock()
+		if !reflect.DeepEqual(symbols.orderSymbols, step.want) {
+			t.Errorf("orderSymbols after %d = %v, want %v", i, symbols.orderSymbols, step.want)
+		}
+		symbols.mtx.Unlock()
+	}
+	engine.Close()
+}
+
+func Test_symbolEngine_keys(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	engine, events := NewSymbolEngine()
+	gotEventsCh := make(chan []string)
+	go func() {
+		gotEventsCh <- toListEventsOutput(ctx, events)
+	}()
+	order := entity.Order{Amount: 10, Price: 100, ID: 1, Side: entity.Buy, User: 1}
+	if err := engine.ProcessTransaction(ctx, io.NewOrderTransaction{Symbol: "IBM", Order: order}); err != nil {
+		t.Fatalf("ProcessTransaction() error = %v", err)
+	}
+	// The key is still alive in another book.
+	if err := engine.ProcessTransaction(ctx, io.NewOrderTransaction{Symbol: "AAPL", Order: order}); err == nil {
+		t.Errorf("ProcessTransaction() expected error for a key used in another symbol")
+	}
+	if err := engine.ProcessTransaction(ctx, io.CancelOrderTransaction{User: 1, OrderID: 1}); err != nil {
+		t.Fatalf("ProcessTransaction() error = %v", err)
+	}
+	// Once the order is gone the key can be used again.
+	if err := engine.ProcessTransaction(ctx, io.NewOrderTransaction{Symbol: "AAPL", Order: order}); err != nil {
+		t.Errorf("ProcessTransaction() error = %v", err)
+	}
+	engine.Close()
+
+	wantEvents := []string{
+		"A, 1, 1",
+		"B, B, 100, 10",
+		"A, 1, 1",
+		"B, B, -, -",
+		"A, 1, 1",
+		"B, B, 100, 10",
+	}
+	if gotEvents := <-gotEventsCh; !reflect.DeepEqual(gotEvents, wantEvents) {
+		t.Errorf("events: %v, want: %v", gotEvents, wantEvents)
+	}
+	if top := engine.OrderBook("IBM").TopBid(ctx); top != nil {
+		t.Errorf("OrderBook(IBM).TopBid() = %+v, want nil", top)
+	}
+}
+
+func Test_symbolEngine_flush(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	engine, events := NewSymbolEngine()
+	gotEventsCh := make(chan []string)
+	go func() {
+		gotEventsCh <- toListEventsOutput(ctx, events)
+	}()
+	newOrder := func(symbol string, order entity.Order) {
+		order.User = 1
+		if err := engine.ProcessTransaction(ctx, io.NewOrderTransaction{Symbol: symbol, Order: order}); err != nil {
+			t.Fatalf("ProcessTransaction() error = %v", err)
+		}
+	}
+	newOrder("MSFT", entity.Order{Amount: 10, Price: 100, ID: 1, Side: entity.Buy})
+	newOrder("IBM", entity.Order{Amount: 10, Price: 100, ID: 2, Side: entity.Sell})
+	newOrder("AAPL", entity.Order{Amount: 10, Price: 110, StopPrice: 105, ID: 3, Side: entity.Buy})
+	if err := engine.ProcessTransaction(ctx, io.FlushAllOrdersTransaction{}); err != nil {
+		t.Fatalf("ProcessTransaction() error = %v", err)
+	}
+	// The keys of the flushed orders can be used again, and the order books follow the books after the flush.
+	newOrder("IBM", entity.Order{Amount: 5, Price: 90, ID: 1, Side: entity.Buy})
+	engine.Close()
+
+	// The flush publishes nothing.
+	wantEvents := []string{
+		"A, 1, 1",
+		"B, B, 100, 10",
+		"A, 1, 2",
+		"B, S, 100, 10",
+		"A, 1, 3",
+		"A, 1, 1",
+		"B, B, 90, 5",
+	}
+	if gotEvents := <-gotEventsCh; !reflect.DeepEqual(gotEvents, wantEvents) {
+		t.Errorf("events: %v, want: %v", gotEvents, wantEvents)
+	}
+	for _, symbol := range []string{"AAPL", "IBM", "MSFT"} {
+		book := engine.OrderBook(symbol)
+		gotTops := []*orderbook.BookLevel{book.TopBid(ctx), book.TopAsk(ctx)}
+		wantTops := []*orderbook.BookLevel{nil, nil}
+		if symbol == "IBM" {
+			wantTops[0] = &orderbook.BookLevel{Side: entity.Buy, Price: 90, TotalQuantity: 5}
+		}
+		if !reflect.DeepEqual(gotTops, wantTops) || book.NeedsResync(ctx) {
+			t.Errorf("OrderBook(%v) tops: %+v, NeedsResync() = %v, want: %+v",
+				symbol, gotTops, book.NeedsResync(ctx), wantTops)
+		}
+	}
+}
+
+func Test_symbolEngine_orderBookCancelled(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	engine, events := NewSymbolEngine()
+	gotEventsCh := make(chan []string)
+	go func() {
+		gotEventsCh <- toListEventsOutput(ctx, events)
+	}()
+	orders := []entity.Order{
+		{Amount: 10, Price: 100, ID: 1, Side: entity.Sell, User: 1},
+		// The leftover of the immediate or cancel order is cancelled without resting.
+		{Amount: 15, Price: 100, ID: 2, Side: entity.Buy, User: 2, TimeInForce: entity.ImmediateOrCancel},
+		{Amount: 10, Price: 101, ID: 3, Side: entity.Sell, User: 1},
+		// The fill or kill order is killed without resting.
+		{Amount: 20, Price: 101, ID: 4, Side: entity.Buy, User: 2, TimeInForce: entity.FillOrKill},
+		// The self-trade prevention cancels the incoming order without resting.
+		{Amount: 5, Price: 101, ID: 5, Side: entity.Buy, User: 1, SelfTrade: entity.CancelNewest},
+		{Amount: 5, Price: 99, ID: 6, Side: entity.Buy, User: 2},
+	}
+	for _, order := range orders {
+		order.Symbol = "IBM"
+		if err := engine.ProcessTransaction(ctx, io.NewOrderTransaction{Symbol: "IBM", Order: order}); err != nil {
+			t.Fatalf("ProcessTransaction(%v) error = %v", order.ID, err)
+		}
+	}
+	engine.Close()
+	<-gotEventsCh
+
+	book := engine.OrderBook("IBM")
+	if book.NeedsResync(ctx) {
+		t.Fatalf("NeedsResync() = true")
+	}
+	gotTops := []*orderbook.BookLevel{book.TopBid(ctx), book.TopAsk(ctx)}
+	wantTops := []*orderbook.BookLevel{
+		{Side: entity.Buy, Price: 99, TotalQuantity: 5},
+		{Side: entity.Sell, Price: 101, TotalQuantity: 10},
+	}
+	if !reflect.DeepEqual(gotTops, wantTops) {
+		t.Errorf("tops: %+v, want: %+v", gotTops, wantTops)
+	}
+}
+
+func Test_symbolEngine_orderBookQueue(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	engine, events := NewSymbolEngine()
+	gotEventsCh := make(chan []string)
+	go func() {
+		gotEventsCh <- toListEventsOutput(ctx, events)
+	}()
+	orders := []entity.Order{
+		// The stop is older than the buy, but it only joins the queue once the trade triggers it.
+		{Amount: 5, Price: 100, StopPrice: 100, ID: 1, Side: entity.Buy, User: 1, Timestamp: time.UnixMilli(1)},
+		{Amount: 5, Price: 100, ID: 2, Side: entity.Buy, User: 2, Timestamp: time.UnixMilli(2)},
+		{Amount: 1, Price: 100, ID: 3, Side: entity.Sell, User: 3, Timestamp: time.UnixMilli(3)},
+	}
+	for _, order := range orders {
+		order.Symbol = "IBM"
+		if err := engine.ProcessTransaction(ctx, io.NewOrderTransaction{Symbol: "IBM", Order: order}); err != nil {
+			t.Fatalf("ProcessTransaction(%v) error = %v", order.ID, err)
+		}
+	}
+	engine.Close()
+	<-gotEventsCh
+
+	var wantIDs []entity.OrderID
+	for _, order := range engine.(snapshotter).snapshot().Books[0].Orders[entity.Buy] {
+		wantIDs = append(wantIDs, order.ID)
+	}
+	var gotIDs []entity.OrderID
+	for _, order := range engine.OrderBook("IBM").OrdersAt(ctx, entity.Buy, 100) {
+		gotIDs = append(gotIDs, order.ID)
+	}
+	if !reflect.DeepEqual(gotIDs, wantIDs) || !reflect.DeepEqual(gotIDs, []entity.OrderID{2, 1}) {
+		t.Errorf("OrdersAt(Buy, 100) ids = %v, engine queue %v, want [2 1]", gotIDs, wantIDs)
+	}
+}
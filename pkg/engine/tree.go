@@ -0,0 +1,273 @@
+package engine
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+	obkIo "github.com/rodoufu/simple-orderbook/pkg/io"
+)
+
+// treeOrder is the position of an order in the book.
+type treeOrder struct {
+	level   *priceLevel
+	element *list.Element
+}
+
+// treeEngine keeps each side of the book in a tree of price levels, with the orders of each level in a queue.
+// Adding an order costs $O(log n)$ where $n$ is the number of price levels, and cancelling costs $O(1)$ unless the
+// level gets empty and has to be removed from the tree.
+type treeEngine struct {
+	mtx    sync.Mutex
+	sides  map[entity.Side]*priceTree
+	orders map[entity.OrderKey]treeOrder
+	events chan event.Event
+	// options used to create the engine.
+	options options
+	engineState
+}
+
+func (s *treeEngine) ProcessTransaction(ctx context.Context, transaction obkIo.Transaction) error {
+	switch t := transaction.(type) {
+	case obkIo.NewOrderTransaction:
+		return s.AddOrder(ctx, t.Order)
+	case obkIo.CancelOrderTransaction:
+		return s.CancelOrder(ctx, t.Key())
+	case obkIo.ReplaceOrderTransaction:
+		return s.ReplaceOrder(ctx, t.Key(), t.Price, t.Amount)
+	case obkIo.SessionTransaction:
+		return s.ChangeSession(ctx, t.Session)
+	case obkIo.ExpireOrdersTransaction:
+		return s.ExpireOrders(ctx)
+	case replayTransaction:
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		// The marker is not an event of the book, so it takes no sequence number.
+		s.events <- &replayMarker{done: t.done}
+		return nil
+	case obkIo.ErrorTransaction:
+		return t.Err
+	case obkIo.FlushAllOrdersTransaction:
+		if s == nil {
+			return notStartedError
+		}
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		flushOrders(s)
+		return nil
+	default:
+		return fmt.Errorf("problem identifying transaction: %v", transaction)
+	}
+}
+
+func (s *treeEngine) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	close(s.events)
+	return nil
+}
+
+func (s *treeEngine) AddOrder(ctx context.Context, order entity.Order) error {
+	if s == nil {
+		return notStartedError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return submitOrder(s, order)
+}
+
+func (s *treeEngine) CancelOrder(ctx context.Context, key entity.OrderKey) error {
+	if s == nil {
+		return notStartedError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return cancelOrder(s, key)
+}
+
+// bestLevel gives the level with the best price for the side, nil for an empty side.
+func (s *treeEngine) bestLevel(side entity.Side) *priceLevel {
+	if side == entity.Buy {
+		return s.sides[side].last()
+	}
+	return s.sides[side].first()
+}
+
+func (s *treeEngine) ReplaceOrder(ctx context.Context, key entity.OrderKey, price, amount entity.Decimal) error {
+	if s == nil {
+		return notStartedError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return replaceOrder(s, key, price, amount)
+}
+
+func (s *treeEngine) ExpireOrders(ctx context.Context) error {
+	if s == nil {
+		return notStartedError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	expireOrders(s)
+	return nil
+}
+
+func (s *treeEngine) ChangeSession(ctx context.Context, session entity.SessionState) error {
+	if s == nil {
+		return notStartedError
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	changeSession(s, session)
+	return nil
+}
+
+func (s *treeEngine) Snapshot(ctx context.Context, writer io.Writer) error {
+	if s == nil {
+		return notStartedError
+	}
+	return obkIo.WriteSnapshot(writer, 0, s.snapshot())
+}
+
+func (s *treeEngine) Restore(ctx context.Context, reader io.Reader) error {
+	if s == nil {
+		return notStartedError
+	}
+	var snapshot engineSnapshot
+	if _, err := obkIo.ReadSnapshot(reader, &snapshot); err != nil {
+		return err
+	}
+	return s.restore(snapshot)
+}
+
+func (s *treeEngine) snapshot() engineSnapshot {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return snapshotSingleBook(s)
+}
+
+func (s *treeEngine) restore(snapshot engineSnapshot) error {
+	book, err := singleBook(snapshot)
+	if err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.sides = map[entity.Side]*priceTree{
+		entity.Buy:  {},
+		entity.Sell: {},
+	}
+	s.orders = map[entity.OrderKey]treeOrder{}
+	return restoreBook(s, book)
+}
+
+func (s *treeEngine) best(side entity.Side) *entity.Order {
+	level := s.bestLevel(side)
+	if level == nil {
+		return nil
+	}
+	return level.orders.Front().Value.(*entity.Order)
+}
+
+func (s *treeEngine) removeBest(side entity.Side) {
+	if top := s.best(side); top != nil {
+		s.remove(top.Key())
+	}
+}
+
+func (s *treeEngine) insert(order entity.Order) {
+	level := s.sides[order.Side].getOrCreate(order.Price)
+	s.orders[order.Key()] = treeOrder{
+		level:   level,
+		element: level.orders.PushBack(&order),
+	}
+}
+
+func (s *treeEngine) remove(key entity.OrderKey) (entity.Order, bool) {
+	position, orderExists := s.orders[key]
+	if !orderExists {
+		return entity.Order{}, false
+	}
+
+	order := position.level.orders.Remove(position.element).(*entity.Order)
+	delete(s.orders, key)
+	if position.level.orders.Len() == 0 {
+		s.sides[order.Side].delete(position.level.price)
+	}
+	return *order, true
+}
+
+func (s *treeEngine) sideOf(key entity.OrderKey) (entity.Side, bool) {
+	position, orderExists := s.orders[key]
+	if !orderExists {
+		return entity.InvalidSide, false
+	}
+	return position.element.Value.(*entity.Order).Side, true
+}
+
+func (s *treeEngine) find(key entity.OrderKey) *entity.Order {
+	position, orderExists := s.orders[key]
+	if !orderExists {
+		return nil
+	}
+	return position.element.Value.(*entity.Order)
+}
+
+func (s *treeEngine) walk(side entity.Side, fn func(order *entity.Order) bool) {
+	// The best buy orders have the highest prices.
+	s.sides[side].walk(side == entity.Sell, func(level *priceLevel) bool {
+		for it := level.orders.Front(); it != nil; it = it.Next() {
+			if !fn(it.Value.(*entity.Order)) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (s *treeEngine) levelQuantity(side entity.Side, price entity.Decimal) entity.Decimal {
+	var total entity.Decimal
+	if level := s.sides[side].get(price); level != nil {
+		for it := level.orders.Front(); it != nil; it = it.Next() {
+			total += it.Value.(*entity.Order).Amount
+		}
+	}
+	return total
+}
+
+func (s *treeEngine) publish(evt event.Event) {
+	evt.SetBookSequence(s.nextEventSequence())
+	if s.options.listener != nil {
+		s.options.listener(evt)
+	}
+	s.events <- evt
+}
+
+func (s *treeEngine) config() *options {
+	return &s.options
+}
+
+func newTreeEngine(events chan event.Event, opts ...Option) *treeEngine {
+	return &treeEngine{
+		mtx: sync.Mutex{},
+		sides: map[entity.Side]*priceTree{
+			entity.Buy:  {},
+			entity.Sell: {},
+		},
+		orders:  map[entity.OrderKey]treeOrder{},
+		events:  events,
+		options: newOptions(opts...),
+	}
+}
+
+func NewTreeEngine(opts ...Option) (MatchingEngine, <-chan event.Event) {
+	engine := newTreeEngine(make(chan event.Event, 10), opts...)
+	return engine, engine.events
+}
@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
+	"github.com/rodoufu/simple-orderbook/pkg/io"
+)
+
+func (n *priceNode) checkBalance(t *testing.T) int {
+	if n == nil {
+		return 0
+	}
+	left, right := n.left.checkBalance(t), n.right.checkBalance(t)
+	if left-right > 1 || right-left > 1 {
+		t.Errorf("unbalanced node %v: left %v, right %v", n.level.price, left, right)
+	}
+	height := 1 + left
+	if right >= left {
+		height = 1 + right
+	}
+	if height != n.height {
+		t.Errorf("node %v height = %v, want %v", n.level.price, n.height, height)
+	}
+	return height
+}
+
+func Test_priceTree(t *testing.T) {
+	t.Parallel()
+	random := rand.New(rand.NewSource(42))
+	tree := priceTree{}
+	prices := map[entity.Decimal]bool{}
+	for i := 0; i < 2000; i++ {
+		price := entity.Decimal(random.Intn(300))
+		if prices[price] && random.Intn(2) == 0 {
+			tree.delete(price)
+			delete(prices, price)
+		} else {
+			tree.getOrCreate(price)
+			prices[price] = true
+		}
+	}
+	tree.root.checkBalance(t)
+
+	var gotAscending, gotDescending []entity.Decimal
+	tree.walk(true, func(level *priceLevel) bool {
+		gotAscending = append(gotAscending, level.price)
+		return true
+	})
+	tree.walk(false, func(level *priceLevel) bool {
+		gotDescending = append(gotDescending, level.price)
+		return true
+	})
+	var wantAscending, wantDescending []entity.Decimal
+	for price := entity.Decimal(0); price < 300; price++ {
+		if prices[price] {
+			wantAscending = append(wantAscending, price)
+			wantDescending = append([]entity.Decimal{price}, wantDescending...)
+			if tree.get(price) == nil {
+				t.Errorf("get(%v) not found", price)
+			}
+		} else if tree.get(price) != nil {
+			t.Errorf("get(%v) found a deleted level", price)
+		}
+	}
+	if !reflect.DeepEqual(gotAscending, wantAscending) {
+		t.Errorf("walk(true) = %v, want %v", gotAscending, wantAscending)
+	}
+	if !reflect.DeepEqual(gotDescending, wantDescending) {
+		t.Errorf("walk(false) = %v, want %v", gotDescending, wantDescending)
+	}
+	if first := tree.first(); first == nil || first.price != wantAscending[0] {
+		t.Errorf("first() = %+v, want %v", first, wantAscending[0])
+	}
+	if last := tree.last(); last == nil || last.price != wantDescending[0] {
+		t.Errorf("last() = %+v, want %v", last, wantDescending[0])
+	}
+}
+
+// randomTransactions generates orders around the same prices, so they match and sit in the same levels.
+func randomTransactions(random *rand.Rand, size int) []io.Transaction {
+	var resp []io.Transaction
+	var orderIDs []entity.OrderID
+	users := map[entity.OrderID]entity.UserID{}
+	// randomUser is mostly the owner of the order, so few requests are rejected.
+	randomUser := func(orderID entity.OrderID) entity.UserID {
+		if random.Intn(10) == 0 {
+			return entity.UserID(1 + random.Intn(5))
+		}
+		return users[orderID]
+	}
+	for i := 0; i < size; i++ {
+		if len(orderIDs) > 0 && random.Intn(4) == 0 {
+			orderID := orderIDs[random.Intn(len(orderIDs))]
+			resp = append(resp, io.CancelOrderTransaction{
+				User:    randomUser(orderID),
+				OrderID: orderID,
+			})
+			continue
+		}
+		if len(orderIDs) > 0 && random.Intn(8) == 0 {
+			orderID := orderIDs[random.Intn(len(orderIDs))]
+			resp = append(resp, io.ReplaceOrderTransaction{
+				User:    randomUser(orderID),
+				OrderID: orderID,
+				Price:   entity.Decimal(95 + random.Intn(10)),
+				Amount:  entity.Decimal(1 + random.Intn(100)),
+			})
+			continue
+		}
+		order := entity.Order{
+			Amount:    entity.Decimal(1 + random.Intn(100)),
+			Price:     entity.Decimal(95 + random.Intn(10)),
+			ID:        entity.OrderID(i + 1),
+			Side:      entity.Buy,
+			User:      entity.UserID(1 + random.Intn(5)),
+			Timestamp: time.UnixMilli(int64(i)),
+		}
+		if random.Intn(2) == 0 {
+			order.Side = entity.Sell
+		}
+		if random.Intn(20) == 0 {
+			order.Type = entity.Market
+			order.Price = 0
+		}
+		if random.Intn(10) == 0 {
+			order.TimeInForce = entity.TimeInForce(1 + random.Intn(2))
+		} else if random.Intn(10) == 0 {
+			order.PostOnly = entity.PostOnly(1 + random.Intn(2))
+		}
+		if random.Intn(5) == 0 {
+			order.DisplayAmount = entity.Decimal(1 + random.Intn(20))
+		}
+		if random.Intn(10) == 0 {
+			order.SelfTrade = entity.SelfTradePrevention(random.Intn(6))
+		}
+		if random.Intn(10) == 0 {
+			order.StopPrice = entity.Decimal(95 + random.Intn(10))
+		}
+		orderIDs = append(orderIDs, order.ID)
+		users[order.ID] = order.User
+		resp = append(resp, io.NewOrderTransaction{
+			Symbol: "IBM",
+			Order:  order,
+		})
+	}
+	return resp
+}
+
+func toListEvents(events <-chan event.Event) []event.Event {
+	var resp []event.Event
+	for evt := range events {
+		if trade, ok := evt.(*event.TradeGenerated); ok {
+			// Hardcoding the timestamp
+			trade.Trade.Timestamp = time.Time{}
+		}
+		resp = append(resp, evt)
+	}
+	return resp
+}
+
+func runTransactions(
+	ctx context.Context, engine MatchingEngine, events <-chan event.Event, transactions []io.Transaction,
+) []event.Event {
+	gotEvents := make(chan []event.Event)
+	go func() {
+		gotEvents <- toListEvents(events)
+	}()
+	for _, transaction := range transactions {
+		_ = engine.ProcessTransaction(ctx, transaction)
+	}
+	engine.Close()
+	return <-gotEvents
+}
+
+func Test_treeEngine_sameEventsAsList(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		opts []Option
+		seed int64
+	}{
+		{
+			name: "match crossing",
+			seed: 1,
+		},
+		{
+			name: "reject crossing",
+			opts: []Option{WithMode(RejectCrossing)},
+			seed: 2,
+		},
+		{
+			name: "pro-rata",
+			opts: []Option{WithMatchingPolicy(ProRata{MinAllocation: 2, TopOrderPriority: true})},
+			seed: 3,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			transactions := randomTransactions(rand.New(rand.NewSource(tt.seed)), 2000)
+			transactions = append(transactions, io.FlushAllOrdersTransaction{})
+
+			// Replaced orders are timestamped by the engine, both need the same time.
+			opts := append([]Option{WithClock(entity.NewManualClock(time.UnixMilli(42)))}, tt.opts...)
+			listEngine, listEvents := NewListEngine(opts...)
+			treeEngine, treeEvents := NewTreeEngine(opts...)
+			wantEvents := runTransactions(ctx, listEngine, listEvents, transactions)
+			gotEvents := runTransactions(ctx, treeEngine, treeEvents, transactions)
+			if len(gotEvents) != len(wantEvents) {
+				t.Fatalf("tree engine generated %v events, want %v", len(gotEvents), len(wantEvents))
+			}
+			for i := range wantEvents {
+				if !reflect.DeepEqual(gotEvents[i], wantEvents[i]) {
+					t.Fatalf("event %v = %+v, want %+v", i, gotEvents[i], wantEvents[i])
+				}
+			}
+		})
+	}
+}
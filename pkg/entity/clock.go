@@ -0,0 +1,51 @@
+package entity
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock gives the current time, so the time used for orders and trades can be controlled.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock reads the time of the operating system.
+var SystemClock Clock = systemClock{}
+
+// ManualClock only moves when told so, it is used to drive the time by hand in tests and replays.
+type ManualClock struct {
+	mtx sync.Mutex
+	now time.Time
+}
+
+// NewManualClock creates a clock stopped at now.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now.
+func (c *ManualClock) Set(now time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = now
+}
+
+// Advance moves the clock forward by the duration.
+func (c *ManualClock) Advance(duration time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = c.now.Add(duration)
+}
@@ -0,0 +1,131 @@
+package entity
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// MaxScale is the largest number of decimal places a Decimal can have, so one unit still fits in it.
+const MaxScale = 19
+
+// Decimal is a non-negative fixed-point number, kept as the number of units of its scale.
+// The scale is given by the instrument, so 10125 is 101.25 for an instrument with scale 2 and 10125 for one with
+// scale 0.
+type Decimal uint64
+
+// ParseDecimal reads a decimal number with at most scale decimal places, like 101.25, exactly.
+// Negative numbers, more decimal places than the scale and values that do not fit are errors.
+func ParseDecimal(text string, scale uint8) (Decimal, error) {
+	if scale > MaxScale {
+		return 0, fmt.Errorf("invalid scale: %v", scale)
+	}
+	integer, fraction, hasPoint := strings.Cut(text, ".")
+	if len(integer) == 0 && len(fraction) == 0 {
+		return 0, fmt.Errorf("invalid decimal: %q", text)
+	}
+	if hasPoint && len(fraction) == 0 {
+		return 0, fmt.Errorf("invalid decimal: %q", text)
+	}
+	if len(fraction) > int(scale) {
+		return 0, fmt.Errorf("decimal %q has more than %v decimal places", text, scale)
+	}
+	for _, part := range []string{integer, fraction} {
+		for _, digit := range part {
+			if digit < '0' || digit > '9' {
+				return 0, fmt.Errorf("invalid decimal: %q", text)
+			}
+		}
+	}
+
+	// The digits are read as a single integer, padding the fraction up to the scale.
+	digits := strings.TrimLeft(integer+fraction+strings.Repeat("0", int(scale)-len(fraction)), "0")
+	if len(digits) == 0 {
+		return 0, nil
+	}
+	units, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("decimal %q does not fit in scale %v", text, scale)
+	}
+	return Decimal(units), nil
+}
+
+// unit gives the number of units in one, for the scale.
+func unit(scale uint8) uint64 {
+	resp := uint64(1)
+	for i := uint8(0); i < scale; i++ {
+		resp *= 10
+	}
+	return resp
+}
+
+// Format prints the number with exactly scale decimal places.
+func (d Decimal) Format(scale uint8) string {
+	if scale == 0 || scale > MaxScale {
+		return strconv.FormatUint(uint64(d), 10)
+	}
+	one := unit(scale)
+	return fmt.Sprintf("%d.%0*d", uint64(d)/one, int(scale), uint64(d)%one)
+}
+
+// Decimal128 is a non-negative fixed-point number of 128 bits, large enough for the product of any two Decimals.
+type Decimal128 struct {
+	Hi uint64
+	Lo uint64
+}
+
+// Notional gives the value of the amount at the price, with the scale of the prices plus the one of the quantities.
+// It is computed in 128 bits, so it always fits, even with the scales of the crypto currencies.
+func Notional(price, amount Decimal) Decimal128 {
+	hi, lo := bits.Mul64(uint64(price), uint64(amount))
+	return Decimal128{Hi: hi, Lo: lo}
+}
+
+// Format prints the number with exactly scale decimal places.
+func (d Decimal128) Format(scale uint8) string {
+	value := new(big.Int).Lsh(new(big.Int).SetUint64(d.Hi), 64)
+	text := value.Or(value, new(big.Int).SetUint64(d.Lo)).String()
+	if scale == 0 {
+		return text
+	}
+	if len(text) <= int(scale) {
+		text = strings.Repeat("0", int(scale)-len(text)+1) + text
+	}
+	return text[:len(text)-int(scale)] + "." + text[len(text)-int(scale):]
+}
+
+func (d Decimal128) String() string {
+	return d.Format(0)
+}
+
+// Scale gives the number of decimal places of the prices and the quantities of an instrument.
+type Scale struct {
+	Price    uint8
+	Quantity uint8
+}
+
+// ParsePrice reads a price with the scale of the prices.
+func (s Scale) ParsePrice(text string) (Decimal, error) {
+	return ParseDecimal(text, s.Price)
+}
+
+// ParseQuantity reads a quantity with the scale of the quantities.
+func (s Scale) ParseQuantity(text string) (Decimal, error) {
+	return ParseDecimal(text, s.Quantity)
+}
+
+// FormatPrice prints a price with the precision of the prices.
+func (s Scale) FormatPrice(price Decimal) string {
+	return price.Format(s.Price)
+}
+
+// FormatQuantity prints a quantity with the precision of the quantities.
+func (s Scale) FormatQuantity(quantity Decimal) string {
+	return quantity.Format(s.Quantity)
+}
+
+// MaxDecimal is the largest value a Decimal can have.
+const MaxDecimal = Decimal(math.MaxUint64)
@@ -0,0 +1,104 @@
+package entity
+
+import (
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		text    string
+		scale   uint8
+		want    Decimal
+		wantErr bool
+	}{
+		{text: "101.25", scale: 2, want: 10125},
+		{text: "101.25", scale: 4, want: 1012500},
+		{text: "101", scale: 2, want: 10100},
+		{text: "0.5", scale: 1, want: 5},
+		{text: ".5", scale: 1, want: 5},
+		{text: "007", scale: 0, want: 7},
+		{text: "0", scale: 8, want: 0},
+		{text: "18446744073709551615", scale: 0, want: MaxDecimal},
+		{text: "1844674407370955161.5", scale: 1, want: MaxDecimal},
+		{text: "101.255", scale: 2, wantErr: true},
+		{text: "101.25", scale: 0, wantErr: true},
+		{text: "-1", scale: 0, wantErr: true},
+		{text: "-0.5", scale: 1, wantErr: true},
+		{text: "1e3", scale: 0, wantErr: true},
+		{text: "", scale: 0, wantErr: true},
+		{text: ".", scale: 2, wantErr: true},
+		{text: "1.", scale: 2, wantErr: true},
+		{text: "18446744073709551616", scale: 0, wantErr: true},
+		{text: "1844674407370955161.6", scale: 1, wantErr: true},
+		{text: "1", scale: MaxScale + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.text, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseDecimal(tt.text, tt.scale)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDecimal(%q, %v) error = %v, wantErr %v", tt.text, tt.scale, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDecimal(%q, %v) = %v, want %v", tt.text, tt.scale, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecimal_Format(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		decimal Decimal
+		scale   uint8
+		want    string
+	}{
+		{decimal: 10125, scale: 2, want: "101.25"},
+		{decimal: 10125, scale: 0, want: "10125"},
+		{decimal: 5, scale: 3, want: "0.005"},
+		{decimal: 10100, scale: 2, want: "101.00"},
+		{decimal: 0, scale: 2, want: "0.00"},
+		{decimal: MaxDecimal, scale: 0, want: "18446744073709551615"},
+		{decimal: MaxDecimal, scale: MaxScale, want: "1.8446744073709551615"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.want, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.decimal.Format(tt.scale); got != tt.want {
+				t.Errorf("Format(%v) = %v, want %v", tt.scale, got, tt.want)
+			}
+			if parsed, err := ParseDecimal(tt.want, tt.scale); err != nil || parsed != tt.decimal {
+				t.Errorf("ParseDecimal(%q, %v) = %v, %v, want %v", tt.want, tt.scale, parsed, err, tt.decimal)
+			}
+		})
+	}
+}
+
+func TestDecimal128_Format(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		value Decimal128
+		scale uint8
+		want  string
+	}{
+		{name: "zero", want: "0"},
+		{name: "integer", value: Decimal128{Lo: 10125}, want: "10125"},
+		{name: "decimal places", value: Decimal128{Lo: 10125}, scale: 2, want: "101.25"},
+		{name: "below one", value: Decimal128{Lo: 5}, scale: 3, want: "0.005"},
+		{name: "above 64 bits", value: Decimal128{Hi: 1}, want: "18446744073709551616"},
+		{name: "above 64 bits with decimal places", value: Decimal128{Hi: 1}, scale: 16, want: "1844.6744073709551616"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.value.Format(tt.scale); got != tt.want {
+				t.Errorf("Format(%v) = %v, want %v", tt.scale, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package entity
+
+// Instrument is the reference data of a symbol, used to validate its orders.
+// The zero values do not constrain the orders.
+type Instrument struct {
+	Symbol string
+	// Scale is the number of decimal places of the prices and the quantities, the other values are given with it.
+	Scale Scale
+	// TickSize is the smallest price change, the prices must be a multiple of it.
+	TickSize Decimal
+	// LotSize is the smallest amount change, the amounts must be a multiple of it.
+	LotSize Decimal
+	// MinQuantity and MaxQuantity limit the amount of an order.
+	MinQuantity Decimal
+	MaxQuantity Decimal
+	// MinPrice and MaxPrice limit the price of the limit orders.
+	MinPrice Decimal
+	MaxPrice Decimal
+	// ReferencePrice is the price the static band of the symbol is around, the last trade price is used without one.
+	ReferencePrice Decimal
+}
+
+// OnTick checks the price is a multiple of the tick size.
+func (i Instrument) OnTick(price Decimal) bool {
+	return i.TickSize == 0 || price%i.TickSize == 0
+}
+
+// OnLot checks the amount is a multiple of the lot size.
+func (i Instrument) OnLot(amount Decimal) bool {
+	return i.LotSize == 0 || amount%i.LotSize == 0
+}
+
+// QuantityAllowed checks the amount is between the minimum and the maximum quantity.
+func (i Instrument) QuantityAllowed(amount Decimal) bool {
+	return amount >= i.MinQuantity && (i.MaxQuantity == 0 || amount <= i.MaxQuantity)
+}
+
+// PriceAllowed checks the price is between the price limits.
+func (i Instrument) PriceAllowed(price Decimal) bool {
+	return price >= i.MinPrice && (i.MaxPrice == 0 || price <= i.MaxPrice)
+}
+
+// Instruments is the registry of the instruments by their symbol.
+type Instruments map[string]Instrument
@@ -42,48 +42,216 @@ func (s Side) String() string {
 	}
 }
 
+// OrderType defines how the price of the order is used on the matching.
+type OrderType uint8
+
+const (
+	// Limit orders only match at their price or better, and the remaining sits in the book.
+	Limit OrderType = iota
+	// Market orders match at any price and never sit in the book.
+	Market OrderType = iota
+)
+
+func (t OrderType) String() string {
+	switch t {
+	case Limit:
+		return "limit"
+	case Market:
+		return "market"
+	default:
+		return fmt.Sprintf("invalid order type (%v)", uint8(t))
+	}
+}
+
+// TimeInForce defines how long the order stays in the book.
+type TimeInForce uint8
+
+const (
+	// GoodTillCancel orders sit in the book until they are filled or cancelled, it is the default.
+	GoodTillCancel TimeInForce = iota
+	// ImmediateOrCancel orders fill what they can and the remaining is cancelled.
+	ImmediateOrCancel TimeInForce = iota
+	// FillOrKill orders are fully filled or they do not trade at all.
+	FillOrKill TimeInForce = iota
+	// GoodTillTime orders sit in the book until they are filled, cancelled or the clock reaches their ExpireAt.
+	GoodTillTime TimeInForce = iota
+)
+
+func (t TimeInForce) String() string {
+	switch t {
+	case GoodTillCancel:
+		return "GTC"
+	case ImmediateOrCancel:
+		return "IOC"
+	case FillOrKill:
+		return "FOK"
+	case GoodTillTime:
+		return "GTT"
+	default:
+		return fmt.Sprintf("invalid time in force (%v)", uint8(t))
+	}
+}
+
+// ParseTimeInForce gives the TimeInForce for its name.
+func ParseTimeInForce(name string) (TimeInForce, error) {
+	for _, timeInForce := range []TimeInForce{GoodTillCancel, ImmediateOrCancel, FillOrKill, GoodTillTime} {
+		if timeInForce.String() == name {
+			return timeInForce, nil
+		}
+	}
+	return GoodTillCancel, fmt.Errorf("invalid time in force: %v", name)
+}
+
+// PostOnly defines what happens to an order that would take liquidity when it arrives.
+type PostOnly uint8
+
+const (
+	// TakeLiquidity orders are matched on arrival, it is the default.
+	TakeLiquidity PostOnly = iota
+	// PostOnlyReject orders are rejected when they would match on arrival.
+	PostOnlyReject PostOnly = iota
+	// PostOnlyReprice orders are moved one tick away from the opposite top of the book when they would match.
+	PostOnlyReprice PostOnly = iota
+)
+
+func (p PostOnly) String() string {
+	switch p {
+	case TakeLiquidity:
+		return "take liquidity"
+	case PostOnlyReject:
+		return "post only reject"
+	case PostOnlyReprice:
+		return "post only reprice"
+	default:
+		return fmt.Sprintf("invalid post only (%v)", uint8(p))
+	}
+}
+
+// SelfTradePrevention defines what happens when an order would match another one of the same user.
+type SelfTradePrevention uint8
+
+const (
+	// SelfTradeDefault uses the self-trade prevention of the engine, which allows self-trades unless configured.
+	SelfTradeDefault SelfTradePrevention = iota
+	// SelfTradeAllow matches the orders of the same user like any other.
+	SelfTradeAllow SelfTradePrevention = iota
+	// CancelNewest cancels the remaining of the incoming order.
+	CancelNewest SelfTradePrevention = iota
+	// CancelOldest cancels the resting order and keeps matching the incoming one.
+	CancelOldest SelfTradePrevention = iota
+	// CancelBoth cancels the resting order and the remaining of the incoming one.
+	CancelBoth SelfTradePrevention = iota
+	// DecrementAndCancel reduces both orders by the smallest amount, cancelling the one that gets nothing left.
+	DecrementAndCancel SelfTradePrevention = iota
+)
+
+func (s SelfTradePrevention) String() string {
+	switch s {
+	case SelfTradeDefault:
+		return "STP_DEFAULT"
+	case SelfTradeAllow:
+		return "STP_ALLOW"
+	case CancelNewest:
+		return "STP_CANCEL_NEWEST"
+	case CancelOldest:
+		return "STP_CANCEL_OLDEST"
+	case CancelBoth:
+		return "STP_CANCEL_BOTH"
+	case DecrementAndCancel:
+		return "STP_DECREMENT"
+	default:
+		return fmt.Sprintf("invalid self-trade prevention (%v)", uint8(s))
+	}
+}
+
+// ParseSelfTradePrevention gives the SelfTradePrevention for its name.
+func ParseSelfTradePrevention(name string) (SelfTradePrevention, error) {
+	for _, selfTrade := range []SelfTradePrevention{
+		SelfTradeDefault, SelfTradeAllow, CancelNewest, CancelOldest, CancelBoth, DecrementAndCancel,
+	} {
+		if selfTrade.String() == name {
+			return selfTrade, nil
+		}
+	}
+	return SelfTradeDefault, fmt.Errorf("invalid self-trade prevention: %v", name)
+}
+
 // OrderID represents the type used of orders identification.
+// It is chosen by the user, so different users can have orders with the same id.
 type OrderID uint64
 
 // UserID represents the type used of users identification.
 type UserID uint64
 
+// OrderKey identifies an order by the user that placed it and the id given by the user.
+type OrderKey struct {
+	User UserID
+	ID   OrderID
+}
+
+// ExchangeOrderID is the unique id given by the engine to each order it receives.
+type ExchangeOrderID uint64
+
 // Order represents each order placed.
 type Order struct {
 	// Amount is how much the client wants to buy.
-	Amount uint64
+	Amount Decimal
 	// Price is how much the client is willing to pay.
-	Price uint64
-	// ID is the identification of the order.
+	Price Decimal
+	// ID is the identification of the order, unique for the user.
 	ID OrderID
+	// ExchangeID is given by the engine when the order is accepted, rejected orders have none.
+	ExchangeID ExchangeOrderID
 	// Side of the book it will sit.
 	Side Side
 	// User identifies the user that placed the order.
 	User UserID
+	// Symbol is the instrument being traded.
+	Symbol string
+	// Type of the order, the zero value is a limit order.
+	Type OrderType
+	// TimeInForce of the order, the zero value is good till cancel.
+	TimeInForce TimeInForce
+	// PostOnly tells if the order can take liquidity, the zero value allows it.
+	PostOnly PostOnly
+	// SelfTrade is used when the order arrives and would match one of the same user, the zero value uses the engine
+	// configuration.
+	SelfTrade SelfTradePrevention
+	// DisplayAmount is the size of the visible slice of an iceberg order, zero shows the whole amount.
+	// Once the order sits in the book Amount is the visible slice.
+	DisplayAmount Decimal
+	// HiddenAmount is the reserve of an iceberg order used to refill the visible slice.
+	HiddenAmount Decimal
+	// StopPrice makes the order wait outside the book until a trade reaches it, zero sends the order right away.
+	// Buy stops are triggered by trades at or above it, and sell stops by trades at or below it.
+	StopPrice Decimal
+	// ExpireAt is when a good till time order leaves the book.
+	ExpireAt time.Time
 	// Timestamp for when the order was generated.
 	Timestamp time.Time
 }
 
-// Less checks if the current order should appear before the other one in the book.
-func (o *Order) Less(other *Order) bool {
-	if o.Side != other.Side {
-		panic(fmt.Sprintf("cannot compare orders of different sides"))
+// Key gives the identification of the order across users.
+func (o *Order) Key() OrderKey {
+	return OrderKey{
+		User: o.User,
+		ID:   o.ID,
 	}
-	switch o.Side {
-	case Buy:
-		return o.Price < other.Price || (o.Price == other.Price && o.Timestamp.Before(other.Timestamp))
-	case Sell:
-		return o.Price > other.Price || (o.Price == other.Price && o.Timestamp.Before(other.Timestamp))
-	case InvalidSide:
-		panic(fmt.Sprintf("invalid side: %v", o.Side))
-	default:
-		panic(fmt.Sprintf("invalid side: %v", o.Side))
+}
+
+// Notional gives the value of the whole order, including the hidden amount, at its price.
+// It is false when the amount with the hidden one does not fit in a Decimal.
+func (o *Order) Notional() (Decimal128, bool) {
+	amount := o.Amount + o.HiddenAmount
+	if amount < o.Amount {
+		return Decimal128{}, false
 	}
+	return Notional(o.Price, amount), true
 }
 
 // Match process the matching between two orders.
-// It returns the remaining order in case there is something left and the generated trade.
-func (o *Order) Match(other *Order) (*Order, *Trade) {
+// It returns the remaining order in case there is something left and the generated trade, timestamped by the clock.
+func (o *Order) Match(other *Order, clock Clock) (*Order, *Trade) {
 	if o == nil || other == nil || o.Side.Opposite() != other.Side {
 		return nil, nil
 	}
@@ -102,58 +270,66 @@ func (o *Order) Match(other *Order) (*Order, *Trade) {
 		sellUserID = o.User
 		sellOrderID = o.ID
 	}
+	buyExchangeID := aOrder.ExchangeID
+	sellExchangeID := bOrder.ExchangeID
+
+	// Market orders take the price of the order they match against.
+	price := bOrder.Price
+	if bOrder.Type == Market {
+		price = aOrder.Price
+	}
 
-	if aOrder.Price >= bOrder.Price {
+	if aOrder.Type == Market || bOrder.Type == Market || aOrder.Price >= bOrder.Price {
+		now := clock.Now()
 		if aOrder.Amount == bOrder.Amount {
 			return nil, &Trade{
-				TakeOrderID:  o.ID,
-				MakerOrderID: other.ID,
-				Amount:       aOrder.Amount,
-				Price:        bOrder.Price,
-				Timestamp:    time.Now(),
-				BuyUserID:    buyUserID,
-				SellUserID:   sellUserID,
-				BuyOrderID:   buyOrderID,
-				SellOrderID:  sellOrderID,
+				TakeOrderID:    o.ID,
+				MakerOrderID:   other.ID,
+				Amount:         aOrder.Amount,
+				Price:          price,
+				Notional:       Notional(price, aOrder.Amount),
+				Timestamp:      now,
+				BuyUserID:      buyUserID,
+				SellUserID:     sellUserID,
+				BuyOrderID:     buyOrderID,
+				SellOrderID:    sellOrderID,
+				BuyExchangeID:  buyExchangeID,
+				SellExchangeID: sellExchangeID,
 			}
 		} else if aOrder.Amount > bOrder.Amount {
-			return &Order{
-					Amount:    aOrder.Amount - bOrder.Amount,
-					Price:     aOrder.Price,
-					ID:        aOrder.ID,
-					Side:      aOrder.Side,
-					User:      aOrder.User,
-					Timestamp: aOrder.Timestamp,
-				}, &Trade{
-					TakeOrderID:  o.ID,
-					MakerOrderID: other.ID,
-					Amount:       bOrder.Amount,
-					Price:        bOrder.Price,
-					Timestamp:    time.Now(),
-					BuyUserID:    buyUserID,
-					SellUserID:   sellUserID,
-					BuyOrderID:   buyOrderID,
-					SellOrderID:  sellOrderID,
-				}
+			remaining := *aOrder
+			remaining.Amount = aOrder.Amount - bOrder.Amount
+			return &remaining, &Trade{
+				TakeOrderID:    o.ID,
+				MakerOrderID:   other.ID,
+				Amount:         bOrder.Amount,
+				Price:          price,
+				Notional:       Notional(price, bOrder.Amount),
+				Timestamp:      now,
+				BuyUserID:      buyUserID,
+				SellUserID:     sellUserID,
+				BuyOrderID:     buyOrderID,
+				SellOrderID:    sellOrderID,
+				BuyExchangeID:  buyExchangeID,
+				SellExchangeID: sellExchangeID,
+			}
 		} else {
-			return &Order{
-					Amount:    bOrder.Amount - aOrder.Amount,
-					Price:     bOrder.Price,
-					ID:        bOrder.ID,
-					Side:      bOrder.Side,
-					User:      bOrder.User,
-					Timestamp: bOrder.Timestamp,
-				}, &Trade{
-					TakeOrderID:  o.ID,
-					MakerOrderID: other.ID,
-					Amount:       aOrder.Amount,
-					Price:        bOrder.Price,
-					Timestamp:    time.Now(),
-					BuyUserID:    buyUserID,
-					SellUserID:   sellUserID,
-					BuyOrderID:   buyOrderID,
-					SellOrderID:  sellOrderID,
-				}
+			remaining := *bOrder
+			remaining.Amount = bOrder.Amount - aOrder.Amount
+			return &remaining, &Trade{
+				TakeOrderID:    o.ID,
+				MakerOrderID:   other.ID,
+				Amount:         aOrder.Amount,
+				Price:          price,
+				Notional:       Notional(price, aOrder.Amount),
+				Timestamp:      now,
+				BuyUserID:      buyUserID,
+				SellUserID:     sellUserID,
+				BuyOrderID:     buyOrderID,
+				SellOrderID:    sellOrderID,
+				BuyExchangeID:  buyExchangeID,
+				SellExchangeID: sellExchangeID,
+			}
 		}
 	}
 	return nil, nil
@@ -1,164 +1,12 @@
 package entity
 
 import (
+	"math"
 	"reflect"
 	"testing"
 	"time"
 )
 
-func TestOrder_Less(t *testing.T) {
-	t.Parallel()
-	type args struct {
-		other *Order
-	}
-	time1 := time.Now()
-	time2 := time1.Add(time.Second)
-	tests := []struct {
-		name  string
-		order *Order
-		args  args
-		want  bool
-	}{
-		{
-			name: "buy (10, time1), (20, time2)",
-			order: &Order{
-				Price:     10,
-				Timestamp: time1,
-				Side:      Buy,
-			},
-			args: args{
-				other: &Order{
-					Price:     20,
-					Timestamp: time2,
-					Side:      Buy,
-				},
-			},
-			want: true,
-		},
-		{
-			name: "buy (10, time1), (2, time2)",
-			order: &Order{
-				Price:     10,
-				Timestamp: time1,
-				Side:      Buy,
-			},
-			args: args{
-				other: &Order{
-					Price:     2,
-					Timestamp: time2,
-					Side:      Buy,
-				},
-			},
-			want: false,
-		},
-		{
-			name: "buy (10, time1), (10, time2)",
-			order: &Order{
-				Price:     10,
-				Timestamp: time1,
-				Side:      Buy,
-			},
-			args: args{
-				other: &Order{
-					Price:     10,
-					Timestamp: time2,
-					Side:      Buy,
-				},
-			},
-			want: true,
-		},
-		{
-			name: "buy (10, time2), (10, time1)",
-			order: &Order{
-				Price:     10,
-				Timestamp: time2,
-				Side:      Buy,
-			},
-			args: args{
-				other: &Order{
-					Price:     10,
-					Timestamp: time1,
-					Side:      Buy,
-				},
-			},
-			want: false,
-		},
-		{
-			name: "sell (10, time1), (20, time2)",
-			order: &Order{
-				Price:     10,
-				Timestamp: time1,
-				Side:      Sell,
-			},
-			args: args{
-				other: &Order{
-					Price:     20,
-					Timestamp: time2,
-					Side:      Sell,
-				},
-			},
-			want: false,
-		},
-		{
-			name: "sell (10, time1), (2, time2)",
-			order: &Order{
-				Price:     10,
-				Timestamp: time1,
-				Side:      Sell,
-			},
-			args: args{
-				other: &Order{
-					Price:     2,
-					Timestamp: time2,
-					Side:      Sell,
-				},
-			},
-			want: true,
-		},
-		{
-			name: "sell (10, time1), (10, time2)",
-			order: &Order{
-				Price:     10,
-				Timestamp: time1,
-				Side:      Sell,
-			},
-			args: args{
-				other: &Order{
-					Price:     10,
-					Timestamp: time2,
-					Side:      Sell,
-				},
-			},
-			want: true,
-		},
-		{
-			name: "sell (10, time2), (10, time1)",
-			order: &Order{
-				Price:     10,
-				Timestamp: time2,
-				Side:      Sell,
-			},
-			args: args{
-				other: &Order{
-					Price:     10,
-					Timestamp: time1,
-					Side:      Sell,
-				},
-			},
-			want: false,
-		},
-	}
-	for _, tt := range tests {
-		tt := tt
-		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
-			if got := tt.order.Less(tt.args.other); got != tt.want {
-				t.Errorf("Less() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func TestOrder_Match(t *testing.T) {
 	t.Parallel()
 	time1 := time.Now()
@@ -229,6 +77,7 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 2,
 				Amount:       10,
 				Price:        10,
+				Notional:     Decimal128{Lo: 100},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -267,6 +116,7 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 2,
 				Amount:       9,
 				Price:        10,
+				Notional:     Decimal128{Lo: 90},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -306,6 +156,7 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 2,
 				Amount:       10,
 				Price:        10,
+				Notional:     Decimal128{Lo: 100},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -335,6 +186,7 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 2,
 				Amount:       10,
 				Price:        10,
+				Notional:     Decimal128{Lo: 100},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -373,6 +225,7 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 2,
 				Amount:       9,
 				Price:        10,
+				Notional:     Decimal128{Lo: 90},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -412,6 +265,7 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 2,
 				Amount:       10,
 				Price:        10,
+				Notional:     Decimal128{Lo: 100},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -460,6 +314,7 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 1,
 				Amount:       10,
 				Price:        10,
+				Notional:     Decimal128{Lo: 100},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -498,6 +353,7 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 1,
 				Amount:       9,
 				Price:        10,
+				Notional:     Decimal128{Lo: 90},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -537,6 +393,7 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 1,
 				Amount:       10,
 				Price:        10,
+				Notional:     Decimal128{Lo: 100},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -566,6 +423,7 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 1,
 				Amount:       10,
 				Price:        10,
+				Notional:     Decimal128{Lo: 100},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -604,6 +462,7 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 1,
 				Amount:       9,
 				Price:        10,
+				Notional:     Decimal128{Lo: 90},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -643,6 +502,77 @@ func TestOrder_Match(t *testing.T) {
 				MakerOrderID: 1,
 				Amount:       10,
 				Price:        10,
+				Notional:     Decimal128{Lo: 100},
+				BuyUserID:    1,
+				BuyOrderID:   1,
+				SellUserID:   2,
+				SellOrderID:  2,
+			},
+		},
+		{
+			name: "market buy (0, 10) sell (10, 10) trade at the sell price",
+			order: &Order{
+				Side:   Buy,
+				Amount: 10,
+				ID:     1,
+				User:   1,
+				Type:   Market,
+			},
+			args: args{
+				other: &Order{
+					Side:   Sell,
+					Price:  10,
+					Amount: 10,
+					ID:     2,
+					User:   2,
+				},
+			},
+			wantTrade: &Trade{
+				TakeOrderID:  1,
+				MakerOrderID: 2,
+				Amount:       10,
+				Price:        10,
+				Notional:     Decimal128{Lo: 100},
+				BuyUserID:    1,
+				BuyOrderID:   1,
+				SellUserID:   2,
+				SellOrderID:  2,
+			},
+		},
+		{
+			name: "market sell (0, 10) buy (20, 5) order larger than book, trade at the buy price",
+			order: &Order{
+				Side:      Sell,
+				Amount:    10,
+				ID:        2,
+				User:      2,
+				Type:      Market,
+				Timestamp: time2,
+			},
+			args: args{
+				other: &Order{
+					Side:      Buy,
+					Price:     20,
+					Amount:    5,
+					ID:        1,
+					User:      1,
+					Timestamp: time1,
+				},
+			},
+			wantOrder: &Order{
+				Amount:    5,
+				ID:        2,
+				Side:      Sell,
+				User:      2,
+				Type:      Market,
+				Timestamp: time2,
+			},
+			wantTrade: &Trade{
+				TakeOrderID:  2,
+				MakerOrderID: 1,
+				Amount:       5,
+				Price:        20,
+				Notional:     Decimal128{Lo: 100},
 				BuyUserID:    1,
 				BuyOrderID:   1,
 				SellUserID:   2,
@@ -654,10 +584,10 @@ func TestOrder_Match(t *testing.T) {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			gotOrder, gotTrade := tt.order.Match(tt.args.other)
-			if gotTrade != nil && tt.wantTrade != nil {
-				// Hardcoding the timestamp
-				tt.wantTrade.Timestamp = gotTrade.Timestamp
+			clock := NewManualClock(time2.Add(time.Second))
+			gotOrder, gotTrade := tt.order.Match(tt.args.other, clock)
+			if tt.wantTrade != nil {
+				tt.wantTrade.Timestamp = clock.Now()
 			}
 			if !reflect.DeepEqual(gotOrder, tt.wantOrder) {
 				t.Errorf("Match() gotOrder = %+v, want %+v", gotOrder, tt.wantOrder)
@@ -669,6 +599,81 @@ func TestOrder_Match(t *testing.T) {
 	}
 }
 
+func TestOrder_Notional(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		order  Order
+		want   Decimal128
+		wantOk bool
+	}{
+		{
+			name:   "market order",
+			order:  Order{Amount: MaxDecimal, Type: Market},
+			wantOk: true,
+		},
+		{
+			name:   "small order",
+			order:  Order{Amount: 10, Price: 10125},
+			want:   Decimal128{Lo: 101250},
+			wantOk: true,
+		},
+		{
+			name:   "largest notional in 64 bits",
+			order:  Order{Amount: 1<<32 + 1, Price: 1<<32 - 1},
+			want:   Decimal128{Lo: math.MaxUint64},
+			wantOk: true,
+		},
+		{
+			name:   "notional above 64 bits",
+			order:  Order{Amount: 1 << 32, Price: 1 << 32},
+			want:   Decimal128{Hi: 1},
+			wantOk: true,
+		},
+		{
+			name:   "largest price and amount",
+			order:  Order{Amount: MaxDecimal, Price: MaxDecimal},
+			want:   Decimal128{Hi: math.MaxUint64 - 1, Lo: 1},
+			wantOk: true,
+		},
+		{
+			name:   "hidden amount counts",
+			order:  Order{Amount: 1 << 31, HiddenAmount: 1 << 31, Price: 1 << 32},
+			want:   Decimal128{Hi: 1},
+			wantOk: true,
+		},
+		{
+			name:  "amount with the hidden one does not fit",
+			order: Order{Amount: MaxDecimal, HiddenAmount: 1, Price: 1},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := tt.order.Notional()
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("Notional() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestOrder_Match_notional(t *testing.T) {
+	t.Parallel()
+	clock := NewManualClock(time.UnixMilli(1))
+	// A price of 100000.00000000 and an amount of 1000.00000000 with the scales of the crypto currencies.
+	buy := &Order{Side: Buy, Price: 100_000_00000000, Amount: 1_000_00000000, ID: 1, User: 1}
+	sell := &Order{Side: Sell, Price: 100_000_00000000, Amount: 1_000_00000000, ID: 2, User: 2}
+	_, trade := buy.Match(sell, clock)
+	if trade == nil {
+		t.Fatalf("Match() gotTrade = nil")
+	}
+	if want := "100000000.0000000000000000"; trade.Notional.Format(16) != want {
+		t.Errorf("Match() notional = %v, want %v", trade.Notional.Format(16), want)
+	}
+}
+
 func TestSide_Opposite(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -0,0 +1,58 @@
+package entity
+
+import (
+	"fmt"
+)
+
+// SessionState defines what a book accepts and if the orders are matched.
+type SessionState uint8
+
+const (
+	// Continuous books match the orders as they arrive, it is the default.
+	Continuous SessionState = iota
+	// PreOpen books collect orders without matching them, and do not publish the indicative uncrossing.
+	PreOpen SessionState = iota
+	// Auction books collect orders without matching them for a call auction, publishing the indicative uncrossing.
+	Auction SessionState = iota
+	// Halted books only accept cancels until trading resumes.
+	Halted SessionState = iota
+	// Closed books do not accept new orders or replaces, the resting orders can still be cancelled.
+	Closed SessionState = iota
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case Continuous:
+		return "CONTINUOUS"
+	case PreOpen:
+		return "PRE_OPEN"
+	case Auction:
+		return "AUCTION"
+	case Halted:
+		return "HALTED"
+	case Closed:
+		return "CLOSED"
+	default:
+		return fmt.Sprintf("invalid session state (%v)", uint8(s))
+	}
+}
+
+// ParseSessionState gives the SessionState for its name.
+func ParseSessionState(name string) (SessionState, error) {
+	for _, session := range []SessionState{Continuous, PreOpen, Auction, Halted, Closed} {
+		if session.String() == name {
+			return session, nil
+		}
+	}
+	return Continuous, fmt.Errorf("invalid session state: %v", name)
+}
+
+// Collecting tells the orders are kept without matching, so the book can cross until the uncross.
+func (s SessionState) Collecting() bool {
+	return s == PreOpen || s == Auction
+}
+
+// AcceptsOrders tells new orders and replaces are accepted, cancels are accepted in every state.
+func (s SessionState) AcceptsOrders() bool {
+	return s != Halted && s != Closed
+}
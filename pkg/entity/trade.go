@@ -9,13 +9,19 @@ type Trade struct {
 	// MakerOrderID is the order already on the book.
 	MakerOrderID OrderID
 	// Amount is the size of the trade.
-	Amount uint64
+	Amount Decimal
 	// Price is how much the client paid for the trade.
-	Price uint64
+	Price Decimal
+	// Notional is the value of the trade, Price times Amount, with the scale of the prices plus the one of the
+	// quantities.
+	Notional Decimal128
 	// Timestamp is the moment the trade was created.
 	Timestamp   time.Time
 	BuyUserID   UserID
 	BuyOrderID  OrderID
 	SellUserID  UserID
 	SellOrderID OrderID
+	// BuyExchangeID and SellExchangeID are the ids given by the engine to the orders.
+	BuyExchangeID  ExchangeOrderID
+	SellExchangeID ExchangeOrderID
 }
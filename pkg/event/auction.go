@@ -0,0 +1,53 @@
+package event
+
+import (
+	"fmt"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+)
+
+// AuctionIndicative is emitted while a call auction is open, every time the book changes the price it would uncross
+// at.
+type AuctionIndicative struct {
+	Header
+	Symbol string
+	// Price the auction would uncross at now, zero when the book does not cross.
+	Price entity.Decimal
+	// Volume that would be traded at the price.
+	Volume entity.Decimal
+	// Scale is used to print the price and the volume with the precision of the instrument.
+	Scale entity.Scale
+}
+
+func (ai *AuctionIndicative) BookSymbol() string {
+	return ai.Symbol
+}
+
+func (ai *AuctionIndicative) Output() string {
+	if ai == nil {
+		return ""
+	}
+	if ai.Volume == 0 {
+		return "I, -, -"
+	}
+	return fmt.Sprintf("I, %v, %v", ai.Scale.FormatPrice(ai.Price), ai.Scale.FormatQuantity(ai.Volume))
+}
+
+// AuctionUncrossed is emitted when a call auction ends, after the trades at the uncrossing price.
+type AuctionUncrossed struct {
+	Header
+	Symbol string
+	// Price all the trades of the auction were made at, zero when the book did not cross.
+	Price entity.Decimal
+	// Volume traded by the auction.
+	Volume entity.Decimal
+	Scale  entity.Scale
+}
+
+func (au *AuctionUncrossed) BookSymbol() string {
+	return au.Symbol
+}
+
+func (au *AuctionUncrossed) Output() string {
+	return ""
+}
@@ -8,10 +8,17 @@ import (
 )
 
 type TopOfBookChange struct {
-	Event
+	Header
+	Symbol        string
 	Side          entity.Side
-	Price         uint64
-	TotalQuantity uint64
+	Price         entity.Decimal
+	TotalQuantity entity.Decimal
+	// Scale is used to print the price and the quantity with the precision of the instrument.
+	Scale entity.Scale
+}
+
+func (t *TopOfBookChange) BookSymbol() string {
+	return t.Symbol
 }
 
 func (t *TopOfBookChange) Output() string {
@@ -22,6 +29,8 @@ func (t *TopOfBookChange) Output() string {
 	if t.TotalQuantity == 0 {
 		return fmt.Sprintf("B, %v, -, -", sideUpper[0:1])
 	} else {
-		return fmt.Sprintf("B, %v, %v, %v", sideUpper[0:1], t.Price, t.TotalQuantity)
+		return fmt.Sprintf(
+			"B, %v, %v, %v", sideUpper[0:1], t.Scale.FormatPrice(t.Price), t.Scale.FormatQuantity(t.TotalQuantity),
+		)
 	}
 }
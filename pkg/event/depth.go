@@ -0,0 +1,54 @@
+package event
+
+import (
+	"fmt"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+)
+
+// DepthAction tells how a price level of the book changed.
+type DepthAction uint8
+
+const (
+	// DepthNew is used for a price level that was not in the book.
+	DepthNew DepthAction = iota
+	// DepthChange is used when the amount of a price level changed.
+	DepthChange DepthAction = iota
+	// DepthDelete is used when the last order of a price level left the book.
+	DepthDelete DepthAction = iota
+)
+
+func (a DepthAction) String() string {
+	switch a {
+	case DepthNew:
+		return "new"
+	case DepthChange:
+		return "change"
+	case DepthDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("invalid depth action (%v)", uint8(a))
+	}
+}
+
+// DepthUpdate is emitted for every price level changed by a request, on both sides of the book.
+type DepthUpdate struct {
+	Header
+	Symbol string
+	Side   entity.Side
+	Action DepthAction
+	Price  entity.Decimal
+	// TotalQuantity is the amount of the level after the change, zero when it was deleted.
+	// Only the visible slice of iceberg orders is counted.
+	TotalQuantity entity.Decimal
+	// Scale is used to print the price and the quantity with the precision of the instrument.
+	Scale entity.Scale
+}
+
+func (d *DepthUpdate) BookSymbol() string {
+	return d.Symbol
+}
+
+func (d *DepthUpdate) Output() string {
+	return ""
+}
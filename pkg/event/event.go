@@ -4,4 +4,26 @@ package event
 type Event interface {
 	Output
 	event()
+	// BookSymbol identifies the book that generated the event.
+	BookSymbol() string
+	// BookSequence is the position of the event among the ones published by its book, starting at 1.
+	BookSequence() uint64
+	// SetBookSequence numbers the event, it is called by the engine when the event is published.
+	SetBookSequence(sequence uint64)
+}
+
+// Header is embedded in every event.
+type Header struct {
+	// Sequence grows by one for every event published by the book, so the consumers can find the missing ones.
+	Sequence uint64
+}
+
+func (h *Header) event() {}
+
+func (h *Header) BookSequence() uint64 {
+	return h.Sequence
+}
+
+func (h *Header) SetBookSequence(sequence uint64) {
+	h.Sequence = sequence
 }
@@ -6,10 +6,56 @@ import (
 	"github.com/rodoufu/simple-orderbook/pkg/entity"
 )
 
+// CancelReason explains why an order was cancelled.
+type CancelReason uint8
+
+const (
+	// CancelRequested is used when the user asked for the cancel.
+	CancelRequested CancelReason = iota
+	// CancelNoLiquidity is used when an order that cannot sit in the book, like market, immediate or cancel and fill
+	// or kill orders, could not be fully filled.
+	CancelNoLiquidity CancelReason = iota
+	// CancelExpired is used when the clock reaches the deadline of a good till time order.
+	CancelExpired CancelReason = iota
+	// CancelReplaced is used when a replace takes the order out of the book to send it again with the new values.
+	CancelReplaced CancelReason = iota
+	// CancelSelfTrade is used when the self-trade prevention blocks a match between orders of the same user.
+	CancelSelfTrade CancelReason = iota
+	// CancelPriceBand is used when the circuit breaker halts the book, so the rest of the order that tripped it does
+	// not stay crossing the book.
+	CancelPriceBand CancelReason = iota
+)
+
+func (r CancelReason) String() string {
+	switch r {
+	case CancelRequested:
+		return "requested"
+	case CancelNoLiquidity:
+		return "no liquidity"
+	case CancelExpired:
+		return "expired"
+	case CancelReplaced:
+		return "replaced"
+	case CancelSelfTrade:
+		return "self-trade"
+	case CancelPriceBand:
+		return "price band"
+	default:
+		return fmt.Sprintf("invalid cancel reason (%v)", uint8(r))
+	}
+}
+
 // OrderCancelled is emitted when an order is successfully canceled.
 type OrderCancelled struct {
-	Event
-	Order entity.Order
+	Header
+	Symbol string
+	Order  entity.Order
+	// Reason tells why the order was cancelled.
+	Reason CancelReason
+}
+
+func (oc *OrderCancelled) BookSymbol() string {
+	return oc.Symbol
 }
 
 func (oc *OrderCancelled) Output() string {
@@ -18,8 +64,13 @@ func (oc *OrderCancelled) Output() string {
 
 // OrderCreated is emitted when an order is successfully added to the book.
 type OrderCreated struct {
-	Event
-	Order entity.Order
+	Header
+	Symbol string
+	Order  entity.Order
+}
+
+func (oc *OrderCreated) BookSymbol() string {
+	return oc.Symbol
 }
 
 func (oc *OrderCreated) Output() string {
@@ -28,8 +79,13 @@ func (oc *OrderCreated) Output() string {
 
 // OrderUpdated is emitted when an order changes.
 type OrderUpdated struct {
-	Event
-	Order entity.Order
+	Header
+	Symbol string
+	Order  entity.Order
+}
+
+func (ou *OrderUpdated) BookSymbol() string {
+	return ou.Symbol
 }
 
 func (ou *OrderUpdated) Output() string {
@@ -38,20 +94,155 @@ func (ou *OrderUpdated) Output() string {
 
 // OrderFilled is emitted when an order is successfully filled.
 type OrderFilled struct {
-	Event
-	Order entity.Order
+	Header
+	Symbol string
+	Order  entity.Order
 	// Full indicates if the order was fully filled.
 	Full bool
+	// Notional is the value of the fill, with the scale of the prices plus the one of the quantities.
+	Notional entity.Decimal128
+}
+
+func (of *OrderFilled) BookSymbol() string {
+	return of.Symbol
 }
 
 func (of *OrderFilled) Output() string {
 	return ""
 }
 
+// RejectReason explains why an order was rejected.
+type RejectReason uint8
+
+const (
+	// RejectCrossed is used when the order would cross the book.
+	RejectCrossed RejectReason = iota
+	// RejectPostOnly is used when a post only order would take liquidity.
+	RejectPostOnly RejectReason = iota
+	// RejectUnknownOrder is used when a user asks to change an order the user does not have.
+	RejectUnknownOrder RejectReason = iota
+	// RejectAuction is used for orders that cannot wait for the uncross of a call auction, like market, immediate or
+	// cancel and fill or kill orders.
+	RejectAuction RejectReason = iota
+	// RejectSession is used for new orders and replaces sent while the book is halted or closed.
+	RejectSession RejectReason = iota
+	// RejectPriceBand is used for orders priced outside the static price band.
+	RejectPriceBand RejectReason = iota
+	// RejectUnknownInstrument is used for orders of a symbol missing from the instrument registry.
+	RejectUnknownInstrument RejectReason = iota
+	// RejectTickSize is used for prices that are not a multiple of the tick size of the instrument.
+	RejectTickSize RejectReason = iota
+	// RejectLotSize is used for amounts that are not a multiple of the lot size of the instrument.
+	RejectLotSize RejectReason = iota
+	// RejectQuantity is used for amounts below the minimum or above the maximum quantity of the instrument.
+	RejectQuantity RejectReason = iota
+	// RejectPriceLimit is used for prices outside the price limits of the instrument.
+	RejectPriceLimit RejectReason = iota
+	// RejectNotional is used for orders whose value cannot be represented, as their amount with the hidden one does not
+	// fit in a Decimal.
+	RejectNotional RejectReason = iota
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectCrossed:
+		return "crossed book"
+	case RejectPostOnly:
+		return "post only"
+	case RejectUnknownOrder:
+		return "unknown order"
+	case RejectAuction:
+		return "auction"
+	case RejectSession:
+		return "session"
+	case RejectPriceBand:
+		return "price band"
+	case RejectUnknownInstrument:
+		return "unknown instrument"
+	case RejectTickSize:
+		return "tick size"
+	case RejectLotSize:
+		return "lot size"
+	case RejectQuantity:
+		return "quantity"
+	case RejectPriceLimit:
+		return "price limit"
+	case RejectNotional:
+		return "notional"
+	default:
+		return fmt.Sprintf("invalid reject reason (%v)", uint8(r))
+	}
+}
+
+// OrderRejected is emitted when an order is not accepted by the engine.
+type OrderRejected struct {
+	Header
+	Symbol string
+	Order  entity.Order
+	// Reason tells why the order was rejected.
+	Reason RejectReason
+}
+
+func (or *OrderRejected) BookSymbol() string {
+	return or.Symbol
+}
+
+func (or *OrderRejected) Output() string {
+	if or == nil {
+		return ""
+	}
+	return fmt.Sprintf("R, %v, %v", or.Order.User, or.Order.ID)
+}
+
+// RequestRejected is emitted when a cancel or replace is not accepted, the order stays as it was.
+type RequestRejected struct {
+	Header
+	Symbol string
+	// User that sent the request.
+	User    entity.UserID
+	OrderID entity.OrderID
+	// Reason tells why the request was rejected.
+	Reason RejectReason
+}
+
+func (rr *RequestRejected) BookSymbol() string {
+	return rr.Symbol
+}
+
+func (rr *RequestRejected) Output() string {
+	if rr == nil {
+		return ""
+	}
+	return fmt.Sprintf("R, %v, %v", rr.User, rr.OrderID)
+}
+
+// OrderRepriced is emitted when a post only order has its price changed so it does not take liquidity.
+type OrderRepriced struct {
+	Header
+	Symbol string
+	// Order with the new price.
+	Order entity.Order
+	// OriginalPrice is the price the order was sent with.
+	OriginalPrice entity.Decimal
+}
+
+func (or *OrderRepriced) BookSymbol() string {
+	return or.Symbol
+}
+
+func (or *OrderRepriced) Output() string {
+	return ""
+}
+
 // OrderAcknowledge is used only to print messages.
 type OrderAcknowledge struct {
-	Event
-	Order entity.Order
+	Header
+	Symbol string
+	Order  entity.Order
+}
+
+func (oa *OrderAcknowledge) BookSymbol() string {
+	return oa.Symbol
 }
 
 func (oa *OrderAcknowledge) Output() string {
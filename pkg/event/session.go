@@ -0,0 +1,29 @@
+package event
+
+import (
+	"fmt"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+)
+
+// SessionChanged is emitted when a book moves to another session state.
+type SessionChanged struct {
+	Header
+	Symbol   string
+	Previous entity.SessionState
+	Session  entity.SessionState
+}
+
+func (sc *SessionChanged) BookSymbol() string {
+	return sc.Symbol
+}
+
+func (sc *SessionChanged) Output() string {
+	if sc == nil {
+		return ""
+	}
+	if len(sc.Symbol) == 0 {
+		return fmt.Sprintf("S, %v", sc.Session)
+	}
+	return fmt.Sprintf("S, %v, %v", sc.Symbol, sc.Session)
+}
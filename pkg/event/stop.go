@@ -0,0 +1,57 @@
+package event
+
+import (
+	"fmt"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+)
+
+// StopAccepted is emitted when a stop order is waiting for its stop price to be crossed.
+type StopAccepted struct {
+	Header
+	Symbol string
+	Order  entity.Order
+}
+
+func (sa *StopAccepted) BookSymbol() string {
+	return sa.Symbol
+}
+
+func (sa *StopAccepted) Output() string {
+	if sa == nil {
+		return ""
+	}
+	return fmt.Sprintf("A, %v, %v", sa.Order.User, sa.Order.ID)
+}
+
+// StopTriggered is emitted when a trade crosses the stop price, the order is then sent to the book.
+type StopTriggered struct {
+	Header
+	Symbol string
+	Order  entity.Order
+}
+
+func (st *StopTriggered) BookSymbol() string {
+	return st.Symbol
+}
+
+func (st *StopTriggered) Output() string {
+	return ""
+}
+
+// StopCancelled is emitted when a stop order is cancelled before being triggered.
+type StopCancelled struct {
+	Header
+	Symbol string
+	Order  entity.Order
+	// Reason tells why the stop was cancelled.
+	Reason CancelReason
+}
+
+func (sc *StopCancelled) BookSymbol() string {
+	return sc.Symbol
+}
+
+func (sc *StopCancelled) Output() string {
+	return ""
+}
@@ -8,8 +8,15 @@ import (
 
 // TradeGenerated is emitted when a match is found.
 type TradeGenerated struct {
-	Event
-	Trade entity.Trade
+	Header
+	Symbol string
+	Trade  entity.Trade
+	// Scale is used to print the price and the amount with the precision of the instrument.
+	Scale entity.Scale
+}
+
+func (tg *TradeGenerated) BookSymbol() string {
+	return tg.Symbol
 }
 
 func (tg *TradeGenerated) Output() string {
@@ -19,6 +26,6 @@ func (tg *TradeGenerated) Output() string {
 	return fmt.Sprintf(
 		"T, %v, %v, %v, %v, %v, %v",
 		tg.Trade.BuyUserID, tg.Trade.BuyOrderID, tg.Trade.SellUserID, tg.Trade.SellOrderID,
-		tg.Trade.Price, tg.Trade.Amount,
+		tg.Scale.FormatPrice(tg.Trade.Price), tg.Scale.FormatQuantity(tg.Trade.Amount),
 	)
 }
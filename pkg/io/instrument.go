@@ -0,0 +1,95 @@
+package io
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+)
+
+// ReadInstruments loads the instrument registry from the file, one instrument per line: symbol, tickSize, lotSize,
+// minQuantity, maxQuantity, minPrice, maxPrice[, priceScale, quantityScale[, referencePrice]].
+// The values are decimals with the scales of the instrument, integers when they are not given, and a zero value does
+// not constrain the orders.
+// The reference price is the one the static price band is around, the last trade price is used when it is zero.
+func ReadInstruments(fileName string) (entity.Instruments, error) {
+	csvFile, err := os.Open(fileName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem opening: %v", fileName)
+	}
+	defer csvFile.Close()
+
+	csvReader := csv.NewReader(csvFile)
+	csvReader.Comment = '#'
+	csvReader.FieldsPerRecord = -1
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem reading: %v", fileName)
+	}
+
+	resp := entity.Instruments{}
+	for _, record := range records {
+		if len(record) != 7 && len(record) != 9 && len(record) != 10 {
+			return nil, fmt.Errorf("invalid instrument line: %v", record)
+		}
+		for i := 0; i < len(record); i++ {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		instrument, err := parseInstrument(record)
+		if err != nil {
+			return nil, errors.Wrapf(err, "problem parsing instrument %v", record[0])
+		}
+		if _, ok := resp[instrument.Symbol]; ok {
+			return nil, fmt.Errorf("duplicated instrument: %v", instrument.Symbol)
+		}
+		resp[instrument.Symbol] = instrument
+	}
+	return resp, nil
+}
+
+func parseInstrument(record []string) (entity.Instrument, error) {
+	var scale entity.Scale
+	if len(record) >= 9 {
+		for i, it := range []*uint8{&scale.Price, &scale.Quantity} {
+			value, err := strconv.ParseUint(record[7+i], 10, 8)
+			if err != nil || value > entity.MaxScale {
+				return entity.Instrument{}, fmt.Errorf("invalid scale: %v", record[7+i])
+			}
+			*it = uint8(value)
+		}
+	}
+
+	instrument := entity.Instrument{
+		Symbol: record[0],
+		Scale:  scale,
+	}
+	values := []struct {
+		column int
+		field  *entity.Decimal
+		parse  func(string) (entity.Decimal, error)
+	}{
+		{1, &instrument.TickSize, scale.ParsePrice},
+		{2, &instrument.LotSize, scale.ParseQuantity},
+		{3, &instrument.MinQuantity, scale.ParseQuantity},
+		{4, &instrument.MaxQuantity, scale.ParseQuantity},
+		{5, &instrument.MinPrice, scale.ParsePrice},
+		{6, &instrument.MaxPrice, scale.ParsePrice},
+		// The reference price comes after the scales.
+		{9, &instrument.ReferencePrice, scale.ParsePrice},
+	}
+	for _, value := range values {
+		if value.column >= len(record) {
+			continue
+		}
+		var err error
+		if *value.field, err = value.parse(record[value.column]); err != nil {
+			return entity.Instrument{}, err
+		}
+	}
+	return instrument, nil
+}
@@ -0,0 +1,281 @@
+package io
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// journalHeaderSize is the size of the length and the CRC written before every record.
+	journalHeaderSize = 8
+	// maxJournalRecordSize is far more than any transaction needs, so a corrupted length is not allocated.
+	maxJournalRecordSize = 1 << 20
+)
+
+// JournalFailedError is returned once an entry could not be written nor taken back, the file cannot be trusted for
+// more entries.
+var JournalFailedError = fmt.Errorf("journal failed")
+
+// JournalEntry is a transaction accepted by an engine, with the time the engine applied it at.
+type JournalEntry struct {
+	// Sequence is given by the journal, it starts at 1 and grows by one for every entry.
+	Sequence    uint64
+	Time        time.Time
+	Transaction Transaction
+}
+
+// journalRecord is how an entry is written, the transaction is kept with its type so it can be read back.
+type journalRecord struct {
+	Sequence    uint64          `json:"sequence"`
+	Time        time.Time       `json:"time"`
+	Type        string          `json:"type"`
+	Transaction json.RawMessage `json:"transaction"`
+}
+
+// transactionTypes names the transactions that can be journaled.
+var transactionTypes = map[string]func() Transaction{
+	"N": func() Transaction { return &NewOrderTransaction{} },
+	"C": func() Transaction { return &CancelOrderTransaction{} },
+	"R": func() Transaction { return &ReplaceOrderTransaction{} },
+	"X": func() Transaction { return &SessionTransaction{} },
+	"E": func() Transaction { return &ExpireOrdersTransaction{} },
+	"F": func() Transaction { return &FlushAllOrdersTransaction{} },
+}
+
+func transactionType(transaction Transaction) (string, error) {
+	switch transaction.(type) {
+	case NewOrderTransaction:
+		return "N", nil
+	case CancelOrderTransaction:
+		return "C", nil
+	case ReplaceOrderTransaction:
+		return "R", nil
+	case SessionTransaction:
+		return "X", nil
+	case ExpireOrdersTransaction:
+		return "E", nil
+	case FlushAllOrdersTransaction:
+		return "F", nil
+	default:
+		return "", fmt.Errorf("transaction cannot be journaled: %v", transaction)
+	}
+}
+
+func encodeEntry(entry JournalEntry) ([]byte, error) {
+	name, err := transactionType(entry.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	transaction, err := json.Marshal(entry.Transaction)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem encoding transaction %v", entry.Sequence)
+	}
+	payload, err := json.Marshal(journalRecord{
+		Sequence:    entry.Sequence,
+		Time:        entry.Time,
+		Type:        name,
+		Transaction: transaction,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem encoding entry %v", entry.Sequence)
+	}
+	if len(payload) > maxJournalRecordSize {
+		return nil, fmt.Errorf("entry %v too large: %v bytes", entry.Sequence, len(payload))
+	}
+
+	resp := make([]byte, journalHeaderSize, journalHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(resp[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(resp[4:8], crc32.ChecksumIEEE(payload))
+	return append(resp, payload...), nil
+}
+
+func decodeEntry(payload []byte) (JournalEntry, error) {
+	var record journalRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return JournalEntry{}, errors.Wrapf(err, "problem decoding entry")
+	}
+	newTransaction, ok := transactionTypes[record.Type]
+	if !ok {
+		return JournalEntry{}, fmt.Errorf("invalid transaction type in entry %v: %v", record.Sequence, record.Type)
+	}
+	transaction := newTransaction()
+	if err := json.Unmarshal(record.Transaction, transaction); err != nil {
+		return JournalEntry{}, errors.Wrapf(err, "problem decoding transaction %v", record.Sequence)
+	}
+	return JournalEntry{
+		Sequence: record.Sequence,
+		Time:     record.Time,
+		// The pointer was only needed to decode it, the engines receive the transactions by value.
+		Transaction: dereference(transaction),
+	}, nil
+}
+
+func dereference(transaction Transaction) Transaction {
+	switch t := transaction.(type) {
+	case *NewOrderTransaction:
+		return *t
+	case *CancelOrderTransaction:
+		return *t
+	case *ReplaceOrderTransaction:
+		return *t
+	case *SessionTransaction:
+		return *t
+	case *ExpireOrdersTransaction:
+		return *t
+	case *FlushAllOrdersTransaction:
+		return *t
+	default:
+		return transaction
+	}
+}
+
+// readJournal reads the entries until the end of the file, also giving the size of the complete records.
+// A record cut by a crash while it was written is only allowed at the end of the file, it is not part of the entries.
+func readJournal(reader io.Reader) ([]JournalEntry, int64, error) {
+	bufReader := bufio.NewReader(reader)
+	var resp []JournalEntry
+	var size int64
+	header := make([]byte, journalHeaderSize)
+	for {
+		if _, err := io.ReadFull(bufReader, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return resp, size, nil
+		} else if err != nil {
+			return nil, 0, errors.Wrapf(err, "problem reading journal")
+		}
+		length := binary.LittleEndian.Uint32(header[0:4])
+		if length > maxJournalRecordSize {
+			return nil, 0, fmt.Errorf("corrupted journal entry after %v entries, length %v", len(resp), length)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(bufReader, payload); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return resp, size, nil
+		} else if err != nil {
+			return nil, 0, errors.Wrapf(err, "problem reading journal")
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+			return nil, 0, fmt.Errorf("corrupted journal entry after %v entries", len(resp))
+		}
+
+		entry, err := decodeEntry(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		if want := uint64(len(resp)) + 1; entry.Sequence != want {
+			return nil, 0, fmt.Errorf("journal entry %v out of sequence, want %v", entry.Sequence, want)
+		}
+		resp = append(resp, entry)
+		size += int64(journalHeaderSize + len(payload))
+	}
+}
+
+// ReadJournal gives the entries in the journal file, in the order they were appended.
+func ReadJournal(fileName string) ([]JournalEntry, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem opening: %v", fileName)
+	}
+	defer file.Close()
+	entries, _, err := readJournal(file)
+	return entries, err
+}
+
+// Journal appends the transactions accepted by an engine to a file, so they can be replayed after a crash.
+type Journal struct {
+	file *os.File
+	// sequence is the one of the last entry in the file.
+	sequence uint64
+	// size is where the last complete entry ends, the file is cut there when an entry fails.
+	size int64
+	// failed is returned by every append once the file could not be cut back.
+	failed error
+}
+
+// OpenJournal opens the journal file to append more entries, creating it when it does not exist.
+// It also gives the entries already in the file, dropping a last record cut by a crash.
+func OpenJournal(fileName string) (*Journal, []JournalEntry, error) {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "problem opening: %v", fileName)
+	}
+	entries, size, err := readJournal(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, errors.Wrapf(err, "problem reading: %v", fileName)
+	}
+	if err = file.Truncate(size); err != nil {
+		file.Close()
+		return nil, nil, errors.Wrapf(err, "problem truncating: %v", fileName)
+	}
+	if _, err = file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, errors.Wrapf(err, "problem seeking: %v", fileName)
+	}
+	return &Journal{
+		file:     file,
+		sequence: uint64(len(entries)),
+		size:     size,
+	}, entries, nil
+}
+
+// Append writes the transaction with the next sequence number, it only returns once the entry is on disk.
+// An entry that fails is cut out of the file, so the next ones follow the last complete entry, when the file cannot
+// be cut the journal fails with JournalFailedError.
+func (j *Journal) Append(at time.Time, transaction Transaction) (JournalEntry, error) {
+	if j.failed != nil {
+		return JournalEntry{}, j.failed
+	}
+	entry := JournalEntry{
+		Sequence:    j.sequence + 1,
+		Time:        at,
+		Transaction: transaction,
+	}
+	record, err := encodeEntry(entry)
+	if err != nil {
+		return JournalEntry{}, err
+	}
+	if _, err = j.file.Write(record); err != nil {
+		return JournalEntry{}, j.rollback(errors.Wrapf(err, "problem writing entry %v", entry.Sequence))
+	}
+	if err = j.file.Sync(); err != nil {
+		return JournalEntry{}, j.rollback(errors.Wrapf(err, "problem syncing entry %v", entry.Sequence))
+	}
+	j.sequence = entry.Sequence
+	j.size += int64(len(record))
+	return entry, nil
+}
+
+// rollback cuts the file after the last complete entry, failing the journal when it cannot.
+func (j *Journal) rollback(cause error) error {
+	err := j.file.Truncate(j.size)
+	if err == nil {
+		_, err = j.file.Seek(j.size, io.SeekStart)
+	}
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		j.failed = errors.Wrapf(JournalFailedError, "%v, problem cutting the file back: %v", cause, err)
+		return j.failed
+	}
+	return cause
+}
+
+// Sequence gives the sequence number of the last entry appended.
+func (j *Journal) Sequence() uint64 {
+	return j.sequence
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,78 @@
+package io
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJournal_Append(t *testing.T) {
+	t.Parallel()
+	at := time.UnixMilli(1_000).UTC()
+	transactions := []Transaction{
+		CancelOrderTransaction{User: 1, OrderID: 1},
+		CancelOrderTransaction{User: 2, OrderID: 2},
+	}
+	fileName := filepath.Join(t.TempDir(), "journal")
+	journal, _, err := OpenJournal(fileName)
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+	if _, err = journal.Append(at, transactions[0]); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	// An entry that fails half written is cut out, so the next one follows the last complete entry.
+	if _, err = journal.file.Write([]byte{1, 2, 3}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	cause := fmt.Errorf("disk full")
+	if err = journal.rollback(cause); err != cause {
+		t.Fatalf("rollback() error = %v, want %v", err, cause)
+	}
+	if _, err = journal.Append(at, transactions[1]); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	entries, err := ReadJournal(fileName)
+	if err != nil {
+		t.Fatalf("ReadJournal() error = %v", err)
+	}
+	wantEntries := []JournalEntry{
+		{Sequence: 1, Time: at, Transaction: transactions[0]},
+		{Sequence: 2, Time: at, Transaction: transactions[1]},
+	}
+	if !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("entries: %v, want: %v", entries, wantEntries)
+	}
+
+	// Once the file cannot be cut back the journal refuses every entry.
+	if err = journal.file.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = journal.Append(at, transactions[0]); !errors.Is(err, JournalFailedError) {
+			t.Errorf("Append(%d) error = %v, want %v", i, err, JournalFailedError)
+		}
+	}
+	if got := journal.Sequence(); got != 2 {
+		t.Errorf("Sequence() = %v, want 2", got)
+	}
+}
+
+func TestOpenJournal_corruptedLength(t *testing.T) {
+	t.Parallel()
+	fileName := filepath.Join(t.TempDir(), "journal")
+	header := make([]byte, journalHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], ^uint32(0))
+	if err := os.WriteFile(fileName, header, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, _, err := OpenJournal(fileName); err == nil {
+		t.Errorf("OpenJournal() error = nil, want the corrupted entry")
+	}
+}
@@ -15,7 +15,11 @@ import (
 	"github.com/rodoufu/simple-orderbook/pkg/entity"
 )
 
-func ReadTransactions(ctx context.Context, fileName string) (<-chan Transaction, error) {
+// ReadTransactions parses the transactions in the file, the orders are timestamped with the clock.
+// Prices and amounts are read with the scale of the instrument of the symbol, integers for unknown symbols.
+func ReadTransactions(
+	ctx context.Context, fileName string, clock entity.Clock, instruments entity.Instruments,
+) (<-chan Transaction, error) {
 	csvFile, err := os.Open(fileName)
 	if err != nil {
 		return nil, errors.Wrapf(err, "problem opening: %v", fileName)
@@ -29,6 +33,8 @@ func ReadTransactions(ctx context.Context, fileName string) (<-chan Transaction,
 		done := ctx.Done()
 		csvReader := csv.NewReader(csvFile)
 		csvReader.Comment = '#'
+		// orderSymbols gives the scale of the replaces, since they do not carry the symbol.
+		orderSymbols := map[entity.OrderKey]string{}
 
 		for {
 			select {
@@ -47,57 +53,57 @@ func ReadTransactions(ctx context.Context, fileName string) (<-chan Transaction,
 
 				switch record[0] {
 				case "N":
-					if len(record) != 7 {
+					if len(record) < 7 {
 						resp <- ErrorTransaction{
 							Err: fmt.Errorf("invalid create order line: %v", record),
 						}
 						return
 					}
 
-					var userID, price, amount, orderID int64
-					userID, err = strconv.ParseInt(record[1], 10, 64)
+					var order entity.Order
+					order, err = parseOrder(record, clock, instruments[record[2]].Scale)
 					if err != nil {
 						resp <- ErrorTransaction{
-							Err: errors.Wrapf(err, "problem parsing user ID in create order"),
+							Err: errors.Wrapf(err, "problem parsing create order"),
 						}
 						return
 					}
-					price, err = strconv.ParseInt(record[3], 10, 64)
-					if err != nil {
+					orderSymbols[order.Key()] = order.Symbol
+					resp <- NewOrderTransaction{
+						Symbol: record[2],
+						Order:  order,
+					}
+				case "S":
+					if len(record) < 8 {
 						resp <- ErrorTransaction{
-							Err: errors.Wrapf(err, "problem parsing price in create order"),
+							Err: fmt.Errorf("invalid create stop order line: %v", record),
 						}
 						return
 					}
-					amount, err = strconv.ParseInt(record[4], 10, 64)
-					if err != nil {
+
+					scale := instruments[record[2]].Scale
+					var stopPrice entity.Decimal
+					stopPrice, err = scale.ParsePrice(record[3])
+					if err != nil || stopPrice == 0 {
 						resp <- ErrorTransaction{
-							Err: errors.Wrapf(err, "problem parsing amount in create order"),
+							Err: fmt.Errorf("invalid stop price in create stop order: %v", record[3]),
 						}
 						return
 					}
-					orderID, err = strconv.ParseInt(record[6], 10, 64)
+					// Without the stop price the line has the same layout as a create order one.
+					var order entity.Order
+					order, err = parseOrder(append(record[:3:3], record[4:]...), clock, scale)
 					if err != nil {
 						resp <- ErrorTransaction{
-							Err: errors.Wrapf(err, "problem parsing amount in create order"),
+							Err: errors.Wrapf(err, "problem parsing create stop order"),
 						}
 						return
 					}
-
-					side := entity.Buy
-					if record[5] == "S" {
-						side = entity.Sell
-					}
+					order.StopPrice = stopPrice
+					orderSymbols[order.Key()] = order.Symbol
 					resp <- NewOrderTransaction{
 						Symbol: record[2],
-						Order: entity.Order{
-							Amount:    uint64(amount),
-							Price:     uint64(price),
-							ID:        entity.OrderID(orderID),
-							Side:      side,
-							User:      entity.UserID(userID),
-							Timestamp: time.Now(),
-						},
+						Order:  order,
 					}
 				case "C":
 					if len(record) != 3 {
@@ -126,6 +132,77 @@ func ReadTransactions(ctx context.Context, fileName string) (<-chan Transaction,
 						User:    entity.UserID(userID),
 						OrderID: entity.OrderID(orderID),
 					}
+				case "R", "M":
+					if len(record) != 5 {
+						resp <- ErrorTransaction{
+							Err: fmt.Errorf("invalid replace order line: %v", record),
+						}
+						return
+					}
+
+					var userID, orderID int64
+					var price, amount entity.Decimal
+					userID, err = strconv.ParseInt(record[1], 10, 64)
+					if err != nil {
+						resp <- ErrorTransaction{
+							Err: errors.Wrapf(err, "problem parsing user ID in replace order"),
+						}
+						return
+					}
+					orderID, err = strconv.ParseInt(record[2], 10, 64)
+					if err != nil {
+						resp <- ErrorTransaction{
+							Err: errors.Wrapf(err, "problem parsing order ID in replace order"),
+						}
+						return
+					}
+					scale := instruments[orderSymbols[entity.OrderKey{
+						User: entity.UserID(userID),
+						ID:   entity.OrderID(orderID),
+					}]].Scale
+					price, err = scale.ParsePrice(record[3])
+					if err != nil {
+						resp <- ErrorTransaction{
+							Err: errors.Wrapf(err, "problem parsing price in replace order"),
+						}
+						return
+					}
+					amount, err = scale.ParseQuantity(record[4])
+					if err != nil {
+						resp <- ErrorTransaction{
+							Err: errors.Wrapf(err, "problem parsing amount in replace order"),
+						}
+						return
+					}
+					resp <- ReplaceOrderTransaction{
+						User:    entity.UserID(userID),
+						OrderID: entity.OrderID(orderID),
+						Price:   price,
+						Amount:  amount,
+					}
+				case "X":
+					if len(record) < 2 || len(record) > 3 {
+						resp <- ErrorTransaction{
+							Err: fmt.Errorf("invalid session line: %v", record),
+						}
+						return
+					}
+
+					var session entity.SessionState
+					session, err = entity.ParseSessionState(record[1])
+					if err != nil {
+						resp <- ErrorTransaction{
+							Err: errors.Wrapf(err, "problem parsing session"),
+						}
+						return
+					}
+					transaction := SessionTransaction{
+						Session: session,
+					}
+					if len(record) == 3 {
+						transaction.Symbol = record[2]
+					}
+					resp <- transaction
 				case "F":
 					resp <- FlushAllOrdersTransaction{}
 				default:
@@ -138,3 +215,91 @@ func ReadTransactions(ctx context.Context, fileName string) (<-chan Transaction,
 	}()
 	return resp, nil
 }
+
+// parseOrder reads a create order line: N, user, symbol, price, amount, side, userOrderId[, instruction...].
+// The price and the amounts are read with the scale of the instrument.
+func parseOrder(record []string, clock entity.Clock, scale entity.Scale) (entity.Order, error) {
+	userID, err := strconv.ParseInt(record[1], 10, 64)
+	if err != nil {
+		return entity.Order{}, errors.Wrapf(err, "problem parsing user ID")
+	}
+	price, err := scale.ParsePrice(record[3])
+	if err != nil {
+		return entity.Order{}, errors.Wrapf(err, "problem parsing price")
+	}
+	amount, err := scale.ParseQuantity(record[4])
+	if err != nil {
+		return entity.Order{}, errors.Wrapf(err, "problem parsing amount")
+	}
+	orderID, err := strconv.ParseInt(record[6], 10, 64)
+	if err != nil {
+		return entity.Order{}, errors.Wrapf(err, "problem parsing order ID")
+	}
+
+	side := entity.Buy
+	if record[5] == "S" {
+		side = entity.Sell
+	}
+	orderType := entity.Limit
+	if price == 0 {
+		orderType = entity.Market
+	}
+	order := entity.Order{
+		Amount:    amount,
+		Price:     price,
+		ID:        entity.OrderID(orderID),
+		Side:      side,
+		User:      entity.UserID(userID),
+		Symbol:    record[2],
+		Type:      orderType,
+		Timestamp: clock.Now(),
+	}
+	if err = parseInstructions(&order, record[7:], scale); err != nil {
+		return entity.Order{}, errors.Wrapf(err, "problem parsing instructions")
+	}
+	return order, nil
+}
+
+// parseInstructions applies the optional columns of a create order line to the order.
+func parseInstructions(order *entity.Order, instructions []string, scale entity.Scale) error {
+	for _, instruction := range instructions {
+		if name, value, found := strings.Cut(instruction, "="); found {
+			switch name {
+			case "DISPLAY":
+				displayAmount, err := scale.ParseQuantity(value)
+				if err != nil {
+					return errors.Wrapf(err, "problem parsing display amount")
+				}
+				order.DisplayAmount = displayAmount
+			case "EXPIRE":
+				expireAt, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return errors.Wrapf(err, "problem parsing expiry")
+				}
+				order.TimeInForce = entity.GoodTillTime
+				order.ExpireAt = time.UnixMilli(expireAt)
+			default:
+				return fmt.Errorf("invalid instruction: %v", instruction)
+			}
+			continue
+		}
+
+		switch instruction {
+		case "POST_ONLY":
+			order.PostOnly = entity.PostOnlyReject
+		case "POST_ONLY_REPRICE":
+			order.PostOnly = entity.PostOnlyReprice
+		default:
+			if selfTrade, err := entity.ParseSelfTradePrevention(instruction); err == nil {
+				order.SelfTrade = selfTrade
+				continue
+			}
+			timeInForce, err := entity.ParseTimeInForce(instruction)
+			if err != nil {
+				return fmt.Errorf("invalid instruction: %v", instruction)
+			}
+			order.TimeInForce = timeInForce
+		}
+	}
+	return nil
+}
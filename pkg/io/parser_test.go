@@ -0,0 +1,239 @@
+package io
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
+)
+
+func TestReadTransactions(t *testing.T) {
+	t.Parallel()
+	now := time.UnixMilli(1_000)
+	instruments := entity.Instruments{
+		"BTC": {Symbol: "BTC", Scale: entity.Scale{Price: 2, Quantity: 3}},
+	}
+	tests := []struct {
+		name             string
+		lines            []string
+		wantTransactions []Transaction
+		wantErr          bool
+	}{
+		{
+			name:  "create order",
+			lines: []string{"N, 1, IBM, 10, 100, B, 1"},
+			wantTransactions: []Transaction{
+				NewOrderTransaction{Symbol: "IBM", Order: entity.Order{
+					Amount: 100, Price: 10, ID: 1, Side: entity.Buy, User: 1, Symbol: "IBM", Timestamp: now,
+				}},
+			},
+		},
+		{
+			name:  "market order",
+			lines: []string{"N, 2, IBM, 0, 100, S, 3"},
+			wantTransactions: []Transaction{
+				NewOrderTransaction{Symbol: "IBM", Order: entity.Order{
+					Amount: 100, ID: 3, Side: entity.Sell, User: 2, Symbol: "IBM", Type: entity.Market, Timestamp: now,
+				}},
+			},
+		},
+		{
+			name: "time in force, self-trade prevention and post only",
+			lines: []string{
+				"N, 1, IBM, 10, 100, B, 1, IOC, STP_CANCEL_OLDEST",
+				"N, 1, IBM, 10, 100, B, 2, FOK",
+				"N, 1, IBM, 10, 100, S, 3, POST_ONLY",
+				"N, 1, IBM, 10, 100, S, 4, POST_ONLY_REPRICE, STP_DECREMENT",
+			},
+			wantTransactions: []Transaction{
+				NewOrderTransaction{Symbol: "IBM", Order: entity.Order{
+					Amount: 100, Price: 10, ID: 1, Side: entity.Buy, User: 1, Symbol: "IBM", Timestamp: now,
+					TimeInForce: entity.ImmediateOrCancel, SelfTrade: entity.CancelOldest,
+				}},
+				NewOrderTransaction{Symbol: "IBM", Order: entity.Order{
+					Amount: 100, Price: 10, ID: 2, Side: entity.Buy, User: 1, Symbol: "IBM", Timestamp: now,
+					TimeInForce: entity.FillOrKill,
+				}},
+				NewOrderTransaction{Symbol: "IBM", Order: entity.Order{
+					Amount: 100, Price: 10, ID: 3, Side: entity.Sell, User: 1, Symbol: "IBM", Timestamp: now,
+					PostOnly: entity.PostOnlyReject,
+				}},
+				NewOrderTransaction{Symbol: "IBM", Order: entity.Order{
+					Amount: 100, Price: 10, ID: 4, Side: entity.Sell, User: 1, Symbol: "IBM", Timestamp: now,
+					PostOnly: entity.PostOnlyReprice, SelfTrade: entity.DecrementAndCancel,
+				}},
+			},
+		},
+		{
+			name:  "iceberg and good till time",
+			lines: []string{"N, 1, IBM, 10, 100, B, 1, DISPLAY=10, EXPIRE=5000"},
+			wantTransactions: []Transaction{
+				NewOrderTransaction{Symbol: "IBM", Order: entity.Order{
+					Amount: 100, Price: 10, ID: 1, Side: entity.Buy, User: 1, Symbol: "IBM", Timestamp: now,
+					DisplayAmount: 10, TimeInForce: entity.GoodTillTime, ExpireAt: time.UnixMilli(5_000),
+				}},
+			},
+		},
+		{
+			name:  "stop order",
+			lines: []string{"S, 1, IBM, 12, 0, 100, B, 1", "S, 2, IBM, 8, 7, 50, S, 2, IOC"},
+			wantTransactions: []Transaction{
+				NewOrderTransaction{Symbol: "IBM", Order: entity.Order{
+					Amount: 100, ID: 1, Side: entity.Buy, User: 1, Symbol: "IBM", Type: entity.Market, StopPrice: 12,
+					Timestamp: now,
+				}},
+				NewOrderTransaction{Symbol: "IBM", Order: entity.Order{
+					Amount: 50, Price: 7, ID: 2, Side: entity.Sell, User: 2, Symbol: "IBM", StopPrice: 8,
+					TimeInForce: entity.ImmediateOrCancel, Timestamp: now,
+				}},
+			},
+		},
+		{
+			name:  "decimal prices and amounts of the instrument",
+			lines: []string{"N, 1, BTC, 101.25, 0.5, B, 1", "S, 1, BTC, 100.5, 0, 1.25, S, 2", "M, 1, 1, 101.5, 0.25"},
+			wantTransactions: []Transaction{
+				NewOrderTransaction{Symbol: "BTC", Order: entity.Order{
+					Amount: 500, Price: 10125, ID: 1, Side: entity.Buy, User: 1, Symbol: "BTC", Timestamp: now,
+				}},
+				NewOrderTransaction{Symbol: "BTC", Order: entity.Order{
+					Amount: 1250, ID: 2, Side: entity.Sell, User: 1, Symbol: "BTC", Type: entity.Market,
+					StopPrice: 10050, Timestamp: now,
+				}},
+				ReplaceOrderTransaction{User: 1, OrderID: 1, Price: 10150, Amount: 250},
+			},
+		},
+		{
+			name:  "cancel, replace, session and flush",
+			lines: []string{"C, 1, 2", "R, 1, 2, 11, 50", "M, 1, 2, 12, 40", "X, HALTED", "X, AUCTION, IBM", "F"},
+			wantTransactions: []Transaction{
+				CancelOrderTransaction{User: 1, OrderID: 2},
+				ReplaceOrderTransaction{User: 1, OrderID: 2, Price: 11, Amount: 50},
+				ReplaceOrderTransaction{User: 1, OrderID: 2, Price: 12, Amount: 40},
+				SessionTransaction{Session: entity.Halted},
+				SessionTransaction{Symbol: "IBM", Session: entity.Auction},
+				FlushAllOrdersTransaction{},
+			},
+		},
+		{
+			name:    "short create order",
+			lines:   []string{"N, 1, IBM, 10, 100, B"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid price",
+			lines:   []string{"N, 1, IBM, ten, 100, B, 1"},
+			wantErr: true,
+		},
+		{
+			name:    "negative amount",
+			lines:   []string{"N, 1, IBM, 10, -100, B, 1"},
+			wantErr: true,
+		},
+		{
+			name:    "more decimal places than the scale",
+			lines:   []string{"N, 1, BTC, 101.255, 1, B, 1"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown instruction",
+			lines:   []string{"N, 1, IBM, 10, 100, B, 1, GTD"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid display amount",
+			lines:   []string{"N, 1, IBM, 10, 100, B, 1, DISPLAY=ten"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid expiry",
+			lines:   []string{"N, 1, IBM, 10, 100, B, 1, EXPIRE=tomorrow"},
+			wantErr: true,
+		},
+		{
+			name:    "stop order without a stop price",
+			lines:   []string{"S, 1, IBM, 0, 10, 100, B, 1"},
+			wantErr: true,
+		},
+		{
+			name:    "short stop order",
+			lines:   []string{"S, 1, IBM, 12, 10, 100, B"},
+			wantErr: true,
+		},
+		{
+			name:    "cancel with extra columns",
+			lines:   []string{"C, 1, 2, 3"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid order id in cancel",
+			lines:   []string{"C, 1, two"},
+			wantErr: true,
+		},
+		{
+			name:    "short replace",
+			lines:   []string{"R, 1, 2, 11"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid amount in replace",
+			lines:   []string{"M, 1, 2, 11, fifty"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown session",
+			lines:   []string{"X, OPEN"},
+			wantErr: true,
+		},
+		{
+			name:    "session with extra columns",
+			lines:   []string{"X, HALTED, IBM, 1"},
+			wantErr: true,
+		},
+		{
+			name:  "unknown line",
+			lines: []string{"N, 1, IBM, 10, 100, B, 1", "Q, 1"},
+			wantTransactions: []Transaction{
+				NewOrderTransaction{Symbol: "IBM", Order: entity.Order{
+					Amount: 100, Price: 10, ID: 1, Side: entity.Buy, User: 1, Symbol: "IBM", Timestamp: now,
+				}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fileName := filepath.Join(t.TempDir(), "input.csv")
+			if err := os.WriteFile(fileName, []byte(strings.Join(tt.lines, "\n")+"\n"), 0o600); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+			clock := entity.NewManualClock(now)
+			transactions, err := ReadTransactions(context.Background(), fileName, clock, instruments)
+			if err != nil {
+				t.Fatalf("ReadTransactions() error = %v", err)
+			}
+
+			var gotTransactions []Transaction
+			gotErr := false
+			for transaction := range transactions {
+				if _, ok := transaction.(ErrorTransaction); ok {
+					gotErr = true
+					continue
+				}
+				gotTransactions = append(gotTransactions, transaction)
+			}
+			if gotErr != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", gotErr, tt.wantErr)
+			}
+			if !reflect.DeepEqual(gotTransactions, tt.wantTransactions) {
+				t.Errorf("transactions: %v, want: %v", gotTransactions, tt.wantTransactions)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotVersion is the version of the snapshot format written, snapshots of other versions are not read.
+// Version 2 added the sequence numbers of the events.
+const SnapshotVersion = 2
+
+// snapshotMagic starts every snapshot, so other files are not taken for one.
+var snapshotMagic = [4]byte{'O', 'B', 'K', 'S'}
+
+// snapshotHeader is written before the state, the CRC covers the state.
+type snapshotHeader struct {
+	Magic    [4]byte
+	Version  uint32
+	Sequence uint64
+	Length   uint64
+	CRC      uint32
+}
+
+// WriteSnapshot writes the state of an engine, with the sequence number of the last journal entry it includes.
+func WriteSnapshot(writer io.Writer, sequence uint64, state any) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrapf(err, "problem encoding snapshot")
+	}
+	header := snapshotHeader{
+		Magic:    snapshotMagic,
+		Version:  SnapshotVersion,
+		Sequence: sequence,
+		Length:   uint64(len(payload)),
+		CRC:      crc32.ChecksumIEEE(payload),
+	}
+	if err = binary.Write(writer, binary.LittleEndian, header); err != nil {
+		return errors.Wrapf(err, "problem writing snapshot")
+	}
+	if _, err = writer.Write(payload); err != nil {
+		return errors.Wrapf(err, "problem writing snapshot")
+	}
+	return nil
+}
+
+// ReadSnapshot reads the state written by WriteSnapshot, giving the sequence number of the last journal entry it
+// includes.
+func ReadSnapshot(reader io.Reader, state any) (uint64, error) {
+	var header snapshotHeader
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return 0, errors.Wrapf(err, "problem reading snapshot")
+	}
+	if header.Magic != snapshotMagic {
+		return 0, fmt.Errorf("not a snapshot")
+	}
+	if header.Version != SnapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version: %v", header.Version)
+	}
+	var payload bytes.Buffer
+	if n, err := io.CopyN(&payload, reader, int64(header.Length)); err != nil {
+		return 0, errors.Wrapf(err, "problem reading snapshot, got %v of %v bytes", n, header.Length)
+	}
+	if crc32.ChecksumIEEE(payload.Bytes()) != header.CRC {
+		return 0, fmt.Errorf("corrupted snapshot")
+	}
+	if err := json.Unmarshal(payload.Bytes(), state); err != nil {
+		return 0, errors.Wrapf(err, "problem decoding snapshot")
+	}
+	return header.Sequence, nil
+}
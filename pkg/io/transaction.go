@@ -20,6 +20,43 @@ type CancelOrderTransaction struct {
 	OrderID entity.OrderID
 }
 
+// Key gives the order to cancel, ids are given by the users so they are only unique for the same user.
+func (t CancelOrderTransaction) Key() entity.OrderKey {
+	return entity.OrderKey{
+		User: t.User,
+		ID:   t.OrderID,
+	}
+}
+
+// ReplaceOrderTransaction changes the price and the remaining amount of an order.
+type ReplaceOrderTransaction struct {
+	Transaction
+	User    entity.UserID
+	OrderID entity.OrderID
+	Price   entity.Decimal
+	Amount  entity.Decimal
+}
+
+// Key gives the order to replace.
+func (t ReplaceOrderTransaction) Key() entity.OrderKey {
+	return entity.OrderKey{
+		User: t.User,
+		ID:   t.OrderID,
+	}
+}
+
+// SessionTransaction moves the book of the symbol to another session state, every book when the symbol is empty.
+type SessionTransaction struct {
+	Transaction
+	Symbol  string
+	Session entity.SessionState
+}
+
+// ExpireOrdersTransaction cancels the good till time orders whose deadline was reached by the clock of the engine.
+type ExpireOrdersTransaction struct {
+	Transaction
+}
+
 type FlushAllOrdersTransaction struct {
 	Transaction
 }
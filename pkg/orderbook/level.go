@@ -3,7 +3,8 @@ package orderbook
 import "github.com/rodoufu/simple-orderbook/pkg/entity"
 
 type BookLevel struct {
-	Side          entity.Side
-	Price         uint64
-	TotalQuantity uint64
+	Side  entity.Side
+	Price entity.Decimal
+	// TotalQuantity only counts the visible slice of iceberg orders.
+	TotalQuantity entity.Decimal
 }
@@ -10,12 +10,154 @@ import (
 )
 
 var (
-	notStartedError = fmt.Errorf("orderbook not started or does not exist")
+	notStartedError       = fmt.Errorf("orderbook not started or does not exist")
+	unsequencedEventError = fmt.Errorf("event without sequence number")
+	// StaleEventError is returned for the events already applied, they do not change the book.
+	StaleEventError = fmt.Errorf("stale event")
+	// ResyncNeededError is returned once the book missed an event or could not apply one, it ignores the events until
+	// it is resynced.
+	ResyncNeededError = fmt.Errorf("order book needs a resync")
 )
 
 type listOrderBook struct {
 	mtx    map[entity.Side]*sync.RWMutex
 	orders map[entity.Side][]entity.Order
+
+	sessionMtx sync.RWMutex
+	session    entity.SessionState
+
+	// eventMtx keeps the events applied one at a time, in the order of their sequence numbers.
+	eventMtx sync.Mutex
+	// sequence is the sequence number of the last event applied.
+	sequence    uint64
+	needsResync bool
+	// depthSubscribers receive the depth updates, they are guarded by eventMtx.
+	depthSubscribers map[chan event.DepthUpdate]struct{}
+}
+
+// depthBufferSize is how many depth updates a subscriber can fall behind before its channel is closed.
+const depthBufferSize = 100
+
+func (l *listOrderBook) Sequence(ctx context.Context) uint64 {
+	l.eventMtx.Lock()
+	defer l.eventMtx.Unlock()
+	return l.sequence
+}
+
+func (l *listOrderBook) NeedsResync(ctx context.Context) bool {
+	l.eventMtx.Lock()
+	defer l.eventMtx.Unlock()
+	return l.needsResync
+}
+
+func (l *listOrderBook) Resync(
+	ctx context.Context, sequence uint64, session entity.SessionState, orders []entity.Order,
+) error {
+	if l == nil {
+		return notStartedError
+	}
+	l.eventMtx.Lock()
+	defer l.eventMtx.Unlock()
+
+	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
+		l.mtx[side].Lock()
+		l.orders[side] = []entity.Order{}
+		l.mtx[side].Unlock()
+	}
+	for _, order := range orders {
+		if err := l.addOrder(ctx, order); err != nil {
+			l.needsResync = true
+			return err
+		}
+	}
+	l.sessionMtx.Lock()
+	l.session = session
+	l.sessionMtx.Unlock()
+	l.sequence = sequence
+	l.needsResync = false
+	// The depth of the subscribers may not match the new orders.
+	l.closeDepthSubscribers()
+	return nil
+}
+
+func (l *listOrderBook) SubscribeDepth(ctx context.Context) (DepthSnapshot, <-chan event.DepthUpdate) {
+	updates := make(chan event.DepthUpdate, depthBufferSize)
+	if l == nil {
+		close(updates)
+		return DepthSnapshot{}, updates
+	}
+	l.eventMtx.Lock()
+	defer l.eventMtx.Unlock()
+
+	// No event is applied while the snapshot is taken, so the updates start right after it.
+	snapshot := DepthSnapshot{
+		Sequence: l.sequence,
+		Bids:     l.depth(entity.Buy),
+		Asks:     l.depth(entity.Sell),
+	}
+	if l.needsResync {
+		close(updates)
+		return snapshot, updates
+	}
+	if l.depthSubscribers == nil {
+		l.depthSubscribers = map[chan event.DepthUpdate]struct{}{}
+	}
+	l.depthSubscribers[updates] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		l.eventMtx.Lock()
+		defer l.eventMtx.Unlock()
+		if _, ok := l.depthSubscribers[updates]; ok {
+			delete(l.depthSubscribers, updates)
+			close(updates)
+		}
+	}()
+	return snapshot, updates
+}
+
+// depth gives the levels of the side from the best price.
+func (l *listOrderBook) depth(side entity.Side) []BookLevel {
+	l.mtx[side].RLock()
+	defer l.mtx[side].RUnlock()
+
+	var resp []BookLevel
+	for i := len(l.orders[side]) - 1; i >= 0; i-- {
+		order := l.orders[side][i]
+		if len(resp) == 0 || resp[len(resp)-1].Price != order.Price {
+			resp = append(resp, BookLevel{
+				Side:  side,
+				Price: order.Price,
+			})
+		}
+		resp[len(resp)-1].TotalQuantity += order.Amount
+	}
+	return resp
+}
+
+// publishDepth sends the update to the subscribers, the ones that fell behind are dropped.
+func (l *listOrderBook) publishDepth(update event.DepthUpdate) {
+	for subscriber := range l.depthSubscribers {
+		select {
+		case subscriber <- update:
+		default:
+			delete(l.depthSubscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+func (l *listOrderBook) closeDepthSubscribers() {
+	for subscriber := range l.depthSubscribers {
+		delete(l.depthSubscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+func (l *listOrderBook) Session(ctx context.Context) entity.SessionState {
+	l.sessionMtx.RLock()
+	defer l.sessionMtx.RUnlock()
+	return l.session
 }
 
 func (l *listOrderBook) TopBid(ctx context.Context) *BookLevel {
@@ -58,21 +200,62 @@ func (l *listOrderBook) ProcessEvent(ctx context.Context, evt event.Event) error
 	if l == nil {
 		return notStartedError
 	}
+	l.eventMtx.Lock()
+	defer l.eventMtx.Unlock()
+
+	sequence := evt.BookSequence()
+	switch {
+	case sequence == 0:
+		return unsequencedEventError
+	case sequence <= l.sequence:
+		return fmt.Errorf("%w: event %v already applied, book at %v", StaleEventError, sequence, l.sequence)
+	case l.needsResync:
+		return ResyncNeededError
+	case sequence > l.sequence+1:
+		l.needsResync = true
+		l.closeDepthSubscribers()
+		return fmt.Errorf("%w: missed the events from %v to %v", ResyncNeededError, l.sequence+1, sequence-1)
+	}
+	if err := l.applyEvent(ctx, evt); err != nil {
+		l.needsResync = true
+		l.closeDepthSubscribers()
+		return fmt.Errorf("%w: %v", ResyncNeededError, err)
+	}
+	l.sequence = sequence
+	return nil
+}
 
+// applyEvent changes the book with an event in sequence.
+func (l *listOrderBook) applyEvent(ctx context.Context, evt event.Event) error {
 	switch it := evt.(type) {
-	case *event.TradeGenerated:
+	case *event.TradeGenerated, *event.TopOfBookChange, *event.OrderAcknowledge, *event.OrderRejected, *event.RequestRejected,
+		*event.OrderRepriced, *event.StopAccepted, *event.StopTriggered, *event.StopCancelled, *event.AuctionIndicative,
+		*event.AuctionUncrossed:
+	case *event.DepthUpdate:
+		l.publishDepth(*it)
+	case *event.SessionChanged:
+		l.sessionMtx.Lock()
+		defer l.sessionMtx.Unlock()
+		l.session = it.Session
 	case *event.OrderCancelled:
-		return l.cancelOrder(ctx, it.Order.ID, it.Order.Side)
+		// Orders cancelled before resting, like the leftover of an immediate or cancel order, were never in the book.
+		if _, ok := l.Order(ctx, it.Order.Key()); !ok {
+			return nil
+		}
+		return l.cancelOrder(ctx, it.Order.Key(), it.Order.Side)
 	case *event.OrderCreated:
 		return l.addOrder(ctx, it.Order)
 	case *event.OrderUpdated:
 		return l.updateOrder(ctx, it.Order)
 	case *event.OrderFilled:
 		if it.Full {
-			return l.cancelOrder(ctx, it.Order.ID, it.Order.Side)
-		} else {
-			return l.updateOrder(ctx, it.Order)
+			return l.cancelOrder(ctx, it.Order.Key(), it.Order.Side)
+		}
+		// Fills only take the hidden amount to show the next slice of an iceberg, which loses its time priority.
+		if current, ok := l.Order(ctx, it.Order.Key()); ok && it.Order.HiddenAmount < current.HiddenAmount {
+			return l.requeueOrder(ctx, it.Order)
 		}
+		return l.updateOrder(ctx, it.Order)
 	default:
 		return fmt.Errorf("unexpected event: %v", evt)
 	}
@@ -118,6 +301,100 @@ func (l *listOrderBook) getLevel(ctx context.Context, side entity.Side) <-chan B
 
 }
 
+func (l *listOrderBook) getOrders(ctx context.Context, side entity.Side) <-chan entity.Order {
+	resp := make(chan entity.Order)
+	if l == nil {
+		close(resp)
+		return resp
+	}
+	l.mtx[side].RLock()
+
+	go func() {
+		defer l.mtx[side].RUnlock()
+		defer close(resp)
+		done := ctx.Done()
+
+		// The levels are sent from the best price, but the queue of each level starts at its lowest index.
+		for last := len(l.orders[side]) - 1; last >= 0; {
+			first := l.levelStart(side, last)
+			for _, order := range l.orders[side][first : last+1] {
+				select {
+				case <-done:
+					return
+				case resp <- order:
+				}
+			}
+			last = first - 1
+		}
+	}()
+
+	return resp
+}
+
+// levelStart gives the index of the first order in the queue of the level with the order at the index, the side has
+// to be locked.
+func (l *listOrderBook) levelStart(side entity.Side, index int) int {
+	sideOrders := l.orders[side]
+	first := index
+	for first > 0 && sideOrders[first-1].Price == sideOrders[index].Price {
+		first--
+	}
+	return first
+}
+
+func (l *listOrderBook) BidOrders(ctx context.Context) <-chan entity.Order {
+	return l.getOrders(ctx, entity.Buy)
+}
+
+func (l *listOrderBook) AskOrders(ctx context.Context) <-chan entity.Order {
+	return l.getOrders(ctx, entity.Sell)
+}
+
+func (l *listOrderBook) OrdersAt(ctx context.Context, side entity.Side, price entity.Decimal) []entity.Order {
+	if l == nil {
+		return nil
+	}
+	l.mtx[side].RLock()
+	defer l.mtx[side].RUnlock()
+
+	var resp []entity.Order
+	for _, order := range l.orders[side] {
+		if order.Price == price {
+			resp = append(resp, order)
+		}
+	}
+	return resp
+}
+
+func (l *listOrderBook) Order(ctx context.Context, key entity.OrderKey) (entity.Order, bool) {
+	if l == nil {
+		return entity.Order{}, false
+	}
+	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
+		l.mtx[side].RLock()
+		for _, order := range l.orders[side] {
+			if order.Key() == key {
+				l.mtx[side].RUnlock()
+				return order, true
+			}
+		}
+		l.mtx[side].RUnlock()
+	}
+	return entity.Order{}, false
+}
+
+func (l *listOrderBook) UserOrders(ctx context.Context, user entity.UserID) []entity.Order {
+	var resp []entity.Order
+	for _, side := range []entity.Side{entity.Buy, entity.Sell} {
+		for order := range l.getOrders(ctx, side) {
+			if order.User == user {
+				resp = append(resp, order)
+			}
+		}
+	}
+	return resp
+}
+
 func (l *listOrderBook) Bids(ctx context.Context) <-chan BookLevel {
 	return l.getLevel(ctx, entity.Buy)
 
@@ -127,7 +404,7 @@ func (l *listOrderBook) Asks(ctx context.Context) <-chan BookLevel {
 	return l.getLevel(ctx, entity.Sell)
 }
 
-func (l *listOrderBook) cancelOrder(ctx context.Context, orderID entity.OrderID, side entity.Side) error {
+func (l *listOrderBook) cancelOrder(ctx context.Context, key entity.OrderKey, side entity.Side) error {
 	if l == nil {
 		return notStartedError
 	}
@@ -136,10 +413,10 @@ func (l *listOrderBook) cancelOrder(ctx context.Context, orderID entity.OrderID,
 
 	sideOrders := l.orders[side]
 	index := len(sideOrders) - 1
-	for index >= 0 && sideOrders[index].ID != orderID {
+	for index >= 0 && sideOrders[index].Key() != key {
 		index--
 	}
-	if index >= 0 && sideOrders[index].ID == orderID {
+	if index >= 0 && sideOrders[index].Key() == key {
 		copy(sideOrders[index:], sideOrders[index+1:])
 		sideOrders = sideOrders[:len(sideOrders)-1]
 		l.orders[side] = sideOrders
@@ -147,9 +424,11 @@ func (l *listOrderBook) cancelOrder(ctx context.Context, orderID entity.OrderID,
 		return nil
 	}
 
-	return fmt.Errorf("order %v not found", orderID)
+	return fmt.Errorf("order %v of user %v not found", key.ID, key.User)
 }
 
+// addOrder puts the order at the back of the queue of its price whatever its timestamp, like the engine, so a
+// triggered stop waits behind the orders that arrived before it was triggered.
 func (l *listOrderBook) addOrder(ctx context.Context, order entity.Order) error {
 	if l == nil {
 		return notStartedError
@@ -158,17 +437,34 @@ func (l *listOrderBook) addOrder(ctx context.Context, order entity.Order) error
 	defer l.mtx[order.Side].Unlock()
 
 	sideOrders := l.orders[order.Side]
-	sideOrders = append(sideOrders, order)
-
-	for i := len(sideOrders) - 1; i >= 1 && sideOrders[i].Less(&sideOrders[i-1]); i-- {
-		sideOrders[i], sideOrders[i-1] = sideOrders[i-1], sideOrders[i]
+	index := len(sideOrders)
+	for index > 0 && betterPrice(order.Side, sideOrders[index-1].Price, order.Price) {
+		index--
 	}
-
+	sideOrders = append(sideOrders, entity.Order{})
+	copy(sideOrders[index+1:], sideOrders[index:])
+	sideOrders[index] = order
 	l.orders[order.Side] = sideOrders
 
 	return nil
 }
 
+// requeueOrder moves the order to the back of the queue of its price.
+func (l *listOrderBook) requeueOrder(ctx context.Context, order entity.Order) error {
+	if err := l.cancelOrder(ctx, order.Key(), order.Side); err != nil {
+		return err
+	}
+	return l.addOrder(ctx, order)
+}
+
+// betterPrice checks if the price is better than the other one for the side.
+func betterPrice(side entity.Side, price, other entity.Decimal) bool {
+	if side == entity.Buy {
+		return price > other
+	}
+	return price < other
+}
+
 func (l *listOrderBook) updateOrder(ctx context.Context, order entity.Order) error {
 	if l == nil {
 		return notStartedError
@@ -179,7 +475,7 @@ func (l *listOrderBook) updateOrder(ctx context.Context, order entity.Order) err
 	sideOrders := l.orders[order.Side]
 	found := false
 	for i, it := range sideOrders {
-		if order.ID == it.ID {
+		if order.Key() == it.Key() {
 			sideOrders[i] = order
 			found = true
 		}
@@ -2,7 +2,9 @@ package orderbook
 
 import (
 	"context"
+	"errors"
 	"github.com/rodoufu/simple-orderbook/pkg/entity"
+	"github.com/rodoufu/simple-orderbook/pkg/event"
 	"reflect"
 	"sync"
 	"testing"
@@ -181,6 +183,43 @@ func Test_listOrderBook_Bids(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "iceberg order only counts the visible slice",
+			orderBook: &listOrderBook{
+				mtx: map[entity.Side]*sync.RWMutex{
+					entity.Buy:  {},
+					entity.Sell: {},
+				},
+				orders: map[entity.Side][]entity.Order{
+					entity.Buy: {
+						{
+							Amount:        10,
+							Price:         10,
+							ID:            1,
+							Side:          entity.Buy,
+							User:          1,
+							DisplayAmount: 10,
+							HiddenAmount:  90,
+						},
+						{
+							Amount: 10,
+							Price:  10,
+							ID:     2,
+							Side:   entity.Buy,
+							User:   2,
+						},
+					},
+				},
+			},
+			args: args{ctx: context.Background()},
+			want: []BookLevel{
+				{
+					Side:          entity.Buy,
+					Price:         10,
+					TotalQuantity: 20,
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -192,3 +231,181 @@ func Test_listOrderBook_Bids(t *testing.T) {
 		})
 	}
 }
+
+func Test_listOrderBook_ProcessEvent_sequence(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	book := NewListOrderBook()
+	sequenced := func(sequence uint64, evt event.Event) event.Event {
+		evt.SetBookSequence(sequence)
+		return evt
+	}
+	order := entity.Order{Amount: 10, Price: 10, ID: 1, Side: entity.Buy, User: 1}
+	other := entity.Order{Amount: 5, Price: 11, ID: 2, Side: entity.Buy, User: 2}
+
+	if err := book.ProcessEvent(ctx, sequenced(1, &event.OrderCreated{Order: order})); err != nil {
+		t.Fatalf("ProcessEvent(1) error = %v", err)
+	}
+	// Applying the same event again is rejected and does not change the book.
+	err := book.ProcessEvent(ctx, sequenced(1, &event.OrderCreated{Order: order}))
+	if !errors.Is(err, StaleEventError) {
+		t.Errorf("ProcessEvent(1) again error = %v, want %v", err, StaleEventError)
+	}
+	if err := book.ProcessEvent(ctx, &event.OrderCreated{Order: other}); err == nil {
+		t.Errorf("ProcessEvent() without sequence expected error")
+	}
+	wantLevels := []BookLevel{{Side: entity.Buy, Price: 10, TotalQuantity: 10}}
+	if got := toListBookLevel(ctx, book.Bids(ctx)); !reflect.DeepEqual(got, wantLevels) {
+		t.Errorf("Bids() = %v, want %v", got, wantLevels)
+	}
+
+	// Missing the event 2 stops the book until it is resynced.
+	err = book.ProcessEvent(ctx, sequenced(3, &event.OrderCreated{Order: other}))
+	if !errors.Is(err, ResyncNeededError) {
+		t.Errorf("ProcessEvent(3) error = %v, want %v", err, ResyncNeededError)
+	}
+	if !book.NeedsResync(ctx) {
+		t.Errorf("NeedsResync() = false after a gap")
+	}
+	err = book.ProcessEvent(ctx, sequenced(2, &event.OrderCreated{Order: other}))
+	if !errors.Is(err, ResyncNeededError) {
+		t.Errorf("ProcessEvent(2) error = %v, want %v", err, ResyncNeededError)
+	}
+	if got := book.Sequence(ctx); got != 1 {
+		t.Errorf("Sequence() = %v, want 1", got)
+	}
+
+	if err := book.Resync(ctx, 3, entity.Continuous, []entity.Order{order, other}); err != nil {
+		t.Fatalf("Resync() error = %v", err)
+	}
+	err = book.ProcessEvent(ctx, sequenced(3, &event.OrderCreated{Order: other}))
+	if !errors.Is(err, StaleEventError) {
+		t.Errorf("ProcessEvent(3) after Resync() error = %v, want %v", err, StaleEventError)
+	}
+	cancelled := sequenced(4, &event.OrderCancelled{Order: order})
+	if err := book.ProcessEvent(ctx, cancelled); err != nil || book.NeedsResync(ctx) {
+		t.Errorf("ProcessEvent(4) error = %v, NeedsResync() = %v", err, book.NeedsResync(ctx))
+	}
+	wantLevels = []BookLevel{{Side: entity.Buy, Price: 11, TotalQuantity: 5}}
+	if got := toListBookLevel(ctx, book.Bids(ctx)); !reflect.DeepEqual(got, wantLevels) {
+		t.Errorf("Bids() = %v, want %v", got, wantLevels)
+	}
+}
+
+func Test_listOrderBook_SubscribeDepth(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	book := NewListOrderBook()
+	sequenced := func(sequence uint64, evt event.Event) event.Event {
+		evt.SetBookSequence(sequence)
+		return evt
+	}
+	order := entity.Order{Amount: 10, Price: 10, ID: 1, Side: entity.Buy, User: 1}
+	if err := book.ProcessEvent(ctx, sequenced(1, &event.OrderCreated{Order: order})); err != nil {
+		t.Fatalf("ProcessEvent(1) error = %v", err)
+	}
+	newLevel := &event.DepthUpdate{Side: entity.Buy, Action: event.DepthNew, Price: 10, TotalQuantity: 10}
+	if err := book.ProcessEvent(ctx, sequenced(2, newLevel)); err != nil {
+		t.Fatalf("ProcessEvent(2) error = %v", err)
+	}
+
+	snapshot, updates := book.SubscribeDepth(ctx)
+	wantSnapshot := DepthSnapshot{Sequence: 2, Bids: []BookLevel{{Side: entity.Buy, Price: 10, TotalQuantity: 10}}}
+	if !reflect.DeepEqual(snapshot, wantSnapshot) {
+		t.Errorf("SubscribeDepth() = %+v, want %+v", snapshot, wantSnapshot)
+	}
+	cancelledCtx, cancelSubscriber := context.WithCancel(ctx)
+	_, cancelled := book.SubscribeDepth(cancelledCtx)
+	cancelSubscriber()
+	if _, ok := <-cancelled; ok {
+		t.Errorf("SubscribeDepth() channel open after the context is done")
+	}
+
+	changed := &event.DepthUpdate{Side: entity.Buy, Action: event.DepthChange, Price: 10, TotalQuantity: 4}
+	if err := book.ProcessEvent(ctx, sequenced(3, changed)); err != nil {
+		t.Fatalf("ProcessEvent(3) error = %v", err)
+	}
+	if got := <-updates; got.BookSequence() != 3 || got.TotalQuantity != 4 {
+		t.Errorf("update = %+v, want the change of the event 3", got)
+	}
+
+	// A missing event closes the updates, since the subscriber can no longer follow the book.
+	_ = book.ProcessEvent(ctx, sequenced(5, changed))
+	if _, ok := <-updates; ok {
+		t.Errorf("SubscribeDepth() channel open after a gap")
+	}
+	_, closed := book.SubscribeDepth(ctx)
+	if _, ok := <-closed; ok {
+		t.Errorf("SubscribeDepth() channel open while the book needs a resync")
+	}
+}
+
+func Test_listOrderBook_orders(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	book := NewListOrderBook()
+	var sequence uint64
+	apply := func(evt event.Event) {
+		sequence++
+		evt.SetBookSequence(sequence)
+		if err := book.ProcessEvent(ctx, evt); err != nil {
+			t.Fatalf("ProcessEvent(%v) error = %v", sequence, err)
+		}
+	}
+	iceberg := entity.Order{Amount: 2, Price: 10, ID: 1, Side: entity.Buy, User: 1, DisplayAmount: 2, HiddenAmount: 6}
+	second := entity.Order{Amount: 5, Price: 10, ID: 2, Side: entity.Buy, User: 2}
+	third := entity.Order{Amount: 3, Price: 10, ID: 3, Side: entity.Buy, User: 1}
+	better := entity.Order{Amount: 1, Price: 11, ID: 4, Side: entity.Buy, User: 2}
+	ask := entity.Order{Amount: 4, Price: 12, ID: 5, Side: entity.Sell, User: 1}
+	for _, order := range []entity.Order{iceberg, second, third, better, ask} {
+		apply(&event.OrderCreated{Order: order})
+	}
+	// The next slice of the iceberg goes to the back of the queue.
+	refilled := iceberg
+	refilled.HiddenAmount -= refilled.Amount
+	apply(&event.OrderFilled{Order: refilled})
+	partial := second
+	partial.Amount = 1
+	apply(&event.OrderFilled{Order: partial})
+
+	wantQueue := []entity.Order{partial, third, refilled}
+	if got := book.OrdersAt(ctx, entity.Buy, 10); !reflect.DeepEqual(got, wantQueue) {
+		t.Errorf("OrdersAt(Buy, 10) = %v, want %v", got, wantQueue)
+	}
+	if got := book.OrdersAt(ctx, entity.Sell, 10); len(got) != 0 {
+		t.Errorf("OrdersAt(Sell, 10) = %v, want none", got)
+	}
+
+	if got, ok := book.Order(ctx, ask.Key()); !ok || !reflect.DeepEqual(got, ask) {
+		t.Errorf("Order(%v) = %v, %v, want %v", ask.Key(), got, ok, ask)
+	}
+	if got, ok := book.Order(ctx, entity.OrderKey{User: 2, ID: 1}); ok {
+		t.Errorf("Order() of another user = %v, want not found", got)
+	}
+
+	wantUser := []entity.Order{third, refilled, ask}
+	if got := book.UserOrders(ctx, 1); !reflect.DeepEqual(got, wantUser) {
+		t.Errorf("UserOrders(1) = %v, want %v", got, wantUser)
+	}
+
+	var bids []entity.Order
+	for order := range book.BidOrders(ctx) {
+		bids = append(bids, order)
+	}
+	wantBids := append([]entity.Order{better}, wantQueue...)
+	if !reflect.DeepEqual(bids, wantBids) {
+		t.Errorf("BidOrders() = %v, want %v", bids, wantBids)
+	}
+
+	// The stream stops when the context is done, releasing the book.
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	asks := book.AskOrders(cancelledCtx)
+	cancel()
+	for range asks {
+	}
+	apply(&event.OrderCancelled{Order: ask})
+	if got := book.TopAsk(ctx); got != nil {
+		t.Errorf("TopAsk() = %v, want nil", got)
+	}
+}
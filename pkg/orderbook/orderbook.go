@@ -3,13 +3,24 @@ package orderbook
 import (
 	"context"
 
+	"github.com/rodoufu/simple-orderbook/pkg/entity"
 	"github.com/rodoufu/simple-orderbook/pkg/event"
 )
 
 // OrderBook models another service that would implement this functions.
 type OrderBook interface {
 	// ProcessEvent process the events produced by the MatchingEngine so this part can be consistent.
+	// The events have to arrive in the order of their sequence numbers, events already applied are rejected with
+	// StaleEventError without changing the book, and a missing event makes the book return ResyncNeededError until
+	// Resync is called.
 	ProcessEvent(ctx context.Context, event event.Event) error
+	// Sequence gives the sequence number of the last event applied to the book.
+	Sequence(ctx context.Context) uint64
+	// NeedsResync tells the book missed an event or could not apply one, so it no longer follows the engine.
+	NeedsResync(ctx context.Context) bool
+	// Resync replaces the orders and the session of the book with the state of the engine right after the event with
+	// the sequence number, the following events are applied on top of it.
+	Resync(ctx context.Context, sequence uint64, session entity.SessionState, orders []entity.Order) error
 
 	// Bids returns the buy orders for the book.
 	Bids(ctx context.Context) <-chan BookLevel
@@ -19,4 +30,28 @@ type OrderBook interface {
 	TopBid(ctx context.Context) *BookLevel
 	// TopAsk gives the top sell order.
 	TopAsk(ctx context.Context) *BookLevel
+	// BidOrders returns every buy order of the book, from the best price and in queue order within a price.
+	BidOrders(ctx context.Context) <-chan entity.Order
+	// AskOrders returns every sell order of the book, from the best price and in queue order within a price.
+	AskOrders(ctx context.Context) <-chan entity.Order
+	// OrdersAt gives the orders of the side at the price in queue order.
+	OrdersAt(ctx context.Context, side entity.Side, price entity.Decimal) []entity.Order
+	// Order gives the resting order with the key, false if it is not in the book.
+	Order(ctx context.Context, key entity.OrderKey) (entity.Order, bool)
+	// UserOrders gives the resting orders of the user, the buy orders first, in the order of the book.
+	UserOrders(ctx context.Context, user entity.UserID) []entity.Order
+	// Session gives the session state of the book.
+	Session(ctx context.Context) entity.SessionState
+	// SubscribeDepth gives the depth of the book right after the event with the sequence number of the snapshot,
+	// followed by the depth updates after it until the context is done.
+	// The channel is closed when the subscriber falls behind or the book needs a resync, the subscriber has to
+	// subscribe again to get a new snapshot.
+	SubscribeDepth(ctx context.Context) (DepthSnapshot, <-chan event.DepthUpdate)
+}
+
+// DepthSnapshot is the depth of the book after the event with the sequence number, from the best price of each side.
+type DepthSnapshot struct {
+	Sequence uint64
+	Bids     []BookLevel
+	Asks     []BookLevel
 }